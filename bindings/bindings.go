@@ -0,0 +1,159 @@
+// Package bindings holds the Go types and libraries of the demos, the Account
+// type of luac and the json and person libraries of luar, as engine Modules
+// which can be registered on every engine.
+package bindings
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+// Account is the userdata type of luac/main.go.
+type Account struct {
+	Balance int64
+}
+
+func (a *Account) String() string {
+	return fmt.Sprintf("account(balance=%d)", a.Balance)
+}
+
+// AccountType is the Account global:
+//
+//	local acc = Account.create(1000)
+//	acc:withdrawl(100)
+//	print(acc:balance())
+var AccountType = &engine.Type{Name: "Account"}
+
+func init() {
+	AccountType.Methods = map[string]engine.Function{
+		"create":     createAccount,
+		"balance":    accountBalance,
+		"withdrawl":  accountWithdrawl,
+		"__tostring": accountToString,
+		"__eq":       accountEq,
+	}
+	AccountType.Params = map[string][]string{
+		"create":    {"balance number"},
+		"balance":   {"self"},
+		"withdrawl": {"self", "amount number"},
+	}
+	AccountType.Results = map[string][]string{
+		"create":    {"Account"},
+		"balance":   {"number"},
+		"withdrawl": {},
+	}
+}
+
+func checkAccount(args []engine.Value, i int) (*Account, error) {
+	v, err := AccountType.Check(args, i)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Account), nil
+}
+
+func createAccount(args []engine.Value) ([]engine.Value, error) {
+	balance, err := engine.CheckNumber(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return []engine.Value{AccountType.New(&Account{Balance: int64(balance)})}, nil
+}
+
+func accountBalance(args []engine.Value) ([]engine.Value, error) {
+	account, err := checkAccount(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return []engine.Value{float64(account.Balance)}, nil
+}
+
+func accountWithdrawl(args []engine.Value) ([]engine.Value, error) {
+	account, err := checkAccount(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := engine.CheckNumber(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	account.Balance -= int64(amount)
+	return nil, nil
+}
+
+func accountToString(args []engine.Value) ([]engine.Value, error) {
+	account, err := checkAccount(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return []engine.Value{account.String()}, nil
+}
+
+func accountEq(args []engine.Value) ([]engine.Value, error) {
+	account1, err := checkAccount(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	account2, err := checkAccount(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return []engine.Value{account1.Balance == account2.Balance}, nil
+}
+
+// Person is the struct of luar/main.go.
+type Person struct {
+	Name string `lua:"name"`
+}
+
+func (p Person) String() string {
+	return fmt.Sprintf("Person{Name: %s}", p.Name)
+}
+
+// Accounts registers the Account type.
+var Accounts = &engine.Module{Types: []*engine.Type{AccountType}}
+
+// JSON is the json library: json.pretty(value) returns the indented JSON of a
+// value.
+var JSON = &engine.Module{
+	Name: "json",
+	Funcs: map[string]engine.Function{
+		"pretty": func(args []engine.Value) ([]engine.Value, error) {
+			var v engine.Value
+			if len(args) > 0 {
+				v = args[0]
+			}
+			data, err := json.MarshalIndent(engine.ToGo(v), "", "\t")
+			if err != nil {
+				return nil, err
+			}
+			return []engine.Value{string(data)}, nil
+		},
+	},
+	Params:  map[string][]string{"pretty": {"value any"}},
+	Results: map[string][]string{"pretty": {"string"}},
+}
+
+// People is the person library: person.new(name) returns a *Person, a luar
+// proxy on golua-luar and a table with a name field on the other engines.
+var People = &engine.Module{
+	Name: "person",
+	Funcs: map[string]engine.Function{
+		"new": func(args []engine.Value) ([]engine.Value, error) {
+			name, err := engine.CheckString(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return []engine.Value{&Person{Name: name}}, nil
+		},
+	},
+	Params:  map[string][]string{"new": {"name string"}},
+	Results: map[string][]string{"new": {"{name: string}"}},
+}
+
+// Modules returns all the modules of the package.
+func Modules() []*engine.Module {
+	return []*engine.Module{Accounts, JSON, People}
+}
@@ -0,0 +1,88 @@
+// Command luaapi writes the API of the states of luarun, the standard library
+// of an engine, the Account type and the json and person libraries, as JSON
+// for the tools, lualint -manifest included, or with -stubs as a Lua file of
+// EmmyLua annotations for the editors:
+//
+//	luaapi [-engine=golua] [-compat] [-stubs] [-o=file]
+//
+//	$ luaapi -stubs -o=api.lua
+//
+// The Lua language server reads the stubs of the workspace, or of the
+// workspace.library setting.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rickcrawford/go-lua-test/bindings"
+	"github.com/rickcrawford/go-lua-test/engine"
+	"github.com/rickcrawford/go-lua-test/manifest"
+)
+
+// Exit codes
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("luaapi", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	engineName := flags.String("engine", "golua", "Lua engine whose standard library is listed: "+strings.Join(engine.Names(), ", "))
+	compat := flags.Bool("compat", false, "list the compat functions of engine.Options.Compat")
+	stubs := flags.Bool("stubs", false, "write EmmyLua annotations instead of JSON")
+	output := flags.String("o", "", "file to write, the standard output by default")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: luaapi [flags]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	m, err := manifest.New(*engineName, engine.Options{Modules: bindings.Modules(), Compat: *compat})
+	if err != nil {
+		fmt.Fprintf(stderr, "luaapi: %v\n", err)
+		return exitError
+	}
+	if *output == "" {
+		err = write(stdout, m, *stubs)
+	} else {
+		var f *os.File
+		if f, err = os.Create(*output); err == nil {
+			err = write(f, m, *stubs)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "luaapi: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// write writes 'm' to 'w' as JSON, or as stubs.
+func write(w io.Writer, m *manifest.Manifest, stubs bool) error {
+	if stubs {
+		return m.Stubs(w)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(m)
+}
@@ -0,0 +1,94 @@
+// Command lualint checks Lua scripts, without running them, against the API
+// of the states of luarun: the standard library of an engine, the Account type
+// and the json and person libraries.
+//
+//	lualint [-engine=golua] [-compat] [-manifest=api.json] script.lua...
+//
+// With -manifest, the API is read from a manifest written by luaapi or
+// manifest.Manifest, for the scripts of other programs.
+//
+// The issues are written to the standard output, one per line:
+//
+//	$ lualint -engine=gopher-lua script.lua
+//	script.lua:3: unknown method 'withdraw', did you mean 'withdrawl'?
+//	script.lua:4: 'Account.balance' takes 1 argument, got 2
+//
+// The exit code is 0 without issues, 1 with issues, 2 when a script cannot be
+// read or for bad flags.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rickcrawford/go-lua-test/bindings"
+	"github.com/rickcrawford/go-lua-test/engine"
+	"github.com/rickcrawford/go-lua-test/lint"
+	"github.com/rickcrawford/go-lua-test/manifest"
+)
+
+// Exit codes
+const (
+	exitOK = iota
+	exitIssues
+	exitUsage
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("lualint", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	engineName := flags.String("engine", "golua", "Lua engine whose standard library the scripts use: "+strings.Join(engine.Names(), ", "))
+	compat := flags.Bool("compat", false, "the scripts run with the compat functions of engine.Options.Compat")
+	api := flags.String("manifest", "", "JSON manifest of the API, instead of -engine and -compat")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: lualint [flags] script.lua...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	m, err := readManifest(*api, *engineName, *compat)
+	if err != nil {
+		fmt.Fprintf(stderr, "lualint: %v\n", err)
+		return exitUsage
+	}
+	code := exitOK
+	for _, script := range flags.Args() {
+		issues, err := lint.File(m, script)
+		if err != nil {
+			fmt.Fprintf(stderr, "lualint: %v\n", err)
+			return exitUsage
+		}
+		for _, issue := range issues {
+			fmt.Fprintln(stdout, issue)
+			code = exitIssues
+		}
+	}
+	return code
+}
+
+// readManifest reads the manifest 'path', or returns the API of the engine
+// 'name' when it is empty.
+func readManifest(path, name string, compat bool) (*manifest.Manifest, error) {
+	if path == "" {
+		return manifest.New(name, engine.Options{Modules: bindings.Modules(), Compat: compat})
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return manifest.Read(f)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+func writeFile(t *testing.T, dir, name, code string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	clean := writeFile(t, dir, "clean.lua", "local acc = Account.create(1000)\nacc:withdrawl(100)\nprint(acc:balance())\n")
+	script := writeFile(t, dir, "script.lua", "local acc = Account.create(1000)\nacc:withdrawl(100)\nacc:withdraw(100)\nprint(Account.balance(acc, 1))\n")
+	api := writeFile(t, dir, "api.json", `{"globals": {"print": {"kind": "function"}}}`)
+	bad := writeFile(t, dir, "bad.json", "{")
+
+	tests := []struct {
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{args: []string{clean}, code: exitOK},
+		{args: []string{clean, script}, code: exitIssues, stdout: script + ":3: unknown method 'withdraw', did you mean 'withdrawl'?\n" +
+			script + ":4: 'Account.balance' takes 1 argument, got 2\n"},
+		{args: []string{"-manifest=" + api, clean}, code: exitIssues, stdout: "undefined global 'Account'"},
+
+		{args: nil, code: exitUsage, stderr: "usage: lualint"},
+		{args: []string{"-bad", clean}, code: exitUsage},
+		{args: []string{filepath.Join(dir, "missing.lua")}, code: exitUsage, stderr: "lualint: "},
+		{args: []string{"-manifest=" + bad, clean}, code: exitUsage, stderr: "lualint: manifest"},
+		{args: []string{"-engine=none", clean}, code: exitUsage, stderr: "unknown Lua engine"},
+	}
+	for _, name := range engine.Names() {
+		for _, tt := range tests {
+			args := tt.args
+			if len(args) > 0 && !strings.HasPrefix(args[0], "-engine") {
+				args = append([]string{"-engine=" + name}, args...)
+			}
+			var stdout, stderr bytes.Buffer
+			code := run(args, &stdout, &stderr)
+			if code != tt.code {
+				t.Errorf("%s: %v: exit code %d, want %d, stderr:\n%s", name, tt.args, code, tt.code, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.stdout) || (tt.stdout == "" && stdout.Len() > 0) {
+				t.Errorf("%s: %v: printed %q, want %q", name, tt.args, stdout.String(), tt.stdout)
+			}
+			if !strings.Contains(stderr.String(), tt.stderr) {
+				t.Errorf("%s: %v: stderr %q does not contain %q", name, tt.args, stderr.String(), tt.stderr)
+			}
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxHistory is the number of lines kept in the history file.
+const maxHistory = 1000
+
+var errInterrupted = errors.New("interrupted")
+
+// lineReader reads lines with editing, history and completion when the input
+// is a terminal, and plain lines otherwise.
+type lineReader struct {
+	in       *os.File
+	r        *bufio.Reader
+	w        io.Writer
+	complete func(line string) (int, []string)
+
+	history []string
+	// saved is the number of lines of the history read from its file.
+	saved int
+}
+
+func newLineReader(in *os.File, w io.Writer, complete func(line string) (int, []string)) *lineReader {
+	return &lineReader{in: in, r: bufio.NewReader(in), w: w, complete: complete}
+}
+
+func (r *lineReader) loadHistory(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			r.history = append(r.history, line)
+		}
+	}
+	r.saved = len(r.history)
+}
+
+// saveHistory appends the new lines to the history file, keeping its last
+// maxHistory lines.
+func (r *lineReader) saveHistory(path string) {
+	if len(r.history) == r.saved {
+		return
+	}
+	lines := r.history
+	if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+	}
+	os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+func (r *lineReader) addHistory(line string) {
+	if line == "" || len(r.history) > 0 && r.history[len(r.history)-1] == line {
+		return
+	}
+	r.history = append(r.history, line)
+}
+
+// readLine reads a line, returning errInterrupted on Ctrl-C and io.EOF on
+// Ctrl-D or at the end of the input.
+func (r *lineReader) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(r.in.Fd())
+	if err != nil {
+		// not a terminal
+		fmt.Fprint(r.w, prompt)
+		line, err := r.r.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	defer restore()
+	return r.edit(prompt)
+}
+
+// edit reads a line from the terminal in raw mode.
+func (r *lineReader) edit(prompt string) (string, error) {
+	var line []rune
+	pos := 0
+	hist := len(r.history)
+	current := ""
+	tabs := 0
+
+	redraw := func() {
+		fmt.Fprintf(r.w, "\r%s%s\x1b[K", prompt, string(line))
+		if n := len(line) - pos; n > 0 {
+			fmt.Fprintf(r.w, "\x1b[%dD", n)
+		}
+	}
+	setLine := func(s string) {
+		line = []rune(s)
+		pos = len(line)
+		redraw()
+	}
+	redraw()
+
+	for {
+		c, _, err := r.r.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		if c != '\t' {
+			tabs = 0
+		}
+		switch c {
+		case '\r', '\n':
+			fmt.Fprint(r.w, "\r\n")
+			return string(line), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(r.w, "^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Fprint(r.w, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+				redraw()
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+				redraw()
+			}
+		case 1: // Ctrl-A
+			pos = 0
+			redraw()
+		case 5: // Ctrl-E
+			pos = len(line)
+			redraw()
+		case 11: // Ctrl-K
+			line = line[:pos]
+			redraw()
+		case 21: // Ctrl-U
+			line = line[pos:]
+			pos = 0
+			redraw()
+		case '\t':
+			tabs++
+			start, candidates := r.complete(string(line[:pos]))
+			// positions are byte offsets in the line before the cursor
+			startRune := utf8.RuneCountInString(string(line[:pos])[:start])
+			word := string(line[startRune:pos])
+			prefix := commonPrefix(candidates)
+			switch {
+			case len(candidates) == 0:
+			case len(prefix) > len(word):
+				rest := []rune(prefix[len(word):])
+				line = append(line[:pos], append(rest, line[pos:]...)...)
+				pos += len(rest)
+				redraw()
+			case tabs > 1:
+				sort.Strings(candidates)
+				fmt.Fprintf(r.w, "\r\n%s\r\n", strings.Join(candidates, "  "))
+				redraw()
+			}
+		case 27: // escape sequences of the arrows, Home, End and Delete
+			seq := r.escape()
+			switch seq {
+			case "[A", "OA":
+				if hist > 0 {
+					if hist == len(r.history) {
+						current = string(line)
+					}
+					hist--
+					setLine(r.history[hist])
+				}
+			case "[B", "OB":
+				if hist < len(r.history) {
+					hist++
+					if hist == len(r.history) {
+						setLine(current)
+					} else {
+						setLine(r.history[hist])
+					}
+				}
+			case "[C", "OC":
+				if pos < len(line) {
+					pos++
+					redraw()
+				}
+			case "[D", "OD":
+				if pos > 0 {
+					pos--
+					redraw()
+				}
+			case "[H", "OH", "[1~":
+				pos = 0
+				redraw()
+			case "[F", "OF", "[4~":
+				pos = len(line)
+				redraw()
+			case "[3~":
+				if pos < len(line) {
+					line = append(line[:pos], line[pos+1:]...)
+					redraw()
+				}
+			}
+		default:
+			if c >= ' ' {
+				line = append(line[:pos], append([]rune{c}, line[pos:]...)...)
+				pos++
+				redraw()
+			}
+		}
+	}
+}
+
+// escape reads the rest of an escape sequence: '[' or 'O', then parameters
+// and a final letter or '~'.
+func (r *lineReader) escape() string {
+	var b strings.Builder
+	for i := 0; i < 8; i++ {
+		c, _, err := r.r.ReadRune()
+		if err != nil {
+			break
+		}
+		b.WriteRune(c)
+		if i > 0 && (c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c == '~') {
+			break
+		}
+	}
+	return b.String()
+}
+
+func commonPrefix(words []string) string {
+	if len(words) == 0 {
+		return ""
+	}
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,236 @@
+// Command luarepl is an interactive Lua prompt on any of the engines, with the
+// Account type and the json and person libraries registered.
+//
+//	luarepl -engine=gopher-lua [script.lua...]
+//
+// The scripts given as arguments run before the prompt. A line starting with
+// '=' prints the values of the expression that follows, as do the lines which
+// are expressions, and tables and Go values are pretty printed. Statements may
+// span several lines, the prompt changes to ">>" until they are complete.
+// On a terminal, Tab completes global names and the fields of tables and Go
+// values, Up and Down walk the history, saved in ~/.luarepl_history.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/rickcrawford/go-lua-test/bindings"
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+func main() {
+	engineName := flag.String("engine", "golua", "Lua engine: "+strings.Join(engine.Names(), ", "))
+	historyPath := flag.String("history", defaultHistoryPath(), "history file, none when empty")
+	flag.Parse()
+
+	e, err := engine.New(*engineName, engine.Options{Modules: bindings.Modules()})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer e.Close()
+
+	for _, path := range flag.Args() {
+		if err := e.DoFile(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fmt.Printf("Lua on %s, Ctrl-D to exit\n", e.Name())
+	r := newLineReader(os.Stdin, os.Stdout, func(line string) (int, []string) { return complete(e, line) })
+	if *historyPath != "" {
+		r.loadHistory(*historyPath)
+		defer r.saveHistory(*historyPath)
+	}
+	repl(e, r, os.Stdout)
+}
+
+func defaultHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".luarepl_history")
+}
+
+// repl reads and runs chunks until the end of the input.
+func repl(e engine.Engine, r *lineReader, w io.Writer) {
+	var chunk []string
+	for {
+		prompt := "> "
+		if len(chunk) > 0 {
+			prompt = ">> "
+		}
+		line, err := r.readLine(prompt)
+		if err == errInterrupted {
+			chunk = nil
+			continue
+		}
+		if err != nil {
+			fmt.Fprintln(w)
+			return
+		}
+		if strings.TrimSpace(line) == "" && len(chunk) == 0 {
+			continue
+		}
+		r.addHistory(line)
+
+		if len(chunk) == 0 && strings.HasPrefix(line, "=") {
+			values, err := e.Eval(line[1:])
+			printValues(w, values, err)
+			continue
+		}
+		chunk = append(chunk, line)
+		code := strings.Join(chunk, "\n")
+		values, err := e.Eval(code)
+		if engine.Incomplete(err) {
+			continue
+		}
+		if err == nil || !isSyntaxError(err) {
+			chunk = nil
+			printValues(w, values, err)
+			continue
+		}
+		err = e.DoString(code, "=stdin")
+		if engine.Incomplete(err) {
+			continue
+		}
+		chunk = nil
+		if err != nil {
+			fmt.Fprintln(w, err)
+		}
+	}
+}
+
+func isSyntaxError(err error) bool {
+	var lerr *engine.Error
+	return errors.As(err, &lerr) && lerr.Kind == engine.SyntaxError
+}
+
+// printValues prints the values returned by an expression, strings as they
+// are and the other values formatted.
+func printValues(w io.Writer, values []engine.Value, err error) {
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return
+	}
+	if len(values) == 0 {
+		return
+	}
+	s := make([]string, len(values))
+	for i, v := range values {
+		if str, ok := v.(string); ok {
+			s[i] = str
+		} else {
+			s[i] = engine.Format(v)
+		}
+	}
+	fmt.Fprintln(w, strings.Join(s, "\t"))
+}
+
+// keysFunction returns the string keys of a table, and of the __index table of
+// its metatable, which holds the methods of userdata and strings.
+const keysFunction = `(function(t)
+	local keys = {}
+	local function add(t)
+		for k in pairs(t) do
+			if type(k) == "string" then keys[#keys + 1] = k end
+		end
+	end
+	if type(t) == "table" then add(t) end
+	local mt = getmetatable(t)
+	if type(mt) == "table" and type(rawget(mt, "__index")) == "table" then add(rawget(mt, "__index")) end
+	return keys
+end)`
+
+var keywords = []string{"and", "break", "do", "else", "elseif", "end", "false", "for", "function", "if",
+	"in", "local", "nil", "not", "or", "repeat", "return", "then", "true", "until", "while"}
+
+// complete returns the completions of the name ending 'line', a global name or
+// a field, and the position where the name starts.
+func complete(e engine.Engine, line string) (int, []string) {
+	start := len(line)
+	for start > 0 && isNameByte(line[start-1]) {
+		start--
+	}
+	if start < len(line) && line[start] >= '0' && line[start] <= '9' {
+		return len(line), nil
+	}
+	word := line[start:]
+	var candidates []string
+	// the field of a table, or the method of an object
+	if sep := strings.LastIndexAny(word, ".:"); sep >= 0 {
+		prefix := word[:sep]
+		for _, name := range keys(e, prefix) {
+			candidates = append(candidates, prefix+word[sep:sep+1]+name)
+		}
+	} else {
+		candidates = append(keys(e, "_G"), keywords...)
+	}
+
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) {
+			matches = append(matches, c)
+		}
+	}
+	return start, matches
+}
+
+// isNameByte reports whether 'c' can be part of a name or a path of fields.
+func isNameByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == ':'
+}
+
+// keys returns the keys of the value of 'expr' from Lua, and the fields and
+// methods of Go values.
+func keys(e engine.Engine, expr string) []string {
+	values, err := e.Eval(keysFunction + "(" + expr + ")")
+	if err != nil || len(values) == 0 {
+		return nil
+	}
+	var names []string
+	if t, ok := values[0].(*engine.Table); ok {
+		for _, v := range t.Array {
+			if s, ok := v.(string); ok {
+				names = append(names, s)
+			}
+		}
+	}
+	if values, err := e.Eval(expr); err == nil && len(values) > 0 {
+		if o, ok := values[0].(*engine.Object); ok && o.Type == nil {
+			names = append(names, goNames(o.Value)...)
+		}
+	}
+	return names
+}
+
+// goNames returns the exported fields and methods of a Go value.
+func goNames(v interface{}) []string {
+	var names []string
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil
+	}
+	for i := 0; i < t.NumMethod(); i++ {
+		names = append(names, t.Method(i).Name)
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct {
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.PkgPath == "" {
+				names = append(names, f.Name)
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+// input returns a pipe reading 'text', so that the lineReader reads plain
+// lines.
+func input(t *testing.T, text string) *os.File {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		w.WriteString(text)
+		w.Close()
+	}()
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestRepl(t *testing.T) {
+	const session = `=1 + 1
+x = 10
+function double(n)
+  return n * 2
+end
+double(x)
+{a = 1}
+print("hi")
+error("boom")
+x = = 1
+`
+	const want = "> 2\n> > >> >> > 20\n> {\n  a = 1,\n}\n> hi\n> eval:1: boom\n> "
+	for _, name := range engine.Names() {
+		var out bytes.Buffer
+		e, err := engine.New(name, engine.Options{Stdout: &out})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		repl(e, newLineReader(input(t, session), &out, nil), &out)
+		e.Close()
+		// the message of the syntax error differs between the engines
+		got := out.String()
+		if !strings.HasPrefix(got, want) || !strings.HasSuffix(got, "\n> \n") || len(got) < len(want)+4 {
+			t.Errorf("%s printed\n%s\nwant\n%s<syntax error>", name, got, want)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	for _, name := range engine.Names() {
+		e, err := engine.New(name, engine.Options{})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := e.DoString(`account = {balance = 1, bank = "b", owner = "o"}`, "=test"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		tests := []struct {
+			line  string
+			start int
+			want  []string
+		}{
+			{"print(acc", 6, []string{"account"}},
+			{"x = account.ba", 4, []string{"account.balance", "account.bank"}},
+			{"whi", 0, []string{"while"}},
+			{"x = 12", 6, nil},
+		}
+		for _, tt := range tests {
+			start, matches := complete(e, tt.line)
+			sort.Strings(matches)
+			if start != tt.start || !reflect.DeepEqual(matches, tt.want) {
+				t.Errorf("%s: complete(%q) = %d, %v, want %d, %v", name, tt.line, start, matches, tt.start, tt.want)
+			}
+		}
+		e.Close()
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	for _, tt := range []struct {
+		words []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{"account"}, "account"},
+		{[]string{"account.balance", "account.bank"}, "account.ba"},
+		{[]string{"abc", "xyz"}, ""},
+	} {
+		if got := commonPrefix(tt.words); got != tt.want {
+			t.Errorf("commonPrefix(%q) = %q, want %q", tt.words, got, tt.want)
+		}
+	}
+}
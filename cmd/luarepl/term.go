@@ -0,0 +1,36 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// makeRaw puts the terminal 'fd' in raw mode, as cfmakeraw does, and returns
+// the function restoring its mode. It fails when 'fd' is not a terminal.
+func makeRaw(fd uintptr) (func(), error) {
+	var old syscall.Termios
+	if err := ioctl(fd, ioctlGetTermios, &old); err != nil {
+		return nil, err
+	}
+	t := old
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, ioctlSetTermios, &t); err != nil {
+		return nil, err
+	}
+	return func() { ioctl(fd, ioctlSetTermios, &old) }, nil
+}
+
+func ioctl(fd, request uintptr, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
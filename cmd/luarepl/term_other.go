@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// makeRaw is not implemented, lines are read without editing.
+func makeRaw(fd uintptr) (func(), error) {
+	return nil, errors.New("raw terminal mode not supported")
+}
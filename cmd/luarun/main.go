@@ -0,0 +1,236 @@
+// Command luarun runs a Lua script from the shell or a CI job, on any of the
+// engines, with the Account type and the json and person libraries registered.
+//
+//	luarun [-engine=golua] [-call=function] [-params=JSON] [-timeout=5s] [-mem=64M] script.lua [args...]
+//
+// The arguments are in the global table arg, arg[0] being the script. With
+// -call, the global function is called once the script has run, with the
+// values of the JSON array -params as arguments ("-" reads it from the
+// standard input), and its results are written to the standard output as a
+// JSON array:
+//
+//	$ luarun -call=square -params='[5]' luac/test.lua
+//	[25]
+//
+// The exit code tells how the run failed:
+//
+//	0  success
+//	1  runtime error
+//	2  usage error: bad flags or parameters, unreadable script
+//	3  syntax error
+//	4  limit exceeded: timeout or memory
+//
+// With -log=text or -log=json, the output of print and io.write is written to
+// the standard error as log messages, one per line, tagged with the script
+// and the -request-id, as are the messages of the log library.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rickcrawford/go-lua-test/bindings"
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+// Exit codes
+const (
+	exitOK = iota
+	exitRuntimeError
+	exitUsage
+	exitSyntaxError
+	exitLimit
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("luarun", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	engineName := flags.String("engine", "golua", "Lua engine: "+strings.Join(engine.Names(), ", "))
+	call := flags.String("call", "", "global function to call after running the script")
+	params := flags.String("params", "", "JSON array of the arguments of the -call function, - to read it from stdin")
+	timeout := flags.Duration("timeout", 0, "maximum duration of the run, no limit when 0")
+	mem := flags.String("mem", "", "memory limit in bytes, with an optional K, M or G suffix")
+	logFormat := flags.String("log", "", "log the output of the script to stderr: text or json")
+	requestID := flags.String("request-id", "", "request ID of the -log messages")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: luarun [flags] script.lua [args...]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	script := flags.Arg(0)
+
+	memoryLimit, err := parseSize(*mem)
+	if err != nil {
+		fmt.Fprintf(stderr, "luarun: bad -mem: %v\n", err)
+		return exitUsage
+	}
+	callArgs, err := parseParams(*params, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "luarun: bad -params: %v\n", err)
+		return exitUsage
+	}
+	if _, err := os.Stat(script); err != nil {
+		fmt.Fprintf(stderr, "luarun: %v\n", err)
+		return exitUsage
+	}
+
+	output := stdout
+	var logger *slog.Logger
+	switch *logFormat {
+	case "":
+	case "text", "json":
+		var handler slog.Handler = slog.NewTextHandler(stderr, nil)
+		if *logFormat == "json" {
+			handler = slog.NewJSONHandler(stderr, nil)
+		}
+		logger = slog.New(handler)
+		if *requestID != "" {
+			logger = logger.With("request_id", *requestID)
+		}
+		lines := engine.NewLineLogger(logger, script, "")
+		defer lines.Flush()
+		output = lines
+	default:
+		fmt.Fprintf(stderr, "luarun: bad -log %q, expected text or json\n", *logFormat)
+		return exitUsage
+	}
+
+	e, err := engine.New(*engineName, engine.Options{Modules: bindings.Modules(), MemoryLimit: memoryLimit, Stdout: output, Stdin: stdin, Logger: logger})
+	if err != nil {
+		fmt.Fprintf(stderr, "luarun: %v\n", err)
+		return exitUsage
+	}
+	defer e.Close()
+
+	if *timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		e.SetContext(ctx)
+	}
+
+	arg := engine.NewTable()
+	arg.Set("0", script)
+	for _, a := range flags.Args()[1:] {
+		arg.Array = append(arg.Array, a)
+	}
+	if err := e.SetGlobal("arg", arg); err != nil {
+		fmt.Fprintf(stderr, "luarun: %v\n", err)
+		return exitRuntimeError
+	}
+
+	if err := e.DoFile(script); err != nil {
+		return fail(stderr, err)
+	}
+	if *call == "" {
+		return exitOK
+	}
+	results, err := e.Call(*call, callArgs...)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	values := make([]interface{}, len(results))
+	for i, r := range results {
+		values[i] = engine.ToGo(r)
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		fmt.Fprintf(stderr, "luarun: encoding the results: %v\n", err)
+		return exitRuntimeError
+	}
+	fmt.Fprintf(stdout, "%s\n", data)
+	return exitOK
+}
+
+// fail prints a Lua error and returns the exit code of its kind.
+func fail(stderr io.Writer, err error) int {
+	fmt.Fprintf(stderr, "luarun: %v\n", err)
+	var lerr *engine.Error
+	if !errors.As(err, &lerr) {
+		return exitRuntimeError
+	}
+	switch lerr.Kind {
+	case engine.SyntaxError:
+		return exitSyntaxError
+	case engine.LimitError:
+		return exitLimit
+	}
+	return exitRuntimeError
+}
+
+// parseParams decodes the JSON array of the parameters, or reads it from 'stdin'
+// when 's' is "-". Another JSON value is a single parameter.
+func parseParams(s string, stdin io.Reader) ([]engine.Value, error) {
+	if s == "" {
+		return nil, nil
+	}
+	data := []byte(s)
+	if s == "-" {
+		var err error
+		if data, err = io.ReadAll(stdin); err != nil {
+			return nil, err
+		}
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		list = []interface{}{v}
+	}
+	params := make([]engine.Value, len(list))
+	for i, p := range list {
+		params[i] = engine.ToValue(p)
+	}
+	return params, nil
+}
+
+// parseSize parses a number of bytes with an optional K, M or G suffix. The
+// size cannot be negative, which would disable the limit.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	size, unit := s, int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		unit = 1 << 10
+	case "M":
+		unit = 1 << 20
+	case "G":
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("negative size %d", n)
+	}
+	if n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("size %s is too large", size)
+	}
+	return n * unit, nil
+}
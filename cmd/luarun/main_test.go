@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+const script = `
+function add(a, b) return a + b end
+function first(t) return t.items[1], t.name end
+function summary(...)
+	return {count = select("#", ...), args = {...}}
+end
+function args() return arg[0], #arg, arg[1], arg[2] end
+print("ran")
+`
+
+func writeFile(t *testing.T, dir, name, code string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	ok := writeFile(t, dir, "ok.lua", script)
+	syntax := writeFile(t, dir, "syntax.lua", "x = = 1")
+	runtime := writeFile(t, dir, "runtime.lua", `error("boom")`)
+	loop := writeFile(t, dir, "loop.lua", "while true do end")
+	alloc := writeFile(t, dir, "alloc.lua", "local t = {} for i = 1, 1e7 do t[i] = tostring(i) .. 'padding' end")
+
+	tests := []struct {
+		args   []string
+		stdin  string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{args: []string{ok}, stdout: "ran\n"},
+		{args: []string{"-call=add", "-params=[1, 2]", ok}, stdout: "ran\n[3]\n"},
+		{args: []string{"-call=first", "-params=-", ok}, stdin: `{"items": ["a"], "name": "n"}`, stdout: "ran\n[\"a\",\"n\"]\n"},
+		{args: []string{"-call=summary", `-params=[true, "s", {"k": [1]}]`, ok}, stdout: "ran\n[{\"args\":[true,\"s\",{\"k\":[1]}],\"count\":3}]\n"},
+		{args: []string{"-call=args", ok, "x", "y"}, stdout: "ran\n[\"" + ok + "\",2,\"x\",\"y\"]\n"},
+		{args: []string{"-call=missing", ok}, code: exitRuntimeError, stdout: "ran\n"},
+
+		{args: []string{syntax}, code: exitSyntaxError, stderr: "luarun: "},
+		{args: []string{runtime}, code: exitRuntimeError, stderr: "boom"},
+		{args: []string{"-timeout=50ms", loop}, code: exitLimit},
+		{args: []string{"-mem=1M", alloc}, code: exitLimit},
+
+		{args: nil, code: exitUsage, stderr: "usage: luarun"},
+		{args: []string{"-bad", ok}, code: exitUsage},
+		{args: []string{filepath.Join(dir, "missing.lua")}, code: exitUsage},
+		{args: []string{"-mem=-1", ok}, code: exitUsage, stderr: "bad -mem"},
+		{args: []string{"-params=[1,", ok}, code: exitUsage, stderr: "bad -params"},
+		{args: []string{"-engine=none", ok}, code: exitUsage, stderr: "unknown Lua engine"},
+		{args: []string{"-log=xml", ok}, code: exitUsage, stderr: "bad -log"},
+	}
+	for _, name := range engine.Names() {
+		for _, tt := range tests {
+			args := tt.args
+			if len(args) > 0 && !strings.HasPrefix(args[0], "-engine") {
+				args = append([]string{"-engine=" + name}, args...)
+			}
+			var stdout, stderr bytes.Buffer
+			code := run(args, strings.NewReader(tt.stdin), &stdout, &stderr)
+			if code != tt.code {
+				t.Errorf("%s: %v: exit code %d, want %d, stderr:\n%s", name, tt.args, code, tt.code, stderr.String())
+			}
+			if stdout.String() != tt.stdout {
+				t.Errorf("%s: %v: printed %q, want %q", name, tt.args, stdout.String(), tt.stdout)
+			}
+			if !strings.Contains(stderr.String(), tt.stderr) {
+				t.Errorf("%s: %v: stderr %q does not contain %q", name, tt.args, stderr.String(), tt.stderr)
+			}
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want int64
+		err  bool
+	}{
+		{s: "", want: 0},
+		{s: "512", want: 512},
+		{s: "2k", want: 2 << 10},
+		{s: "64M", want: 64 << 20},
+		{s: "1G", want: 1 << 30},
+		{s: "-1", err: true},
+		{s: "-1M", err: true},
+		{s: "M", err: true},
+		{s: "1.5M", err: true},
+		{s: "9223372036854775807G", err: true},
+	} {
+		got, err := parseSize(tt.s)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v", tt.s, got, err)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Package coverage records the lines of Lua scripts executed by golua states
+// and reports them in the LCOV and HTML formats.
+//
+// A Coverage is shared by all the states of a test, and the reports of several
+// test runs can be merged with WriteLCOVFile:
+//
+//	var cover = coverage.New()
+//
+//	func TestMain(m *testing.M) {
+//		code := m.Run()
+//		if err := cover.WriteLCOVFile("lua.lcov"); err != nil {
+//			log.Fatal(err)
+//		}
+//		os.Exit(code)
+//	}
+//
+//	func TestAccount(t *testing.T) {
+//		L := lua.NewState()
+//		defer L.Close()
+//		cover.Start(L)
+//		...
+//	}
+package coverage
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aarzilli/golua/lua"
+)
+
+// Coverage holds the number of executions of every line of the chunks run by
+// the states it was started on.
+type Coverage struct {
+	mu    sync.Mutex
+	files map[string]*fileCoverage
+}
+
+type fileCoverage struct {
+	// Executions per line, including the executable lines never run.
+	lines map[int]int64
+	// Source of the chunk, only known for files and strings run while started.
+	source string
+}
+
+// New creates an empty Coverage.
+func New() *Coverage {
+	return &Coverage{files: map[string]*fileCoverage{}}
+}
+
+// Start records the lines executed by 'L'. It installs a line hook, which
+// replaces the hook or execution limit set on the state.
+func (c *Coverage) Start(L *lua.State) {
+	L.SetHook(lua.LUA_MASKLINE, 0, c.hook)
+}
+
+// Stop stops recording the lines executed by 'L'.
+func (c *Coverage) Stop(L *lua.State) {
+	L.SetHook(0, 0, nil)
+}
+
+func (c *Coverage) hook(L *lua.State, ev lua.HookEvent) {
+	if ev.CurrentLine <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := chunkName(ev.Source, ev.ShortSource)
+	f, ok := c.files[name]
+	if !ok {
+		f = c.addChunk(name, ev.Source)
+	}
+	f.lines[ev.CurrentLine]++
+}
+
+// chunkName is the file name of chunks loaded from files, the short source of
+// the others.
+func chunkName(source, shortSource string) string {
+	if strings.HasPrefix(source, "@") {
+		return source[1:]
+	}
+	return shortSource
+}
+
+// addChunk adds the executable lines of a chunk seen for the first time. The
+// source of a file is read from disk, the source of a string chunk is the
+// string itself.
+func (c *Coverage) addChunk(name, source string) *fileCoverage {
+	f := &fileCoverage{lines: map[int]int64{}}
+	c.files[name] = f
+	if strings.HasPrefix(source, "@") {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return f
+		}
+		source = string(b)
+	} else if strings.HasPrefix(source, "=") {
+		return f
+	}
+	f.source = source
+	for line := range executableLines(source, name) {
+		f.lines[line] = 0
+	}
+	return f
+}
+
+// AddFile adds the Lua file at 'path' to the coverage, so that it is reported
+// with all its lines unexecuted if no state runs it.
+func (c *Coverage) AddFile(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.files[path]; !ok {
+		c.addChunk(path, "@"+path)
+	}
+	return nil
+}
+
+// Merge adds the executions recorded by 'other' to 'c'.
+func (c *Coverage) Merge(other *Coverage) {
+	if c == other {
+		return
+	}
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, of := range other.files {
+		f, ok := c.files[name]
+		if !ok {
+			f = &fileCoverage{lines: map[int]int64{}}
+			c.files[name] = f
+		}
+		if f.source == "" {
+			f.source = of.source
+		}
+		for line, n := range of.lines {
+			f.lines[line] += n
+		}
+	}
+}
+
+// FileCoverage is the coverage of a chunk.
+type FileCoverage struct {
+	// File name, or short source of the chunks not loaded from a file.
+	Name string
+	// Executions of the executable lines.
+	Lines map[int]int64
+	// Source of the chunk, empty when it was only read from an LCOV file.
+	Source string
+}
+
+// Hit returns the number of lines executed at least once.
+func (f FileCoverage) Hit() int {
+	n := 0
+	for _, count := range f.Lines {
+		if count > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Missed returns the executable lines never executed, in order.
+func (f FileCoverage) Missed() []int {
+	missed := []int{}
+	for line, count := range f.Lines {
+		if count == 0 {
+			missed = append(missed, line)
+		}
+	}
+	sort.Ints(missed)
+	return missed
+}
+
+// Files returns a copy of the coverage of every chunk, sorted by name.
+func (c *Coverage) Files() []FileCoverage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	files := make([]FileCoverage, 0, len(c.files))
+	for name, f := range c.files {
+		lines := make(map[int]int64, len(f.lines))
+		for line, n := range f.lines {
+			lines[line] = n
+		}
+		files = append(files, FileCoverage{Name: name, Lines: lines, Source: f.source})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files
+}
+
+// sortedLines returns the lines of 'lines' in order.
+func sortedLines(lines map[int]int64) []int {
+	sorted := make([]int, 0, len(lines))
+	for line := range lines {
+		sorted = append(sorted, line)
+	}
+	sort.Ints(sorted)
+	return sorted
+}
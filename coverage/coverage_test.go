@@ -0,0 +1,160 @@
+package coverage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aarzilli/golua/lua"
+)
+
+const script = `function sign(x)
+	if x > 0 then
+		return 1
+	else
+		return -1
+	end
+end
+sign(1)
+`
+
+func writeScript(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "sign.lua")
+	if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newCoverage returns the coverage of a script run 'runs' times.
+func newCoverage(t *testing.T, path string, runs int) *Coverage {
+	c := New()
+	L := lua.NewState()
+	defer L.Close()
+	L.OpenLibs()
+	c.Start(L)
+	for i := 0; i < runs; i++ {
+		if err := L.DoFile(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Stop(L)
+	return c
+}
+
+func TestHook(t *testing.T) {
+	path := writeScript(t)
+	files := newCoverage(t, path, 1).Files()
+	if len(files) != 1 || files[0].Name != path || files[0].Source != script {
+		t.Fatalf("got %+v", files)
+	}
+	f := files[0]
+	for _, line := range []int{1, 2, 3, 8} {
+		if f.Lines[line] != 1 {
+			t.Errorf("line %d executed %d times, want 1", line, f.Lines[line])
+		}
+	}
+	if missed := f.Missed(); !reflect.DeepEqual(missed, []int{5}) {
+		t.Errorf("missed %v, want [5]", missed)
+	}
+}
+
+// TestLocalFunctions checks that the multi-line local functions, whose
+// closures Lua creates on their last line, are not reported missed.
+func TestLocalFunctions(t *testing.T) {
+	const code = `local function double(x)
+	return 2 * x
+end
+local half = function(x)
+	return x / 2
+end
+local y = double(half(4))
+`
+	want := map[int]bool{2: true, 3: true, 5: true, 6: true, 7: true}
+	if got := executableLines(code, "local.lua"); !reflect.DeepEqual(got, want) {
+		t.Errorf("executable lines %v, want %v", got, want)
+	}
+	path := filepath.Join(t.TempDir(), "local.lua")
+	if err := os.WriteFile(path, []byte(code), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files := newCoverage(t, path, 1).Files()
+	if len(files) != 1 || len(files[0].Missed()) != 0 {
+		t.Errorf("got %+v", files)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	path := writeScript(t)
+	c := newCoverage(t, path, 1)
+	c.Merge(newCoverage(t, path, 2))
+	c.Merge(c)
+	f := c.Files()[0]
+	if f.Lines[3] != 3 || f.Lines[5] != 0 || f.Source != script {
+		t.Errorf("got %+v", f)
+	}
+}
+
+func TestLCOV(t *testing.T) {
+	path := writeScript(t)
+	c := newCoverage(t, path, 2)
+	var buf bytes.Buffer
+	if err := c.WriteLCOV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "SF:"+path+"\nDA:1,2\nDA:2,2\nDA:3,2\nDA:5,0\n") {
+		t.Errorf("got\n%s", buf.String())
+	}
+
+	read := New()
+	if err := read.ReadLCOV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := read.Files()[0].Lines, c.Files()[0].Lines; !reflect.DeepEqual(got, want) {
+		t.Errorf("read %v, want %v", got, want)
+	}
+	if err := read.ReadLCOV(strings.NewReader("DA:1,1\n")); err == nil {
+		t.Error("read a DA record outside of a file")
+	}
+}
+
+func TestWriteLCOVFile(t *testing.T) {
+	path := writeScript(t)
+	lcov := filepath.Join(t.TempDir(), "lua.lcov")
+	for i := 0; i < 2; i++ {
+		if err := newCoverage(t, path, 1).WriteLCOVFile(lcov); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := os.ReadFile(lcov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New()
+	if err := c.ReadLCOV(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	if lines := c.Files()[0].Lines; lines[3] != 2 || lines[5] != 0 {
+		t.Errorf("the runs did not accumulate: %v", lines)
+	}
+}
+
+func TestHTML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newCoverage(t, writeScript(t), 1).WriteHTML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	html := buf.String()
+	for _, want := range []string{
+		`<tr class="hit"><td class="number">3</td><td class="count">1</td><td>		return 1</td></tr>`,
+		`<tr class="missed"><td class="number">5</td><td class="count">0</td><td>		return -1</td></tr>`,
+		`<tr class=""><td class="number">4</td><td class="count"></td><td>	else</td></tr>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("missing %s in\n%s", want, html)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package coverage
+
+import (
+	"html/template"
+	"io"
+	"os"
+	"strings"
+)
+
+type htmlFile struct {
+	Name    string
+	Percent float64
+	Lines   []htmlLine
+}
+
+type htmlLine struct {
+	Number int
+	Text   string
+	// "hit", "missed" or "" for the lines that are not executable.
+	Class string
+	Count int64
+}
+
+var htmlTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Lua coverage</title>
+<style>
+body { font-family: sans-serif; }
+pre { margin: 0; }
+table.source { border-collapse: collapse; font-family: monospace; }
+table.source td { padding: 0 0.5em; white-space: pre; }
+td.number, td.count { color: #888; text-align: right; }
+tr.hit { background: #dfd; }
+tr.missed { background: #fdd; }
+</style>
+</head>
+<body>
+<h1>Lua coverage</h1>
+<ul>
+{{range $i, $f := .}}<li><a href="#file{{$i}}">{{$f.Name}}</a> {{printf "%.1f" $f.Percent}}%</li>
+{{end}}</ul>
+{{range $i, $f := .}}<h2 id="file{{$i}}">{{$f.Name}} ({{printf "%.1f" $f.Percent}}%)</h2>
+<table class="source">
+{{range $f.Lines}}<tr class="{{.Class}}"><td class="number">{{.Number}}</td><td class="count">{{if .Class}}{{.Count}}{{end}}</td><td>{{.Text}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// WriteHTML writes a report showing the source of every chunk, with the lines
+// executed in green and the lines never executed in red.
+//
+// The source of the chunks only known from an LCOV file is read from disk. The
+// chunks whose source can not be found are listed with their executable lines.
+func (c *Coverage) WriteHTML(w io.Writer) error {
+	files := []htmlFile{}
+	for _, f := range c.Files() {
+		source := f.Source
+		if source == "" {
+			if b, err := os.ReadFile(f.Name); err == nil {
+				source = string(b)
+			}
+		}
+		hf := htmlFile{Name: f.Name}
+		if len(f.Lines) > 0 {
+			hf.Percent = 100 * float64(f.Hit()) / float64(len(f.Lines))
+		}
+		if source != "" {
+			for i, text := range strings.Split(strings.TrimSuffix(source, "\n"), "\n") {
+				hf.Lines = append(hf.Lines, htmlLine{Number: i + 1, Text: text})
+			}
+		}
+		for _, number := range sortedLines(f.Lines) {
+			for len(hf.Lines) < number {
+				hf.Lines = append(hf.Lines, htmlLine{Number: len(hf.Lines) + 1})
+			}
+			line := &hf.Lines[number-1]
+			line.Count = f.Lines[number]
+			line.Class = "missed"
+			if line.Count > 0 {
+				line.Class = "hit"
+			}
+		}
+		files = append(files, hf)
+	}
+	return htmlTemplate.Execute(w, files)
+}
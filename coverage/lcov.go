@@ -0,0 +1,89 @@
+package coverage
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// WriteLCOV writes the coverage in the LCOV tracefile format read by genhtml
+// and most CI services.
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range c.Files() {
+		fmt.Fprintf(bw, "TN:\nSF:%s\n", f.Name)
+		for _, line := range sortedLines(f.Lines) {
+			fmt.Fprintf(bw, "DA:%d,%d\n", line, f.Lines[line])
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", len(f.Lines), f.Hit())
+	}
+	return bw.Flush()
+}
+
+// ReadLCOV merges the line records of an LCOV tracefile into the coverage.
+// Records other than SF and DA are ignored.
+func (c *Coverage) ReadLCOV(r io.Reader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var f *fileCoverage
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		record := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(record, "SF:"):
+			name := record[len("SF:"):]
+			var ok bool
+			f, ok = c.files[name]
+			if !ok {
+				f = &fileCoverage{lines: map[int]int64{}}
+				c.files[name] = f
+			}
+		case strings.HasPrefix(record, "DA:"):
+			if f == nil {
+				return fmt.Errorf("lcov:%d: DA record outside of a file", n)
+			}
+			fields := strings.Split(record[len("DA:"):], ",")
+			if len(fields) < 2 {
+				return fmt.Errorf("lcov:%d: malformed DA record %q", n, record)
+			}
+			line, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return fmt.Errorf("lcov:%d: %v", n, err)
+			}
+			count, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("lcov:%d: %v", n, err)
+			}
+			f.lines[line] += count
+		case record == "end_of_record":
+			f = nil
+		}
+	}
+	return scanner.Err()
+}
+
+// WriteLCOVFile writes the coverage to the LCOV file at 'path', adding the
+// executions already recorded in the file, so that the coverage of successive
+// test runs or packages accumulates.
+func (c *Coverage) WriteLCOVFile(path string) error {
+	merged := New()
+	merged.Merge(c)
+	b, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := merged.ReadLCOV(bytes.NewReader(b)); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	var buf bytes.Buffer
+	if err := merged.WriteLCOV(&buf); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
@@ -0,0 +1,107 @@
+package coverage
+
+import (
+	"strings"
+
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// executableLines returns the lines of 'source' starting a statement, which
+// are the lines a line hook can report. It returns nil if 'source' does not
+// parse.
+//
+// Lua also reports the 'end' of a function without return statement, and the
+// lines of statements spanning several lines. These are added to the report
+// when executed. The statements 'local function f' and 'local f = function'
+// run on the line of the 'end' of the function, where Lua 5.1 creates the
+// closure, rather than on their first line.
+func executableLines(source, name string) map[int]bool {
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil
+	}
+	lines := map[int]bool{}
+	walkStmts(chunk, lines)
+	return lines
+}
+
+func walkStmts(stmts []ast.Stmt, lines map[int]bool) {
+	for _, stmt := range stmts {
+		line := stmt.Line()
+		switch s := stmt.(type) {
+		case *ast.AssignStmt:
+			walkExprs(s.Lhs, lines)
+			walkExprs(s.Rhs, lines)
+		case *ast.LocalAssignStmt:
+			if len(s.Exprs) > 0 {
+				if f, ok := s.Exprs[0].(*ast.FunctionExpr); ok {
+					line = f.LastLine()
+				}
+			}
+			walkExprs(s.Exprs, lines)
+		case *ast.FuncCallStmt:
+			walkExpr(s.Expr, lines)
+		case *ast.DoBlockStmt:
+			walkStmts(s.Stmts, lines)
+		case *ast.WhileStmt:
+			walkExpr(s.Condition, lines)
+			walkStmts(s.Stmts, lines)
+		case *ast.RepeatStmt:
+			walkStmts(s.Stmts, lines)
+			walkExpr(s.Condition, lines)
+		case *ast.IfStmt:
+			walkExpr(s.Condition, lines)
+			walkStmts(s.Then, lines)
+			walkStmts(s.Else, lines)
+		case *ast.NumberForStmt:
+			walkExprs([]ast.Expr{s.Init, s.Limit, s.Step}, lines)
+			walkStmts(s.Stmts, lines)
+		case *ast.GenericForStmt:
+			walkExprs(s.Exprs, lines)
+			walkStmts(s.Stmts, lines)
+		case *ast.FuncDefStmt:
+			walkStmts(s.Func.Stmts, lines)
+		case *ast.ReturnStmt:
+			walkExprs(s.Exprs, lines)
+		}
+		lines[line] = true
+	}
+}
+
+func walkExprs(exprs []ast.Expr, lines map[int]bool) {
+	for _, expr := range exprs {
+		walkExpr(expr, lines)
+	}
+}
+
+// walkExpr looks for the function bodies in 'expr'.
+func walkExpr(expr ast.Expr, lines map[int]bool) {
+	switch e := expr.(type) {
+	case *ast.FunctionExpr:
+		walkStmts(e.Stmts, lines)
+	case *ast.AttrGetExpr:
+		walkExprs([]ast.Expr{e.Object, e.Key}, lines)
+	case *ast.TableExpr:
+		for _, f := range e.Fields {
+			walkExprs([]ast.Expr{f.Key, f.Value}, lines)
+		}
+	case *ast.FuncCallExpr:
+		walkExprs([]ast.Expr{e.Func, e.Receiver}, lines)
+		walkExprs(e.Args, lines)
+	case *ast.LogicalOpExpr:
+		walkExprs([]ast.Expr{e.Lhs, e.Rhs}, lines)
+	case *ast.RelationalOpExpr:
+		walkExprs([]ast.Expr{e.Lhs, e.Rhs}, lines)
+	case *ast.StringConcatOpExpr:
+		walkExprs([]ast.Expr{e.Lhs, e.Rhs}, lines)
+	case *ast.ArithmeticOpExpr:
+		walkExprs([]ast.Expr{e.Lhs, e.Rhs}, lines)
+	case *ast.UnaryMinusOpExpr:
+		walkExpr(e.Expr, lines)
+	case *ast.UnaryNotOpExpr:
+		walkExpr(e.Expr, lines)
+	case *ast.UnaryLenOpExpr:
+		walkExpr(e.Expr, lines)
+	}
+}
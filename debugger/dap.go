@@ -0,0 +1,273 @@
+package debugger
+
+// Debug Adapter Protocol messages and framing, see
+// https://microsoft.github.io/debug-adapter-protocol/specification
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Message is a DAP request, response or event. Only the fields of the kind of
+// message given by Type are set.
+type Message struct {
+	Seq  int    `json:"seq"`
+	Type string `json:"type"`
+
+	// Requests
+	Command   string          `json:"command,omitempty"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+
+	// Responses, which also have a Command. Success is always written for
+	// responses, see MarshalJSON.
+	RequestSeq int    `json:"request_seq,omitempty"`
+	Success    bool   `json:"success,omitempty"`
+	ErrMessage string `json:"message,omitempty"`
+
+	// Events
+	Event string `json:"event,omitempty"`
+
+	// Responses and events
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// MarshalJSON encodes the message, always including the success field of
+// responses: a failed response must say so rather than omit it.
+func (m Message) MarshalJSON() ([]byte, error) {
+	type message Message
+	if m.Type != "response" {
+		return json.Marshal(message(m))
+	}
+	return json.Marshal(struct {
+		message
+		Success bool `json:"success"`
+	}{message(m), m.Success})
+}
+
+// MaxMessageSize is the largest Content-Length accepted by ReadMessage.
+const MaxMessageSize = 16 << 20
+
+// ReadMessage reads a message framed with a Content-Length header.
+func ReadMessage(r *bufio.Reader) (*Message, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("dap: bad Content-Length %q", header.Get("Content-Length"))
+	}
+	if length > MaxMessageSize {
+		return nil, fmt.Errorf("dap: message of %d bytes exceeds %d", length, MaxMessageSize)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	msg := &Message{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, fmt.Errorf("dap: %v", err)
+	}
+	return msg, nil
+}
+
+// WriteMessage writes 'msg' framed with a Content-Length header.
+func WriteMessage(w io.Writer, msg *Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+// conn is the server side of a DAP session.
+type conn struct {
+	r *bufio.Reader
+
+	mu  sync.Mutex
+	w   io.Writer
+	seq int
+}
+
+func newConn(rw io.ReadWriter) *conn {
+	return &conn{r: bufio.NewReader(rw), w: rw}
+}
+
+func (c *conn) send(msg *Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	msg.Seq = c.seq
+	return WriteMessage(c.w, msg)
+}
+
+func (c *conn) respond(req *Message, body interface{}, err error) error {
+	msg := &Message{Type: "response", Command: req.Command, RequestSeq: req.Seq, Success: err == nil}
+	if err != nil {
+		msg.ErrMessage = err.Error()
+	} else if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		msg.Body = b
+	}
+	return c.send(msg)
+}
+
+func (c *conn) event(event string, body interface{}) error {
+	msg := &Message{Type: "event", Event: event}
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		msg.Body = b
+	}
+	return c.send(msg)
+}
+
+// Bodies and arguments of the supported requests and events.
+
+type capabilities struct {
+	SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
+	SupportsEvaluateForHovers        bool `json:"supportsEvaluateForHovers"`
+}
+
+type attachArguments struct {
+	StopOnEntry bool `json:"stopOnEntry"`
+}
+
+// Source identifies a Lua chunk. Path is set for the chunks loaded from
+// files, Name is the short source of the chunk.
+type Source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type sourceBreakpoint struct {
+	Line int `json:"line"`
+}
+
+type setBreakpointsArguments struct {
+	Source      Source             `json:"source"`
+	Breakpoints []sourceBreakpoint `json:"breakpoints"`
+}
+
+type breakpoint struct {
+	Verified bool `json:"verified"`
+	Line     int  `json:"line"`
+}
+
+type setBreakpointsBody struct {
+	Breakpoints []breakpoint `json:"breakpoints"`
+}
+
+type thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type threadsBody struct {
+	Threads []thread `json:"threads"`
+}
+
+// StackFrame is a frame of the Lua stack. Frame ids are the stack level plus
+// one, the innermost frame is level 0.
+type StackFrame struct {
+	ID     int     `json:"id"`
+	Name   string  `json:"name"`
+	Source *Source `json:"source,omitempty"`
+	Line   int     `json:"line"`
+	Column int     `json:"column"`
+}
+
+type stackTraceBody struct {
+	StackFrames []StackFrame `json:"stackFrames"`
+	TotalFrames int          `json:"totalFrames"`
+}
+
+type frameArguments struct {
+	FrameID int `json:"frameId"`
+}
+
+// Scope is a group of variables of a frame: locals, upvalues or globals.
+type Scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type scopesBody struct {
+	Scopes []Scope `json:"scopes"`
+}
+
+type variablesArguments struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+// Variable is a Lua value. Tables have a VariablesReference to list their
+// fields.
+type Variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type variablesBody struct {
+	Variables []Variable `json:"variables"`
+}
+
+type evaluateArguments struct {
+	Expression string `json:"expression"`
+	FrameID    int    `json:"frameId"`
+}
+
+type evaluateBody struct {
+	Result             string `json:"result"`
+	Type               string `json:"type"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type stoppedBody struct {
+	Reason            string `json:"reason"`
+	ThreadID          int    `json:"threadId"`
+	AllThreadsStopped bool   `json:"allThreadsStopped"`
+}
+
+type continuedBody struct {
+	ThreadID            int  `json:"threadId"`
+	AllThreadsContinued bool `json:"allThreadsContinued"`
+}
+
+type continueBody struct {
+	AllThreadsContinued bool `json:"allThreadsContinued"`
+}
+
+// sourcePath is the path used to match breakpoints: the file name for chunks
+// loaded from files, with forward slashes.
+func sourcePath(path string) string {
+	return strings.TrimSuffix(strings.Replace(path, "\\", "/", -1), "/")
+}
+
+// samePath reports whether the breakpoint path 'bp', usually absolute, and
+// the chunk name 'chunk', usually relative to the working directory, designate
+// the same file: one must be the other or end with "/" and the other.
+func samePath(bp, chunk string) bool {
+	bp, chunk = sourcePath(bp), sourcePath(strings.TrimPrefix(chunk, "./"))
+	if bp == chunk {
+		return true
+	}
+	return strings.HasSuffix(bp, "/"+chunk) || strings.HasSuffix(chunk, "/"+bp)
+}
@@ -0,0 +1,479 @@
+// Package debugger is a debugger for golua states speaking the Debug Adapter
+// Protocol (DAP), so that editors can attach to the Lua scripts embedded in a
+// Go program.
+//
+// The Debugger installs a line hook on the state. When a breakpoint or a step
+// is reached, the goroutine running the script blocks in the hook and serves
+// the inspection requests of the client (stack, variables, evaluation) until
+// it is told to continue:
+//
+//	d := debugger.New(L)
+//	defer d.Close()
+//	go d.ListenAndServe("127.0.0.1:4711")
+//	L.DoFile("test.lua")
+//
+// An editor launching the program as a debug adapter talks on the standard
+// input and output instead:
+//
+//	go d.Serve(struct {
+//		io.Reader
+//		io.Writer
+//	}{os.Stdin, os.Stdout})
+//
+// Only one client is served at a time. Scripts run at full speed when no
+// client is attached, apart from the cost of the line hook.
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aarzilli/golua/lua"
+)
+
+// ErrNotStopped is returned by the requests that need the script to be
+// stopped, such as variables or evaluate, while it runs.
+var ErrNotStopped = errors.New("the script is not stopped")
+
+// Id of the single thread reported to the client. Coroutines are not told apart.
+const threadID = 1
+
+type stepMode int
+
+const (
+	stepNone stepMode = iota
+	stepIn
+	stepOver
+	stepOut
+)
+
+// Debugger debugs a golua state.
+type Debugger struct {
+	L *lua.State
+
+	// Set when a pause is requested, read by the hook without locking.
+	pause int32
+
+	mu sync.Mutex
+	// Current session, nil when no client is attached.
+	session *conn
+	// Breakpoint lines and the paths they are set in.
+	breakpoints map[int][]string
+	mode        stepMode
+	// Stack depth when the step started.
+	stepDepth int
+	// Set while the script is stopped.
+	stop *stopped
+}
+
+// stopped is the state of a stopped script.
+type stopped struct {
+	// Functions to run on the goroutine of the script, which stops serving them
+	// when one returns true.
+	requests chan func(L *lua.State) bool
+	// Closed when the script continues.
+	done chan struct{}
+	// Variable containers, variablesReference is the index plus one.
+	vars []container
+	// Registry references to release when the script continues.
+	refs []int
+}
+
+// container is something which has variables.
+type container struct {
+	kind  containerKind
+	level int
+	// Registry reference of a table.
+	ref int
+}
+
+type containerKind int
+
+const (
+	localsContainer containerKind = iota
+	upvaluesContainer
+	globalsContainer
+	tableContainer
+)
+
+// New creates a Debugger for 'L' and installs its line hook, which replaces
+// the hook or execution limit set on the state. It must be called from the
+// goroutine using 'L'.
+func New(L *lua.State) *Debugger {
+	d := &Debugger{L: L, breakpoints: map[int][]string{}}
+	L.SetHook(lua.LUA_MASKLINE, 0, d.hook)
+	return d
+}
+
+// Close removes the hook of the Debugger. It must be called from the goroutine
+// using the state, and not while a client is attached.
+func (d *Debugger) Close() {
+	d.L.SetHook(0, 0, nil)
+}
+
+func (d *Debugger) hook(L *lua.State, ev lua.HookEvent) {
+	if ev.Event != lua.LUA_HOOKLINE {
+		return
+	}
+	reason := d.stopReason(L, ev)
+	if reason == "" {
+		return
+	}
+	d.serveStopped(L, reason)
+}
+
+// stopReason returns why the script must stop on the line of 'ev', or an empty
+// string if it must not.
+func (d *Debugger) stopReason(L *lua.State, ev lua.HookEvent) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.session == nil {
+		return ""
+	}
+	if atomic.CompareAndSwapInt32(&d.pause, 1, 0) {
+		return "pause"
+	}
+	switch d.mode {
+	case stepIn:
+		return "step"
+	case stepOver:
+		if stackDepth(L) <= d.stepDepth {
+			return "step"
+		}
+	case stepOut:
+		if stackDepth(L) < d.stepDepth {
+			return "step"
+		}
+	}
+	for _, path := range d.breakpoints[ev.CurrentLine] {
+		if samePath(path, chunkName(ev.Source, ev.ShortSource)) {
+			return "breakpoint"
+		}
+	}
+	return ""
+}
+
+func chunkName(source, shortSource string) string {
+	if strings.HasPrefix(source, "@") {
+		return source[1:]
+	}
+	return shortSource
+}
+
+func stackDepth(L *lua.State) int {
+	depth := 0
+	for L.GetStack(depth) {
+		depth++
+	}
+	return depth
+}
+
+// serveStopped blocks the script and runs the requests of the session until
+// one resumes it.
+func (d *Debugger) serveStopped(L *lua.State, reason string) {
+	s := &stopped{requests: make(chan func(L *lua.State) bool), done: make(chan struct{})}
+	d.mu.Lock()
+	session := d.session
+	if session == nil {
+		// Detached in the meantime.
+		d.mu.Unlock()
+		return
+	}
+	d.stop = s
+	d.mode = stepNone
+	d.mu.Unlock()
+
+	session.event("stopped", stoppedBody{Reason: reason, ThreadID: threadID, AllThreadsStopped: true})
+	for request := range s.requests {
+		if request(L) {
+			break
+		}
+	}
+
+	d.mu.Lock()
+	d.stop = nil
+	d.mu.Unlock()
+	close(s.done)
+	for _, ref := range s.refs {
+		L.Unref(lua.LUA_REGISTRYINDEX, ref)
+	}
+}
+
+// onStopped runs 'f' on the goroutine of the stopped script and waits for it.
+func (d *Debugger) onStopped(f func(L *lua.State, s *stopped) error) error {
+	d.mu.Lock()
+	s := d.stop
+	d.mu.Unlock()
+	if s == nil {
+		return ErrNotStopped
+	}
+	result := make(chan error, 1)
+	select {
+	case s.requests <- func(L *lua.State) bool {
+		result <- f(L, s)
+		return false
+	}:
+		return <-result
+	case <-s.done:
+		return ErrNotStopped
+	}
+}
+
+// resume makes the stopped script continue in 'mode'.
+func (d *Debugger) resume(mode stepMode) error {
+	d.mu.Lock()
+	s := d.stop
+	d.mu.Unlock()
+	if s == nil {
+		return ErrNotStopped
+	}
+	select {
+	case s.requests <- func(L *lua.State) bool {
+		d.mu.Lock()
+		d.mode = mode
+		d.stepDepth = stackDepth(L)
+		d.mu.Unlock()
+		return true
+	}:
+		return nil
+	case <-s.done:
+		return ErrNotStopped
+	}
+}
+
+// setBreakpoints replaces the breakpoints of the file at 'path'.
+func (d *Debugger) setBreakpoints(path string, lines []int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for line, paths := range d.breakpoints {
+		kept := paths[:0]
+		for _, p := range paths {
+			if p != path {
+				kept = append(kept, p)
+			}
+		}
+		if len(kept) == 0 {
+			delete(d.breakpoints, line)
+		} else {
+			d.breakpoints[line] = kept
+		}
+	}
+	for _, line := range lines {
+		d.breakpoints[line] = append(d.breakpoints[line], path)
+	}
+}
+
+func (d *Debugger) stackTrace() ([]StackFrame, error) {
+	var frames []StackFrame
+	err := d.onStopped(func(L *lua.State, s *stopped) error {
+		for i, e := range L.StackTrace() {
+			name := e.Name
+			if name == "" {
+				name = "?"
+			}
+			frame := StackFrame{ID: i + 1, Name: name, Line: e.CurrentLine}
+			if e.CurrentLine > 0 {
+				frame.Source = &Source{Name: e.ShortSource}
+				if strings.HasPrefix(e.Source, "@") {
+					frame.Source.Path = e.Source[1:]
+				}
+			}
+			frames = append(frames, frame)
+		}
+		return nil
+	})
+	return frames, err
+}
+
+func (d *Debugger) scopes(frameID int) ([]Scope, error) {
+	var scopes []Scope
+	err := d.onStopped(func(L *lua.State, s *stopped) error {
+		level := frameID - 1
+		if !L.GetStack(level) {
+			return fmt.Errorf("no frame %d", frameID)
+		}
+		for _, scope := range []struct {
+			name string
+			kind containerKind
+		}{{"Locals", localsContainer}, {"Upvalues", upvaluesContainer}, {"Globals", globalsContainer}} {
+			s.vars = append(s.vars, container{kind: scope.kind, level: level})
+			scopes = append(scopes, Scope{Name: scope.name, VariablesReference: len(s.vars), Expensive: scope.kind == globalsContainer})
+		}
+		return nil
+	})
+	return scopes, err
+}
+
+func (d *Debugger) variables(ref int) ([]Variable, error) {
+	vars := []Variable{}
+	err := d.onStopped(func(L *lua.State, s *stopped) error {
+		if ref <= 0 || ref > len(s.vars) {
+			return fmt.Errorf("no variables %d", ref)
+		}
+		c := s.vars[ref-1]
+		switch c.kind {
+		case localsContainer:
+			for n := 1; ; n++ {
+				name := L.GetLocal(c.level, n)
+				if name == "" {
+					break
+				}
+				// Skip the internal variables, such as "(for index)".
+				if !strings.HasPrefix(name, "(") {
+					vars = append(vars, s.variable(L, name))
+				}
+				L.Pop(1)
+			}
+		case upvaluesContainer:
+			if !L.GetStackFunction(c.level) {
+				return nil
+			}
+			for n := 1; ; n++ {
+				name := L.GetUpvalue(-1, n)
+				if name == "" {
+					break
+				}
+				vars = append(vars, s.variable(L, name))
+				L.Pop(1)
+			}
+			L.Pop(1)
+		case globalsContainer:
+			L.PushValue(lua.LUA_GLOBALSINDEX)
+			vars = s.fields(L)
+			L.Pop(1)
+		case tableContainer:
+			L.RawGeti(lua.LUA_REGISTRYINDEX, c.ref)
+			vars = s.fields(L)
+			L.Pop(1)
+		}
+		return nil
+	})
+	return vars, err
+}
+
+// fields returns the fields of the table on top of the stack, sorted by name.
+func (s *stopped) fields(L *lua.State) []Variable {
+	vars := []Variable{}
+	t := L.GetTop()
+	L.PushNil()
+	for L.Next(t) != 0 {
+		// Describe a copy of the key, lua_tostring would change it in place.
+		L.PushValue(-2)
+		name := describe(L, -1)
+		L.Pop(1)
+		vars = append(vars, s.variable(L, name))
+		L.Pop(1)
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars
+}
+
+// variable describes the value on top of the stack, tables get a reference to
+// list their fields.
+func (s *stopped) variable(L *lua.State, name string) Variable {
+	v := Variable{Name: name, Value: describe(L, -1), Type: L.Typename(int(L.Type(-1)))}
+	if L.IsTable(-1) {
+		L.PushValue(-1)
+		s.refs = append(s.refs, L.Ref(lua.LUA_REGISTRYINDEX))
+		s.vars = append(s.vars, container{kind: tableContainer, ref: s.refs[len(s.refs)-1]})
+		v.VariablesReference = len(s.vars)
+	}
+	return v
+}
+
+// describe formats the value at 'idx' as tostring does, with strings quoted.
+func describe(L *lua.State, idx int) string {
+	switch L.Type(idx) {
+	case lua.LUA_TNIL:
+		return "nil"
+	case lua.LUA_TBOOLEAN:
+		return strconv.FormatBool(L.ToBoolean(idx))
+	case lua.LUA_TNUMBER:
+		return fmt.Sprintf("%.14g", L.ToNumber(idx))
+	case lua.LUA_TSTRING:
+		return strconv.Quote(L.ToString(idx))
+	}
+	// Let tostring honour __tostring, for userdata like Account.
+	idx = absIndex(L, idx)
+	L.GetGlobal("tostring")
+	if !L.IsFunction(-1) {
+		L.Pop(1)
+		return L.Typename(int(L.Type(idx)))
+	}
+	L.PushValue(idx)
+	if err := L.Call(1, 1); err != nil {
+		return L.Typename(int(L.Type(idx)))
+	}
+	defer L.Pop(1)
+	return L.ToString(-1)
+}
+
+func absIndex(L *lua.State, idx int) int {
+	if idx < 0 && idx > lua.LUA_REGISTRYINDEX {
+		return L.GetTop() + idx + 1
+	}
+	return idx
+}
+
+// evaluate evaluates 'expr' in the frame 'frameID', as an expression or else
+// as a statement. The locals and upvalues of the frame are visible, but
+// assigning them only changes a copy.
+func (d *Debugger) evaluate(expr string, frameID int) (Variable, error) {
+	var result Variable
+	err := d.onStopped(func(L *lua.State, s *stopped) error {
+		top := L.GetTop()
+		defer L.SetTop(top)
+		if L.LoadString("return "+expr) != 0 {
+			L.Pop(1)
+			if L.LoadString(expr) != 0 {
+				return errors.New(L.ToString(-1))
+			}
+		}
+		pushEnvironment(L, frameID-1)
+		L.SetfEnv(-2)
+		if err := L.Call(0, 1); err != nil {
+			return err
+		}
+		result = s.variable(L, expr)
+		return nil
+	})
+	return result, err
+}
+
+// pushEnvironment pushes a table with the upvalues and locals of the function
+// at 'level', falling back to the globals.
+func pushEnvironment(L *lua.State, level int) {
+	L.NewTable()
+	env := L.GetTop()
+	if level >= 0 && L.GetStackFunction(level) {
+		for n := 1; ; n++ {
+			name := L.GetUpvalue(-1, n)
+			if name == "" {
+				break
+			}
+			L.SetField(env, name)
+		}
+		L.Pop(1)
+		// Later locals shadow earlier ones with the same name.
+		for n := 1; ; n++ {
+			name := L.GetLocal(level, n)
+			if name == "" {
+				break
+			}
+			if strings.HasPrefix(name, "(") {
+				L.Pop(1)
+				continue
+			}
+			L.SetField(env, name)
+		}
+	}
+	L.NewTable()
+	L.PushValue(lua.LUA_GLOBALSINDEX)
+	L.SetField(-2, "__index")
+	L.SetMetaTable(env)
+}
@@ -0,0 +1,272 @@
+package debugger
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aarzilli/golua/lua"
+)
+
+const script = `local fee = 1
+function withdraw(balance, amount)
+	local total = amount + fee
+	balance = balance - total
+	return balance
+end
+local b = withdraw(100, 30)
+result = b
+`
+
+// client is a scripted DAP client.
+type client struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *bufio.Reader
+	seq    int
+	events chan *Message
+	resps  chan *Message
+}
+
+func newClient(t *testing.T, conn net.Conn) *client {
+	c := &client{t: t, conn: conn, r: bufio.NewReader(conn), events: make(chan *Message, 16), resps: make(chan *Message)}
+	go func() {
+		for {
+			msg, err := ReadMessage(c.r)
+			if err != nil {
+				close(c.events)
+				close(c.resps)
+				return
+			}
+			if msg.Type == "event" {
+				c.events <- msg
+			} else {
+				c.resps <- msg
+			}
+		}
+	}()
+	return c
+}
+
+// request sends a request and decodes the body of its response into 'body'.
+func (c *client) request(command string, args interface{}, body interface{}) *Message {
+	c.seq++
+	req := &Message{Seq: c.seq, Type: "request", Command: command}
+	if args != nil {
+		b, _ := json.Marshal(args)
+		req.Arguments = b
+	}
+	if err := WriteMessage(c.conn, req); err != nil {
+		c.t.Fatalf("sending %s: %v", command, err)
+	}
+	select {
+	case resp := <-c.resps:
+		if resp == nil || resp.RequestSeq != req.Seq || resp.Command != command {
+			c.t.Fatalf("bad response to %s: %#v", command, resp)
+		}
+		if !resp.Success {
+			c.t.Fatalf("%s failed: %s", command, resp.ErrMessage)
+		}
+		if body != nil {
+			if err := json.Unmarshal(resp.Body, body); err != nil {
+				c.t.Fatalf("decoding %s response: %v", command, err)
+			}
+		}
+		return resp
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("no response to %s", command)
+	}
+	return nil
+}
+
+func (c *client) waitEvent(event string) *Message {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-c.events:
+			if msg == nil {
+				c.t.Fatalf("connection closed waiting for %s", event)
+			}
+			if msg.Event == event {
+				return msg
+			}
+		case <-timeout:
+			c.t.Fatalf("no %s event", event)
+		}
+	}
+}
+
+func (c *client) waitStopped(reason string) {
+	var body stoppedBody
+	json.Unmarshal(c.waitEvent("stopped").Body, &body)
+	if body.Reason != reason {
+		c.t.Fatalf("stopped for %q, expected %q", body.Reason, reason)
+	}
+}
+
+func (c *client) topFrame() StackFrame {
+	var body stackTraceBody
+	c.request("stackTrace", map[string]int{"threadId": threadID}, &body)
+	if len(body.StackFrames) == 0 {
+		c.t.Fatalf("empty stack")
+	}
+	return body.StackFrames[0]
+}
+
+func (c *client) variables(ref int) map[string]string {
+	var body variablesBody
+	c.request("variables", variablesArguments{VariablesReference: ref}, &body)
+	vars := map[string]string{}
+	for _, v := range body.Variables {
+		vars[v.Name] = v.Value
+	}
+	return vars
+}
+
+func TestDebugger(t *testing.T) {
+	dir, err := os.MkdirTemp("", "debugger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "withdraw.lua")
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	L := lua.NewState()
+	defer L.Close()
+	L.OpenLibs()
+	d := New(L)
+	defer d.Close()
+
+	server, conn := net.Pipe()
+	served := make(chan error, 1)
+	go func() { served <- d.Serve(server) }()
+	c := newClient(t, conn)
+
+	c.request("initialize", map[string]string{"adapterID": "lua"}, nil)
+	c.waitEvent("initialized")
+	c.request("attach", attachArguments{}, nil)
+	var bps setBreakpointsBody
+	c.request("setBreakpoints", setBreakpointsArguments{Source: Source{Path: path}, Breakpoints: []sourceBreakpoint{{Line: 3}}}, &bps)
+	if len(bps.Breakpoints) != 1 || !bps.Breakpoints[0].Verified {
+		t.Fatalf("breakpoint not set: %#v", bps)
+	}
+	c.request("configurationDone", nil, nil)
+
+	ran := make(chan error, 1)
+	go func() { ran <- L.DoFile(path) }()
+
+	c.waitStopped("breakpoint")
+	frame := c.topFrame()
+	if frame.Name != "withdraw" || frame.Line != 3 || frame.Source == nil || frame.Source.Path != path {
+		t.Fatalf("wrong frame at breakpoint: %#v", frame)
+	}
+
+	var scopes scopesBody
+	c.request("scopes", frameArguments{FrameID: frame.ID}, &scopes)
+	if len(scopes.Scopes) != 3 {
+		t.Fatalf("wrong scopes: %#v", scopes)
+	}
+	locals := c.variables(scopes.Scopes[0].VariablesReference)
+	if locals["balance"] != "100" || locals["amount"] != "30" {
+		t.Fatalf("wrong locals: %v", locals)
+	}
+	upvalues := c.variables(scopes.Scopes[1].VariablesReference)
+	if upvalues["fee"] != "1" {
+		t.Fatalf("wrong upvalues: %v", upvalues)
+	}
+	globals := c.variables(scopes.Scopes[2].VariablesReference)
+	if !strings.HasPrefix(globals["withdraw"], "function") {
+		t.Fatalf("wrong globals: %v", globals)
+	}
+
+	var eval evaluateBody
+	c.request("evaluate", evaluateArguments{Expression: "amount + fee", FrameID: frame.ID}, &eval)
+	if eval.Result != "31" || eval.Type != "number" {
+		t.Fatalf("wrong evaluation: %#v", eval)
+	}
+
+	c.request("next", map[string]int{"threadId": threadID}, nil)
+	c.waitStopped("step")
+	if frame := c.topFrame(); frame.Name != "withdraw" || frame.Line != 4 {
+		t.Fatalf("wrong frame after next: %#v", frame)
+	}
+
+	c.request("stepOut", map[string]int{"threadId": threadID}, nil)
+	c.waitStopped("step")
+	if frame := c.topFrame(); frame.Name == "withdraw" || frame.Line < 7 {
+		t.Fatalf("wrong frame after stepOut: %#v", frame)
+	}
+
+	c.request("continue", map[string]int{"threadId": threadID}, nil)
+	select {
+	case err := <-ran:
+		if err != nil {
+			t.Fatalf("script failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("script did not finish")
+	}
+	L.GetGlobal("result")
+	if n := L.ToInteger(-1); n != 69 {
+		t.Fatalf("wrong result %d", n)
+	}
+	L.Pop(1)
+
+	c.request("disconnect", nil, nil)
+	if err := <-served; err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	for _, tt := range []struct {
+		in, err string
+	}{
+		{"Content-Length: 12\r\n\r\n{\"seq\":1}   ", ""},
+		{"Content-Length: x\r\n\r\n", "bad Content-Length"},
+		{"Content-Length: -1\r\n\r\n", "bad Content-Length"},
+		{"Content-Length: 1073741824\r\n\r\n", "exceeds"},
+		{"Content-Length: 10\r\n\r\n{}", "EOF"},
+	} {
+		msg, err := ReadMessage(bufio.NewReader(strings.NewReader(tt.in)))
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%q: %v", tt.in, err)
+		case tt.err == "" && msg.Seq != 1:
+			t.Errorf("%q: got %#v", tt.in, msg)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%q: got error %v, want %q", tt.in, err, tt.err)
+		}
+	}
+}
+
+func TestMessageSuccess(t *testing.T) {
+	for _, tt := range []struct {
+		msg  Message
+		want string
+	}{
+		{Message{Seq: 1, Type: "response", Command: "next", RequestSeq: 2}, `"success":false`},
+		{Message{Seq: 1, Type: "response", Command: "next", RequestSeq: 2, Success: true}, `"success":true`},
+		{Message{Seq: 1, Type: "event", Event: "stopped"}, ""},
+	} {
+		b, err := json.Marshal(&tt.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.want == "" {
+			if strings.Contains(string(b), "success") {
+				t.Errorf("%s has a success field", b)
+			}
+		} else if !strings.Contains(string(b), tt.want) || strings.Count(string(b), "success") != 1 {
+			t.Errorf("%s, want %s", b, tt.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package debugger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// ErrSessionActive is returned by Serve when a client is already attached.
+var ErrSessionActive = errors.New("a debugging session is already active")
+
+// Serve runs a DAP session on 'rw', a network connection or the stdin and
+// stdout of the process, until the client disconnects. The script continues
+// when the session ends.
+func (d *Debugger) Serve(rw io.ReadWriter) error {
+	c := newConn(rw)
+	d.mu.Lock()
+	if d.session != nil {
+		d.mu.Unlock()
+		return ErrSessionActive
+	}
+	d.session = c
+	d.mu.Unlock()
+	defer d.detach()
+
+	for {
+		req, err := ReadMessage(c.r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+		done, err := d.handle(c, req)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// ListenAndServe serves the clients connecting to the TCP address 'addr', one
+// after the other.
+func (d *Debugger) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		d.Serve(conn)
+		conn.Close()
+	}
+}
+
+// detach ends the session and resumes the script.
+func (d *Debugger) detach() {
+	d.mu.Lock()
+	d.session = nil
+	d.breakpoints = map[int][]string{}
+	d.mu.Unlock()
+	atomic.StoreInt32(&d.pause, 0)
+	d.resume(stepNone)
+}
+
+// handle handles a request. It returns true when the session is over.
+func (d *Debugger) handle(c *conn, req *Message) (bool, error) {
+	switch req.Command {
+	case "initialize":
+		if err := c.respond(req, capabilities{SupportsConfigurationDoneRequest: true, SupportsEvaluateForHovers: true}, nil); err != nil {
+			return false, err
+		}
+		return false, c.event("initialized", nil)
+
+	case "attach", "launch":
+		var args attachArguments
+		if err := unmarshalArguments(req, &args); err != nil {
+			return false, c.respond(req, nil, err)
+		}
+		if args.StopOnEntry {
+			d.mu.Lock()
+			d.mode = stepIn
+			d.mu.Unlock()
+		}
+		return false, c.respond(req, nil, nil)
+
+	case "configurationDone":
+		return false, c.respond(req, nil, nil)
+
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err := unmarshalArguments(req, &args); err != nil {
+			return false, c.respond(req, nil, err)
+		}
+		lines := make([]int, len(args.Breakpoints))
+		body := setBreakpointsBody{Breakpoints: make([]breakpoint, len(args.Breakpoints))}
+		for i, bp := range args.Breakpoints {
+			lines[i] = bp.Line
+			body.Breakpoints[i] = breakpoint{Verified: true, Line: bp.Line}
+		}
+		path := args.Source.Path
+		if path == "" {
+			path = args.Source.Name
+		}
+		d.setBreakpoints(path, lines)
+		return false, c.respond(req, body, nil)
+
+	case "threads":
+		return false, c.respond(req, threadsBody{Threads: []thread{{ID: threadID, Name: "main"}}}, nil)
+
+	case "stackTrace":
+		frames, err := d.stackTrace()
+		return false, c.respond(req, stackTraceBody{StackFrames: frames, TotalFrames: len(frames)}, err)
+
+	case "scopes":
+		var args frameArguments
+		if err := unmarshalArguments(req, &args); err != nil {
+			return false, c.respond(req, nil, err)
+		}
+		scopes, err := d.scopes(args.FrameID)
+		return false, c.respond(req, scopesBody{Scopes: scopes}, err)
+
+	case "variables":
+		var args variablesArguments
+		if err := unmarshalArguments(req, &args); err != nil {
+			return false, c.respond(req, nil, err)
+		}
+		vars, err := d.variables(args.VariablesReference)
+		return false, c.respond(req, variablesBody{Variables: vars}, err)
+
+	case "evaluate":
+		var args evaluateArguments
+		if err := unmarshalArguments(req, &args); err != nil {
+			return false, c.respond(req, nil, err)
+		}
+		v, err := d.evaluate(args.Expression, args.FrameID)
+		return false, c.respond(req, evaluateBody{Result: v.Value, Type: v.Type, VariablesReference: v.VariablesReference}, err)
+
+	case "continue", "next", "stepIn", "stepOut":
+		mode := map[string]stepMode{"continue": stepNone, "next": stepOver, "stepIn": stepIn, "stepOut": stepOut}[req.Command]
+		err := d.resume(mode)
+		if req.Command == "continue" {
+			return false, c.respond(req, continueBody{AllThreadsContinued: true}, err)
+		}
+		return false, c.respond(req, nil, err)
+
+	case "pause":
+		atomic.StoreInt32(&d.pause, 1)
+		return false, c.respond(req, nil, nil)
+
+	case "disconnect":
+		if err := c.respond(req, nil, nil); err != nil {
+			return true, err
+		}
+		return true, nil
+	}
+	return false, c.respond(req, nil, fmt.Errorf("unsupported request %q", req.Command))
+}
+
+func unmarshalArguments(req *Message, args interface{}) error {
+	if len(req.Arguments) == 0 {
+		return nil
+	}
+	return json.Unmarshal(req.Arguments, args)
+}
@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"math"
+	"math/bits"
+)
+
+// bit32Lib is the bit32 library of Lua 5.2, installed by Options.Compat on the
+// Lua 5.1 engines. The numbers are taken modulo 2^32 and the results are
+// unsigned.
+var bit32Lib = &Module{Name: "bit32", Funcs: map[string]Function{
+	"band": bitFold(func(a, b uint32) uint32 { return a & b }, math.MaxUint32),
+	"bor":  bitFold(func(a, b uint32) uint32 { return a | b }, 0),
+	"bxor": bitFold(func(a, b uint32) uint32 { return a ^ b }, 0),
+	"btest": func(args []Value) ([]Value, error) {
+		results, err := bitFold(func(a, b uint32) uint32 { return a & b }, math.MaxUint32)(args)
+		if err != nil {
+			return nil, err
+		}
+		return []Value{results[0] != 0.0}, nil
+	},
+	"bnot": func(args []Value) ([]Value, error) {
+		x, err := checkBits(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []Value{float64(^x)}, nil
+	},
+	"lshift":  bitShift(func(x uint32, n int) uint32 { return shift(x, n) }),
+	"rshift":  bitShift(func(x uint32, n int) uint32 { return shift(x, -n) }),
+	"lrotate": bitShift(func(x uint32, n int) uint32 { return bits.RotateLeft32(x, n) }),
+	"rrotate": bitShift(func(x uint32, n int) uint32 { return bits.RotateLeft32(x, -n) }),
+	"arshift": bitShift(func(x uint32, n int) uint32 {
+		if n < 0 || x&0x80000000 == 0 {
+			return shift(x, -n)
+		}
+		if n >= 32 {
+			return math.MaxUint32
+		}
+		return x>>uint(n) | ^uint32(math.MaxUint32>>uint(n))
+	}),
+	"extract": func(args []Value) ([]Value, error) {
+		n, err := checkBits(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		field, width, err := bitField(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return []Value{float64(n >> uint(field) & mask(width))}, nil
+	},
+	"replace": func(args []Value) ([]Value, error) {
+		n, err := checkBits(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		v, err := checkBits(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		field, width, err := bitField(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		m := mask(width)
+		return []Value{float64(n&^(m<<uint(field)) | (v&m)<<uint(field))}, nil
+	},
+}}
+
+// checkBits returns argument 'i' modulo 2^32.
+func checkBits(args []Value, i int) (uint32, error) {
+	n, err := CheckNumber(args, i)
+	if err != nil {
+		return 0, err
+	}
+	n = math.Mod(math.Floor(n), 1<<32)
+	if n < 0 {
+		n += 1 << 32
+	}
+	return uint32(n), nil
+}
+
+func bitFold(op func(a, b uint32) uint32, init uint32) Function {
+	return func(args []Value) ([]Value, error) {
+		r := init
+		for i := range args {
+			x, err := checkBits(args, i)
+			if err != nil {
+				return nil, err
+			}
+			r = op(r, x)
+		}
+		return []Value{float64(r)}, nil
+	}
+}
+
+func bitShift(op func(x uint32, n int) uint32) Function {
+	return func(args []Value) ([]Value, error) {
+		x, err := checkBits(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		n, err := CheckNumber(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return []Value{float64(op(x, int(n)))}, nil
+	}
+}
+
+// shift shifts 'x' left by 'n' bits, right when 'n' is negative.
+func shift(x uint32, n int) uint32 {
+	switch {
+	case n <= -32 || n >= 32:
+		return 0
+	case n < 0:
+		return x >> uint(-n)
+	}
+	return x << uint(n)
+}
+
+// bitField returns the field and the optional width, 1 by default, of the
+// arguments 'i' and 'i'+1 of extract and replace.
+func bitField(args []Value, i int) (int, int, error) {
+	f, err := CheckNumber(args, i)
+	if err != nil {
+		return 0, 0, err
+	}
+	width := 1.0
+	if arg(args, i+1) != nil {
+		if width, err = CheckNumber(args, i+1); err != nil {
+			return 0, 0, err
+		}
+	}
+	switch {
+	case f < 0:
+		return 0, 0, ArgError(i, "field cannot be negative")
+	case width <= 0:
+		return 0, 0, ArgError(i+1, "width must be positive")
+	case f+width > 32:
+		return 0, 0, &Error{Kind: RuntimeError, Message: "trying to access non-existent bits"}
+	}
+	return int(f), int(width), nil
+}
+
+func mask(width int) uint32 {
+	return uint32(1<<uint(width) - 1)
+}
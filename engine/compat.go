@@ -0,0 +1,366 @@
+package engine
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// CompatGap is a difference between the engines which Options.Compat cannot
+// remove, because it is in the syntax or the VM.
+type CompatGap struct {
+	// Feature is the name of the feature.
+	Feature string
+	// Engines are the names of the engines without the feature.
+	Engines []string
+	// Probe is a chunk which runs without error on the engines having the
+	// feature, with Options.Compat.
+	Probe string
+	// Reason tells why the feature is missing.
+	Reason string
+}
+
+// CompatGaps are the known gaps between the engines with Options.Compat, the
+// tests checking that every Probe fails exactly on its Engines. golua is the C
+// Lua 5.1 library, LuaJIT has more of the Lua 5.2 features.
+var CompatGaps = []CompatGap{
+	{
+		Feature: "goto",
+		Engines: []string{"golua", "golua-luar"},
+		Probe:   "goto done\n::done::",
+		Reason:  "goto is a syntax error in Lua 5.1",
+	},
+	{
+		Feature: "_ENV",
+		Engines: []string{"golua", "golua-luar", "gopher-lua"},
+		Probe:   "assert(_ENV == _G)",
+		Reason:  "Lua 5.1 has no _ENV, the environments of functions are set with setfenv",
+	},
+	{
+		Feature: "__len of tables",
+		Engines: []string{"golua", "golua-luar"},
+		Probe:   "assert(#setmetatable({}, {__len = function() return 1 end}) == 1)",
+		Reason:  "the # operator of Lua 5.1 only calls __len for userdata",
+	},
+	{
+		Feature: "coroutines",
+		Engines: []string{"go-lua"},
+		Probe:   "assert(coroutine.wrap(function() return 1 end)() == 1)",
+		Reason:  "go-lua has no coroutine library",
+	},
+	{
+		Feature: "debug.getinfo",
+		Engines: []string{"go-lua"},
+		Probe:   "assert(debug.getinfo(1, 'l').currentline == 1)",
+		Reason:  "go-lua has no debug.getinfo",
+	},
+	{
+		Feature: "string.dump",
+		Engines: []string{"go-lua", "gopher-lua"},
+		Probe:   "assert(type(string.dump(function() end)) == 'string')",
+		Reason:  "go-lua and gopher-lua cannot dump bytecode",
+	},
+	{
+		Feature: "setfenv and getfenv of stack levels",
+		Engines: []string{"go-lua"},
+		Probe:   "local function f() assert(getfenv(1) == _G) setfenv(1, {}) end f()",
+		Reason:  "the Lua 5.2 polyfills change the _ENV upvalue of functions, the functions running are not known",
+	},
+	{
+		Feature: "module",
+		Engines: []string{"go-lua"},
+		Probe:   "module('compat_probe')",
+		Reason:  "module needs setfenv of the calling chunk",
+	},
+	{
+		Feature: "error values other than strings",
+		Engines: []string{"go-lua"},
+		Probe:   "local ok, err = pcall(error, {}) assert(type(err) == 'table')",
+		Reason:  "go-lua converts the error values to strings",
+	},
+	{
+		Feature: "formatting of concatenated numbers",
+		Engines: []string{"gopher-lua"},
+		Probe:   "assert(1/3 .. '' == '0.33333333333333')",
+		Reason:  "gopher-lua formats numbers in full when concatenating, only tostring is replaced",
+	},
+}
+
+// installCompat installs in 'e' the functions of Lua 5.1 and 5.2 it is
+// missing, so that scripts written for either run on every engine:
+//
+//	Lua 5.1 engines  table.unpack, table.pack, rawlen, bit32, load of strings
+//	                 with an environment, xpcall with arguments, math.log with
+//	                 a base, string.rep with a separator, __pairs and __ipairs,
+//	                 package.searchers
+//	go-lua           unpack, loadstring, setfenv and getfenv of functions,
+//	                 table.getn, table.maxn, math.log10, package.loaders, the
+//	                 patterns of string.find, string.match, string.gmatch and
+//	                 string.gsub, and tostring of inf and nan like the C Lua
+//	gopher-lua       tonumber of exponents, tostring of numbers with 14
+//	                 digits like the C Lua and the %f frontier of patterns
+//
+// The functions are installed when a test of the state shows they are
+// missing, see CompatGaps for what remains different.
+func installCompat(e Engine) error {
+	missing, err := e.Eval(`bit32 == nil, string.gmatch == nil or string.gsub("a (b)", "%f[%a]%a", "x") ~= "x (x)", tonumber("1e2") == nil`)
+	if err != nil {
+		return err
+	}
+	if arg(missing, 0) == true {
+		if err := e.Register(bit32Lib); err != nil {
+			return err
+		}
+	}
+	if arg(missing, 1) == true {
+		if err := e.Register(&Module{Name: "string", Funcs: patternFuncs}); err != nil {
+			return err
+		}
+	}
+	if arg(missing, 2) == true {
+		if err := e.Register(&Module{Funcs: map[string]Function{"__compat_tonumber": parseNumber}}); err != nil {
+			return err
+		}
+	}
+	return e.DoString(compatLua, "=compat")
+}
+
+// parseNumber converts a decimal or hexadecimal string to a number like the
+// tonumber of the C Lua, nil if it is not a number.
+func parseNumber(args []Value) ([]Value, error) {
+	s, err := CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	s = strings.TrimSpace(s)
+	// inf and nan are not numbers in Lua
+	if strings.ContainsAny(s, "iInN_") {
+		return []Value{nil}, nil
+	}
+	if h := strings.TrimPrefix(s, "-"); len(h) > 2 && h[0] == '0' && h[1]|0x20 == 'x' && !strings.ContainsAny(h, ".pP") {
+		n, err := strconv.ParseUint(h[2:], 16, 64)
+		if err != nil {
+			return []Value{nil}, nil
+		}
+		if strings.HasPrefix(s, "-") {
+			return []Value{-float64(n)}, nil
+		}
+		return []Value{float64(n)}, nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil && !math.IsInf(n, 0) {
+		return []Value{nil}, nil
+	}
+	return []Value{n}, nil
+}
+
+// compatLua is the Lua part of installCompat, testing the state for what it
+// is missing. __compat_tonumber is the Go tonumber of the strings gopher-lua
+// does not convert.
+const compatLua = `
+local parse = __compat_tonumber
+__compat_tonumber = nil
+
+if parse then
+	local tonumber_, type = tonumber, type
+	function tonumber(v, base)
+		if base ~= nil then
+			return tonumber_(v, base)
+		end
+		local n = tonumber_(v)
+		if n == nil and type(v) == "string" then
+			return parse(v)
+		end
+		return n
+	end
+end
+
+if tostring(1/3) ~= "0.33333333333333" or tostring(1/0) ~= "inf" then
+	local tostring_, format, type = tostring, string.format, type
+	function tostring(v)
+		if type(v) ~= "number" then
+			return tostring_(v)
+		elseif v ~= v then
+			return "nan"
+		elseif v == 1/0 then
+			return "inf"
+		elseif v == -1/0 then
+			return "-inf"
+		elseif v == 0 and 1/v < 0 then
+			return "-0"
+		end
+		return format("%.14g", v)
+	end
+end
+
+unpack = unpack or table.unpack
+table.unpack = table.unpack or unpack
+loadstring = loadstring or load
+
+if not table.pack then
+	local select = select
+	function table.pack(...)
+		return {n = select("#", ...), ...}
+	end
+end
+
+if not table.getn then
+	function table.getn(t)
+		return #t
+	end
+end
+
+if not table.maxn then
+	local next, type = next, type
+	function table.maxn(t)
+		local max = 0
+		for k in next, t do
+			if type(k) == "number" and k > max then
+				max = k
+			end
+		end
+		return max
+	end
+end
+
+if not rawlen then
+	local type, rawget, getmetatable, error = type, rawget, getmetatable, error
+	function rawlen(v)
+		if type(v) == "string" or type(v) == "table" and getmetatable(v) == nil then
+			return #v
+		elseif type(v) ~= "table" then
+			error("bad argument #1 to 'rawlen' (table or string expected)", 2)
+		end
+		local n = 0
+		while rawget(v, n + 1) ~= nil do
+			n = n + 1
+		end
+		return n
+	end
+end
+
+if not math.log10 then
+	local log = math.log
+	function math.log10(x)
+		return log(x, 10)
+	end
+end
+
+if math.log(8, 2) ~= 3 then
+	local log, log10 = math.log, math.log10
+	function math.log(x, base)
+		if base == nil then
+			return log(x)
+		elseif base == 10 then
+			return log10(x)
+		end
+		return log(x) / log(base)
+	end
+end
+
+if string.rep("a", 2, ",") ~= "a,a" then
+	local rep = string.rep
+	function string.rep(s, n, sep)
+		if sep == nil or sep == "" or n <= 1 then
+			return rep(s, n)
+		end
+		return rep(s .. sep, n - 1) .. s
+	end
+end
+
+if select(2, xpcall(function(...) return ... end, error, true)) ~= true then
+	local xpcall_, select, unpack = xpcall, select, unpack
+	function xpcall(f, handler, ...)
+		local n, args = select("#", ...), {...}
+		return xpcall_(function() return f(unpack(args, 1, n)) end, handler)
+	end
+end
+
+if not pcall(load, "return") then
+	local load_, loadstring, setfenv, type = load, loadstring, setfenv, type
+	function load(chunk, name, mode, env)
+		local f, err
+		if type(chunk) == "string" then
+			f, err = loadstring(chunk, name)
+		else
+			f, err = load_(chunk, name)
+		end
+		if f and env ~= nil then
+			setfenv(f, env)
+		end
+		return f, err
+	end
+end
+
+if not setfenv and debug and debug.getupvalue and debug.upvaluejoin then
+	local getupvalue, upvaluejoin, type, error, select = debug.getupvalue, debug.upvaluejoin, type, error, select
+	local _G = _G
+
+	-- env returns the function 'f' of getfenv or setfenv and the index of its
+	-- _ENV upvalue, nil if it has none.
+	local function env(f, name)
+		if type(f) == "number" then
+			error("bad argument #1 to '" .. name .. "' (stack levels are not supported)", 3)
+		elseif type(f) ~= "function" then
+			error("bad argument #1 to '" .. name .. "' (function expected, got " .. type(f) .. ")", 3)
+		end
+		local i = 1
+		while true do
+			local up = getupvalue(f, i)
+			if up == "_ENV" then
+				return f, i
+			elseif up == nil then
+				return f, nil
+			end
+			i = i + 1
+		end
+	end
+
+	function getfenv(f)
+		if f == nil or f == 0 then
+			return _G
+		end
+		local i
+		f, i = env(f, "getfenv")
+		if i == nil then
+			return _G
+		end
+		return (select(2, getupvalue(f, i)))
+	end
+
+	function setfenv(f, t)
+		local i
+		f, i = env(f, "setfenv")
+		if i ~= nil then
+			upvaluejoin(f, i, function() return t end, 1)
+		end
+		return f
+	end
+end
+
+local function honors(iter, event)
+	local called = false
+	iter(setmetatable({}, {[event] = function() called = true end}))
+	return called
+end
+
+for name, event in pairs({pairs = "__pairs", ipairs = "__ipairs"}) do
+	if not honors(_G[name], event) then
+		local iter, getmetatable, rawget, type = _G[name], getmetatable, rawget, type
+		_G[name] = function(t)
+			local mt = getmetatable(t)
+			local h = type(mt) == "table" and rawget(mt, event)
+			if h then
+				local f, s, c = h(t)
+				return f, s, c
+			end
+			return iter(t)
+		end
+	end
+end
+
+if package then
+	package.searchers = package.searchers or package.loaders
+	package.loaders = package.loaders or package.searchers
+end
+`
@@ -0,0 +1,100 @@
+package engine_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rickcrawford/go-lua-test/bindings"
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+// script is written like the test.lua of the demos, with functions of Lua 5.1
+// and 5.2.
+const script = `
+GLOBAL_VAR = "this is a global var"
+
+function square(m)
+  return m^2
+end
+
+function account_test()
+  local acc = Account.create(1000)
+  acc:withdrawl(100)
+  print(acc)
+  print(acc:balance(), Account.balance(acc))
+  print(acc == Account.create(900))
+end
+
+account_test()
+print(GLOBAL_VAR, square(3), 1/3, 2^53, 10/2)
+print(table.unpack({1, 2, 3}))
+print(select("#", table.unpack({1, nil, 3}, 1, 3)), table.pack(1, nil, 3).n, unpack({4, 5}))
+print(loadstring("return 1 + 1")(), load("return x", "=chunk", "t", {x = 42})())
+local f = function() return y end
+setfenv(f, {y = "env"})
+print(f(), getfenv(f).y, getfenv(print) == _G)
+print(rawlen({1, 2, 3}), bit32.band(0xff, 0x0f), bit32.lshift(1, 4))
+print(xpcall(function(a, b) return a + b end, debug.traceback, 1, 2))
+print(math.log(8, 2), string.rep("ab", 3, "-"), tonumber("1e2"))
+print(string.gsub("hello world", "(%w+)", "<%1>"))
+print(string.match("key = value", "(%w+)%s*=%s*(%w+)"))
+local t = setmetatable({}, {__pairs = function(t)
+  return function(_, k) if not k then return "k", "v" end end, t, nil
+end})
+for k, v in pairs(t) do print(k, v) end
+`
+
+const want = `account(balance=900)
+900	900
+true
+this is a global var	9	0.33333333333333	9.007199254741e+15	5
+1	2	3
+3	3	4	5
+2	42
+env	env	true
+3	15	16
+true	3
+3	ab-ab-ab	100
+<hello> <world>	2
+key	value
+k	v
+`
+
+func TestCompat(t *testing.T) {
+	for _, name := range engine.Names() {
+		var out bytes.Buffer
+		e, err := engine.New(name, engine.Options{Modules: bindings.Modules(), Stdout: &out, Compat: true})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		defer e.Close()
+		if err := e.DoString(script, "=test"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if out.String() != want {
+			t.Errorf("%s printed\n%s\nwant\n%s", name, out.String(), want)
+		}
+	}
+}
+
+func TestCompatGaps(t *testing.T) {
+	for _, gap := range engine.CompatGaps {
+		missing := map[string]bool{}
+		for _, name := range gap.Engines {
+			missing[name] = true
+		}
+		for _, name := range engine.Names() {
+			e, err := engine.New(name, engine.Options{Compat: true})
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			err = e.DoString(gap.Probe, "=probe")
+			e.Close()
+			if missing[name] && err == nil {
+				t.Errorf("%s: %s works", name, gap.Feature)
+			} else if !missing[name] && err != nil {
+				t.Errorf("%s: %s fails: %v", name, gap.Feature, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Package engine runs Lua scripts on any of the Lua implementations compared in
+// this repository behind one interface:
+//
+//	golua       aarzilli/golua, the C Lua 5.1 VM through cgo
+//	golua-luar  golua with stevedonovan/luar converting Go values to proxies
+//	go-lua      Shopify/go-lua, a Lua 5.2 VM in Go
+//	gopher-lua  yuin/gopher-lua, a Lua 5.1 VM in Go
+//
+// Go libraries are written once as Modules, with Functions working on Values,
+// and registered on every engine the same way:
+//
+//	e, err := engine.New("gopher-lua", engine.Options{Modules: []*engine.Module{myModule}})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer e.Close()
+//	results, err := e.Eval("1 + 2")
+//
+// An Engine, like the state it wraps, must not be used concurrently.
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rickcrawford/go-lua-test/tracing"
+)
+
+// Engine is a Lua state of one of the implementations.
+type Engine interface {
+	// Name is the name the engine was created with.
+	Name() string
+
+	// DoString runs 'code' as a chunk named 'name', which appears in error
+	// messages and stack traces.
+	DoString(code, name string) error
+	// DoFile runs the Lua file at 'path'.
+	DoFile(path string) error
+	// Eval evaluates a Lua expression and returns its values.
+	Eval(expr string) ([]Value, error)
+	// Call calls the global function 'name' and returns its results.
+	Call(name string, args ...Value) ([]Value, error)
+
+	// Global returns the value of a global variable.
+	Global(name string) (Value, error)
+	// SetGlobal sets a global variable.
+	SetGlobal(name string, v Value) error
+	// Register registers the functions and types of a module.
+	Register(m *Module) error
+
+	// SetContext makes the calls stop with a LimitError once 'ctx' is done,
+	// nil removes the context.
+	SetContext(ctx context.Context)
+	// SetStdout sets the writer of print and io.write, os.Stdout when nil.
+	SetStdout(w io.Writer)
+	// SetStdin sets the reader of io.read and io.lines, os.Stdin when nil.
+	SetStdin(r io.Reader)
+	// SetLogger sets the logger of the log library, slog.Default() when nil.
+	SetLogger(l *slog.Logger)
+
+	// Stats returns the counters of the engine, it can be called while the
+	// engine runs.
+	Stats() Stats
+
+	// Native returns the underlying state: a *lua.State of golua, a *lua.State
+	// of go-lua or a *lua.LState of gopher-lua.
+	Native() interface{}
+
+	// Close releases the state.
+	Close()
+}
+
+// Options configures a new Engine.
+type Options struct {
+	// Modules registered in the new state.
+	Modules []*Module
+	// MemoryLimit is the number of bytes the scripts can use before failing
+	// with a LimitError, no limit when 0. golua counts the memory allocated by
+	// the C state. go-lua and gopher-lua count the growth of the Go heap since
+	// the creation of the engine, which includes the allocations of the rest
+	// of the program.
+	MemoryLimit int64
+	// CountInstructions counts the instructions run in the Stats, with a
+	// count hook replacing the hooks set on the state, like the limits.
+	CountInstructions bool
+	// Stdout receives the output of print and io.write, os.Stdout when nil.
+	// See LineLogger to log it.
+	Stdout io.Writer
+	// Stdin is read by io.read and io.lines, os.Stdin when nil.
+	Stdin io.Reader
+	// Logger receives the messages of the log library, slog.Default() when
+	// nil.
+	Logger *slog.Logger
+	// Tracer, when set, creates a span for each call into the state and each
+	// Go function it calls, children of the span of the context set with
+	// SetContext.
+	Tracer *tracing.Tracer
+	// Clock returns the current time of the time library, time.Now when nil.
+	// Tests can set a fixed clock.
+	Clock func() time.Time
+	// UTF8Strings makes string.len and string.sub, and the len and sub methods
+	// of strings, count UTF-8 characters like utf8.len and utf8.sub. The #
+	// operator still counts bytes.
+	UTF8Strings bool
+	// Compat installs the functions of Lua 5.1 and Lua 5.2 missing from the
+	// engine, like unpack, setfenv, table.unpack and bit32, so that one script
+	// runs on all of them. CompatGaps lists the differences which remain.
+	Compat bool
+}
+
+// ErrorKind tells the reason of a failure.
+type ErrorKind int
+
+const (
+	// RuntimeError is an error raised by running code.
+	RuntimeError ErrorKind = iota
+	// SyntaxError is an error compiling a chunk.
+	SyntaxError
+	// LimitError is a call stopped by its context or the memory limit.
+	LimitError
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case SyntaxError:
+		return "syntax error"
+	case LimitError:
+		return "limit exceeded"
+	}
+	return "runtime error"
+}
+
+// Error is the error returned by all the engines for Lua errors.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+
+	// lua is set for the errors raised by Lua, which have a position.
+	lua bool
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Incomplete reports whether 'err' is the syntax error of a chunk ending
+// before a statement or expression does, as when typing a function in a REPL.
+// The error is found at the end of the chunk: "near '<eof>'" for golua, "near
+// <eof>" for go-lua and "at EOF" for gopher-lua. An "'<eof>' expected" error
+// is not, the chunk has too much code rather than too little.
+func Incomplete(err error) bool {
+	lerr, ok := err.(*Error)
+	if !ok || lerr.Kind != SyntaxError {
+		return false
+	}
+	msg := lerr.Message
+	return strings.HasSuffix(msg, "near '<eof>'") || strings.HasSuffix(msg, "near <eof>") || strings.Contains(msg, " at EOF:")
+}
+
+var engines = map[string]func(opts Options) (Engine, error){}
+
+// New creates an Engine of the implementation 'name', see Names.
+func New(name string, opts Options) (Engine, error) {
+	create, ok := engines[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown Lua engine %q, expected one of %v", name, Names())
+	}
+	e, err := create(opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range opts.Modules {
+		if err := e.Register(m); err != nil {
+			e.Close()
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// installLibraries registers the Go libraries of every state: time, utf8 and
+// re, and the compat functions with Options.Compat.
+func installLibraries(e Engine, opts Options) error {
+	if err := newClock(opts).install(e); err != nil {
+		return err
+	}
+	if err := installUTF8(e, opts.UTF8Strings); err != nil {
+		return err
+	}
+	if err := newRegexps().install(e); err != nil {
+		return err
+	}
+	if opts.Compat {
+		return installCompat(e)
+	}
+	return nil
+}
+
+// Names returns the names of the available engines, sorted.
+func Names() []string {
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,301 @@
+package engine_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+// newEngines returns an engine of each implementation, closed at the end of
+// the test.
+func newEngines(t *testing.T, opts engine.Options) map[string]engine.Engine {
+	t.Helper()
+	engines := map[string]engine.Engine{}
+	for _, name := range engine.Names() {
+		e, err := engine.New(name, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		t.Cleanup(e.Close)
+		engines[name] = e
+	}
+	return engines
+}
+
+func TestDoString(t *testing.T) {
+	tests := []struct {
+		code       string
+		kind       engine.ErrorKind
+		incomplete bool
+		ok         bool
+	}{
+		{code: "x = 1 + 2", ok: true},
+		{code: "function f(", kind: engine.SyntaxError, incomplete: true},
+		{code: "if x then", kind: engine.SyntaxError, incomplete: true},
+		{code: "s = [[text", kind: engine.SyntaxError, incomplete: true},
+		{code: "x = = 1", kind: engine.SyntaxError},
+		{code: "return x = 1", kind: engine.SyntaxError},
+		{code: "error('boom')", kind: engine.RuntimeError},
+		{code: "local t = nil; return t.x", kind: engine.RuntimeError},
+	}
+	for name, e := range newEngines(t, engine.Options{}) {
+		for _, tt := range tests {
+			err := e.DoString(tt.code, "=test")
+			if tt.ok {
+				if err != nil {
+					t.Errorf("%s: %q: %v", name, tt.code, err)
+				}
+				continue
+			}
+			var lerr *engine.Error
+			if !errors.As(err, &lerr) {
+				t.Errorf("%s: %q: got %v, want an *engine.Error", name, tt.code, err)
+				continue
+			}
+			if lerr.Kind != tt.kind {
+				t.Errorf("%s: %q: got a %v, want a %v", name, tt.code, lerr.Kind, tt.kind)
+			}
+			if engine.Incomplete(err) != tt.incomplete {
+				t.Errorf("%s: %q: Incomplete is %v for %q", name, tt.code, !tt.incomplete, lerr.Message)
+			}
+		}
+		if err := e.DoString("error('boom')", "=chunk"); err == nil || !strings.Contains(err.Error(), "chunk:1:") {
+			t.Errorf("%s: error %v is not at chunk:1", name, err)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"1 + 2", "3"},
+		{`"a" .. "b", true, nil`, `"ab" true nil`},
+		{"10 / 4, 2^10", "2.5 1024"},
+		{`{1, "two", x = {y = false}}`, `{
+  1,
+  "two",
+  x = {
+    y = false,
+  },
+}`},
+		{"{}", "{}"},
+	}
+	for name, e := range newEngines(t, engine.Options{}) {
+		for _, tt := range tests {
+			values, err := e.Eval(tt.expr)
+			if err != nil {
+				t.Errorf("%s: %s: %v", name, tt.expr, err)
+				continue
+			}
+			var got []string
+			for _, v := range values {
+				got = append(got, engine.Format(v))
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("%s: %s = %s, want %s", name, tt.expr, strings.Join(got, " "), tt.want)
+			}
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	apply := engine.Function(func(args []engine.Value) ([]engine.Value, error) {
+		f, ok := args[0].(*engine.Func)
+		if !ok {
+			return nil, engine.ArgError(0, "function expected, got "+engine.TypeName(args[0]))
+		}
+		return f.Call(args[1:]...)
+	})
+	for name, e := range newEngines(t, engine.Options{}) {
+		if err := e.DoString(`
+function divmod(a, b) return math.floor(a / b), a % b end
+function fail() error("failed") end
+function adder(n) return function(x) return x + n end end
+`, "=test"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		values, err := e.Call("divmod", 17.0, 5.0)
+		if err != nil || !reflect.DeepEqual(values, []engine.Value{3.0, 2.0}) {
+			t.Errorf("%s: divmod(17, 5) = %v, %v", name, values, err)
+		}
+		if _, err := e.Call("fail"); err == nil || !strings.Contains(err.Error(), "failed") {
+			t.Errorf("%s: fail() returned %v", name, err)
+		}
+		if _, err := e.Call("missing"); err == nil {
+			t.Errorf("%s: calling a missing function succeeded", name)
+		}
+
+		values, err = e.Call("adder", 10.0)
+		if err != nil || len(values) != 1 {
+			t.Fatalf("%s: adder(10) = %v, %v", name, values, err)
+		}
+		add, ok := values[0].(*engine.Func)
+		if !ok {
+			t.Fatalf("%s: adder(10) returned a %T", name, values[0])
+		}
+		if values, err := add.Call(5.0); err != nil || !reflect.DeepEqual(values, []engine.Value{15.0}) {
+			t.Errorf("%s: add(5) = %v, %v", name, values, err)
+		}
+
+		// Funcs called from Go functions, and pushed back to Lua.
+		if err := e.SetGlobal("apply", apply); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := e.SetGlobal("add", add); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		values, err = e.Eval("apply(add, 1), apply(function(a, b) return a * b end, 6, 7)")
+		if err != nil || !reflect.DeepEqual(values, []engine.Value{11.0, 42.0}) {
+			t.Errorf("%s: apply = %v, %v", name, values, err)
+		}
+		if _, err := e.Eval("apply(1)"); err == nil || !strings.Contains(err.Error(), "bad argument #1") {
+			t.Errorf("%s: apply(1) returned %v", name, err)
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	values := []engine.Value{
+		nil,
+		true,
+		1.5,
+		"text",
+		&engine.Table{Array: []engine.Value{1.0, "two"}},
+		&engine.Table{Fields: map[string]engine.Value{"name": "Ada", "0": 0.0, "nested": &engine.Table{Array: []engine.Value{true}}}},
+	}
+	for name, e := range newEngines(t, engine.Options{}) {
+		for _, v := range values {
+			if err := e.SetGlobal("v", v); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			got, err := e.Global("v")
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if engine.Format(got) != engine.Format(v) {
+				t.Errorf("%s: got %s, want %s", name, engine.Format(got), engine.Format(v))
+			}
+		}
+		got, err := e.Global("undefined")
+		if err != nil || got != nil {
+			t.Errorf("%s: undefined global = %v, %v", name, got, err)
+		}
+		if values, err := e.Eval("type(v.nested), v[0], #v.nested"); err != nil || !reflect.DeepEqual(values, []engine.Value{"table", 0.0, 1.0}) {
+			t.Errorf("%s: got %v, %v", name, values, err)
+		}
+	}
+}
+
+type address struct {
+	City string `lua:"city"`
+	Zip  string `lua:"zip"`
+}
+
+type customer struct {
+	Name    string             `lua:"name"`
+	Age     int                `lua:"age"`
+	Tags    []string           `lua:"tags"`
+	Scores  map[string]float64 `lua:"scores"`
+	Address *address           `lua:"address"`
+}
+
+func TestValueRoundTrip(t *testing.T) {
+	in := customer{
+		Name:    "Ada",
+		Age:     36,
+		Tags:    []string{"a", "b"},
+		Scores:  map[string]float64{"math": 9.5},
+		Address: &address{City: "London", Zip: "N1"},
+	}
+	for name, e := range newEngines(t, engine.Options{}) {
+		if err := e.SetGlobal("c", engine.ToValue(in)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := e.DoString(`
+c.age = c.age + 1
+c.tags[#c.tags + 1] = "c"
+c.scores.art = 7
+c.address.city = c.address.city:upper()
+`, "=test"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		v, err := e.Global("c")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var out customer
+		if err := engine.FromValue(v, &out); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		want := customer{
+			Name:    "Ada",
+			Age:     37,
+			Tags:    []string{"a", "b", "c"},
+			Scores:  map[string]float64{"math": 9.5, "art": 7},
+			Address: &address{City: "LONDON", Zip: "N1"},
+		}
+		if !reflect.DeepEqual(out, want) {
+			t.Errorf("%s: got %+v, want %+v", name, out, want)
+		}
+		if m, ok := engine.ToGo(v).(map[string]interface{}); !ok || m["age"] != 37.0 {
+			t.Errorf("%s: ToGo = %v", name, engine.ToGo(v))
+		}
+	}
+
+	var bad customer
+	err := engine.FromValue(&engine.Table{Fields: map[string]engine.Value{"age": "old"}}, &bad)
+	if err == nil || !strings.Contains(err.Error(), "age") {
+		t.Errorf("FromValue of a string age returned %v", err)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	cycle := engine.NewTable()
+	cycle.Set("self", cycle)
+	tests := []struct {
+		v    engine.Value
+		want string
+	}{
+		{nil, "nil"},
+		{3.0, "3"},
+		{0.1, "0.1"},
+		{"a\"b", `"a\"b"`},
+		{engine.Function(nil), "function"},
+		{&engine.Table{Fields: map[string]engine.Value{"a b": 1.0, "2": true}}, "{\n  [2] = true,\n  [\"a b\"] = 1,\n}"},
+		{cycle, "{\n  self = <cycle>,\n}"},
+		{engine.Opaque{TypeName: "thread", Address: "0x1"}, "thread: 0x1"},
+	}
+	for _, tt := range tests {
+		if got := engine.Format(tt.v); got != tt.want {
+			t.Errorf("Format(%#v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+// TestLimits checks that a state keeps enforcing its deadlines after a script
+// exceeded one, as pooled states must.
+func TestLimits(t *testing.T) {
+	for name, e := range newEngines(t, engine.Options{}) {
+		for round := 1; round <= 2; round++ {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			e.SetContext(ctx)
+			err := e.DoString("while true do end", "=loop")
+			cancel()
+			var lerr *engine.Error
+			if !errors.As(err, &lerr) || lerr.Kind != engine.LimitError {
+				t.Fatalf("%s: loop %d returned %v", name, round, err)
+			}
+		}
+		e.SetContext(context.Background())
+		if got, err := e.Eval("1 + 1"); err != nil || !reflect.DeepEqual(got, []engine.Value{2.0}) {
+			t.Errorf("%s: after the limits got %v, %v", name, got, err)
+		}
+	}
+}
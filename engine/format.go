@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Format pretty prints a value as Lua source: tables are indented, with their
+// keys sorted, and the tables already being printed are shown as <cycle>.
+// Objects print as their Type name, or Go type for proxies, and Go value.
+func Format(v Value) string {
+	var b strings.Builder
+	format(&b, v, "", map[*Table]bool{})
+	return b.String()
+}
+
+func format(b *strings.Builder, v Value, indent string, seen map[*Table]bool) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("nil")
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case float64:
+		b.WriteString(formatNumber(v))
+	case string:
+		b.WriteString(strconv.Quote(v))
+	case *Table:
+		formatTable(b, v, indent, seen)
+	case *Object:
+		formatObject(b, v)
+	case *Func, Function:
+		b.WriteString("function")
+	case Opaque:
+		b.WriteString(v.String())
+	default:
+		fmt.Fprintf(b, "%v", v)
+	}
+}
+
+func formatTable(b *strings.Builder, t *Table, indent string, seen map[*Table]bool) {
+	if seen[t] {
+		b.WriteString("<cycle>")
+		return
+	}
+	if len(t.Array) == 0 && len(t.Fields) == 0 {
+		b.WriteString("{}")
+		return
+	}
+	seen[t] = true
+	defer delete(seen, t)
+
+	inner := indent + "  "
+	b.WriteString("{\n")
+	for _, v := range t.Array {
+		b.WriteString(inner)
+		format(b, v, inner, seen)
+		b.WriteString(",\n")
+	}
+	for _, k := range sortedKeys(t.Fields) {
+		b.WriteString(inner)
+		if identifier.MatchString(k) {
+			b.WriteString(k)
+		} else if _, err := strconv.ParseFloat(k, 64); err == nil {
+			b.WriteString("[" + k + "]")
+		} else {
+			b.WriteString("[" + strconv.Quote(k) + "]")
+		}
+		b.WriteString(" = ")
+		format(b, t.Fields[k], inner, seen)
+		b.WriteString(",\n")
+	}
+	b.WriteString(indent + "}")
+}
+
+func formatObject(b *strings.Builder, o *Object) {
+	if s, ok := timeString(o); ok {
+		b.WriteString(s)
+		return
+	}
+	if s, ok := o.Value.(fmt.Stringer); ok {
+		b.WriteString(s.String())
+		return
+	}
+	if o.Value == nil {
+		b.WriteString("nil")
+		return
+	}
+	name := reflect.TypeOf(o.Value).String()
+	if o.Type != nil {
+		name = o.Type.Name
+	}
+	v := reflect.ValueOf(o.Value)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	fmt.Fprintf(b, "%s%+v", name, v.Interface())
+}
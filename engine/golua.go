@@ -0,0 +1,522 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/aarzilli/golua/lua"
+	"github.com/stevedonovan/luar"
+)
+
+func init() {
+	engines["golua"] = func(opts Options) (Engine, error) {
+		L := lua.NewState()
+		L.OpenLibs()
+		return newGolua("golua", L, false, opts)
+	}
+	engines["golua-luar"] = func(opts Options) (Engine, error) {
+		return newGolua("golua-luar", luar.Init(), true, opts)
+	}
+}
+
+// objectMetaTable is the metatable of the golua Objects without a Type.
+const objectMetaTable = "engine.Object"
+
+// golua is the engine of aarzilli/golua. Objects are userdata holding an id
+// in 'objects', removed by their __gc metamethod, as Go pointers cannot be
+// stored in the memory of the C state. With 'luar', Go values are pushed as
+// luar proxies.
+type golua struct {
+	name string
+	L    *lua.State
+	luar bool
+
+	// types maps the metatables of the registered Types, and objectMetaTable,
+	// by their address.
+	types   map[uintptr]*Type
+	objects map[uint64]*Object
+	nextID  uint64
+
+	limits limits
+	// count is set to count the instructions in 'stats'.
+	count bool
+	stats stats
+	stdio *stdio
+	log   *logging
+	trace *tracer
+	// caller is the state running the current Go function.
+	caller *lua.State
+
+	// refs are the registry references of the collected Funcs, released by
+	// the next call into the state.
+	mu   sync.Mutex
+	refs []int
+}
+
+func newGolua(name string, L *lua.State, useLuar bool, opts Options) (*golua, error) {
+	e := &golua{name: name, L: L, luar: useLuar, types: map[uintptr]*Type{}, objects: map[uint64]*Object{}, stdio: newStdio(opts)}
+	e.log = newLogging(opts, e.where)
+	e.limits.memory = opts.MemoryLimit
+	e.count = opts.CountInstructions
+	e.setHook()
+	e.registerMetaTable(objectMetaTable, &Type{Methods: map[string]Function{
+		"__tostring": func(args []Value) ([]Value, error) {
+			return []Value{Format(args[0])}, nil
+		},
+	}}, false)
+	if err := e.stdio.install(e); err != nil {
+		L.Close()
+		return nil, err
+	}
+	if err := e.log.install(e); err != nil {
+		L.Close()
+		return nil, err
+	}
+	if err := installLibraries(e, opts); err != nil {
+		L.Close()
+		return nil, err
+	}
+	if useLuar {
+		e.setTimeConverters()
+	}
+	e.trace = newTracer(opts, name, &e.limits)
+	e.stats.reset()
+	return e, nil
+}
+
+func (e *golua) Name() string        { return e.name }
+func (e *golua) Stats() Stats        { return e.stats.get() }
+func (e *golua) Native() interface{} { return e.L }
+func (e *golua) Close()              { e.L.Close() }
+
+func (e *golua) DoString(code, name string) (err error) {
+	end := e.trace.call("DoString", name)
+	defer func() { end(err) }()
+	_, err = e.run(func() int { return e.L.LoadBuffer([]byte(code), name) }, 0)
+	return err
+}
+
+func (e *golua) DoFile(path string) (err error) {
+	end := e.trace.call("DoFile", path)
+	defer func() { end(err) }()
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	_, err = e.run(func() int { return e.L.LoadFile(path) }, 0)
+	return err
+}
+
+func (e *golua) Eval(expr string) (values []Value, err error) {
+	end := e.trace.call("Eval", "eval")
+	defer func() { end(err) }()
+	return e.run(func() int { return e.L.LoadBuffer([]byte("return "+expr), "=eval") }, lua.LUA_MULTRET)
+}
+
+// run loads a chunk with 'load' and calls it.
+func (e *golua) run(load func() int, nresults int) ([]Value, error) {
+	e.release()
+	top := e.L.GetTop()
+	if r := load(); r != 0 {
+		defer e.L.SetTop(top)
+		kind := RuntimeError
+		if r == lua.LUA_ERRSYNTAX {
+			kind = SyntaxError
+		}
+		err := &Error{Kind: kind, Message: e.L.ToString(-1), lua: true}
+		e.stats.record(err, e.memory())
+		return nil, err
+	}
+	return e.call(top, 0, nresults)
+}
+
+// call calls the function at 'top'+1 with 'nargs' arguments above it.
+func (e *golua) call(top, nargs, nresults int) ([]Value, error) {
+	defer e.L.SetTop(top)
+	err := e.L.Call(nargs, nresults)
+	e.stats.record(err, e.memory())
+	if err != nil {
+		return nil, e.limits.take(&Error{Kind: RuntimeError, Message: err.Error(), lua: true})
+	}
+	e.limits.take(nil)
+	return e.values(e.L, top+1, e.L.GetTop()), nil
+}
+
+func (e *golua) SetStdout(w io.Writer)    { e.stdio.setStdout(w) }
+func (e *golua) SetStdin(r io.Reader)     { e.stdio.setStdin(r) }
+func (e *golua) SetLogger(l *slog.Logger) { e.log.setLogger(l) }
+
+// where returns the position of the function at 'level' of the stack of the
+// running Go function, 1 being its caller.
+func (e *golua) where(level int) string {
+	if e.caller == nil {
+		return ""
+	}
+	e.caller.Where(level)
+	defer e.caller.Pop(1)
+	return e.caller.ToString(-1)
+}
+
+func (e *golua) SetContext(ctx context.Context) {
+	e.limits.ctx = ctx
+	e.setHook()
+}
+
+// setHook installs the count hook checking the limits and counting the
+// instructions when needed. It replaces the hooks set on the state, by the
+// profiler for example.
+func (e *golua) setHook() {
+	if !e.limits.enabled() && !e.count {
+		e.L.SetHook(0, 0, nil)
+		return
+	}
+	e.L.SetHook(lua.LUA_MASKCOUNT, hookCount, func(L *lua.State, ev lua.HookEvent) {
+		if e.count {
+			e.stats.instructions.Add(hookCount)
+		}
+		if err := e.limits.check(e.memory); err != nil {
+			panic(L.NewError(err.Message))
+		}
+	})
+}
+
+// memory returns the bytes allocated by the state.
+func (e *golua) memory() int64 {
+	return int64(e.L.GC(lua.LUA_GCCOUNT, 0))*1024 + int64(e.L.GC(lua.LUA_GCCOUNTB, 0))
+}
+
+func (e *golua) Call(name string, args ...Value) (values []Value, err error) {
+	end := e.trace.call("Call", name)
+	defer func() { end(err) }()
+	e.release()
+	top := e.L.GetTop()
+	e.L.GetGlobal(name)
+	for _, arg := range args {
+		if err := e.push(e.L, arg); err != nil {
+			e.L.SetTop(top)
+			return nil, err
+		}
+	}
+	return e.call(top, len(args), lua.LUA_MULTRET)
+}
+
+func (e *golua) Global(name string) (Value, error) {
+	e.L.GetGlobal(name)
+	defer e.L.Pop(1)
+	return e.value(e.L, -1), nil
+}
+
+func (e *golua) SetGlobal(name string, v Value) error {
+	if err := e.push(e.L, v); err != nil {
+		return err
+	}
+	e.L.SetGlobal(name)
+	return nil
+}
+
+func (e *golua) Register(m *Module) error {
+	for _, t := range m.Types {
+		e.registerMetaTable(t.Name, t, true)
+	}
+	if m.Name == "" {
+		for name, f := range m.Funcs {
+			e.L.PushGoClosure(e.goFunction(name, f))
+			e.L.SetGlobal(name)
+		}
+		return nil
+	}
+	e.L.GetGlobal(m.Name)
+	if !e.L.IsTable(-1) {
+		e.L.Pop(1)
+		e.L.NewTable()
+		e.L.PushValue(-1)
+		e.L.SetGlobal(m.Name)
+	}
+	for name, f := range m.Funcs {
+		e.L.PushGoClosure(e.goFunction(m.Name+"."+name, f))
+		e.L.SetField(-2, name)
+	}
+	e.L.Pop(1)
+	return nil
+}
+
+// registerMetaTable creates the metatable 'name' of type 't', with its __gc
+// removing the Objects from 'objects'.
+func (e *golua) registerMetaTable(name string, t *Type, global bool) {
+	L := e.L
+	L.NewMetaTable(name)
+	e.types[L.ToPointer(-1)] = t
+	L.PushValue(-1)
+	L.SetField(-2, "__index")
+	for method, f := range t.Methods {
+		if method != "__gc" {
+			L.SetMetaMethod(method, e.goFunction(name+"."+method, f))
+		}
+	}
+	gc := t.Methods["__gc"]
+	L.SetMetaMethod("__gc", func(L *lua.State) int {
+		id := *(*uint64)(L.ToUserdata(1))
+		if gc != nil {
+			e.goFunction(name+".__gc", gc)(L)
+		}
+		delete(e.objects, id)
+		return 0
+	})
+	if global {
+		L.SetGlobal(name)
+	} else {
+		L.Pop(1)
+	}
+}
+
+// goFunction wraps 'f' for golua, raising its errors as Lua errors. 'name' is
+// the name of its span, the name of the Go function when empty.
+func (e *golua) goFunction(name string, f Function) lua.LuaGoFunction {
+	return func(L *lua.State) int {
+		e.release()
+		caller := e.caller
+		e.caller = L
+		defer func() { e.caller = caller }()
+		end := e.trace.callback(name, f, e.where)
+		results, err := f(e.values(L, 1, L.GetTop()))
+		if err == nil {
+			L.SetTop(0)
+			for _, r := range results {
+				if err = e.push(L, r); err != nil {
+					break
+				}
+			}
+		}
+		end(err)
+		if err != nil {
+			msg := err.Error()
+			if lerr, ok := err.(*Error); !ok || !lerr.lua {
+				L.Where(1)
+				msg = L.ToString(-1) + msg
+			}
+			panic(L.NewError(msg))
+		}
+		return len(results)
+	}
+}
+
+func (e *golua) push(L *lua.State, v Value) error {
+	switch v := v.(type) {
+	case nil:
+		L.PushNil()
+	case bool:
+		L.PushBoolean(v)
+	case float64:
+		L.PushNumber(v)
+	case string:
+		L.PushString(v)
+	case *Table:
+		L.CreateTable(len(v.Array), len(v.Fields))
+		for i, item := range v.Array {
+			if err := e.push(L, item); err != nil {
+				L.Pop(1)
+				return err
+			}
+			L.RawSeti(-2, i+1)
+		}
+		for k, item := range v.Fields {
+			if n, ok := fieldKey(k); ok {
+				L.PushNumber(n)
+			} else {
+				L.PushString(k)
+			}
+			if err := e.push(L, item); err != nil {
+				L.Pop(2)
+				return err
+			}
+			L.RawSet(-3)
+		}
+	case *Object:
+		if e.luar && v.Type == nil {
+			return e.pushProxy(L, v.Value)
+		}
+		name := objectMetaTable
+		if v.Type != nil {
+			name = v.Type.Name
+		}
+		L.LGetMetaTable(name)
+		if !L.IsTable(-1) {
+			L.Pop(1)
+			return &Error{Kind: RuntimeError, Message: "type " + name + " is not registered"}
+		}
+		e.nextID++
+		e.objects[e.nextID] = v
+		*(*uint64)(L.NewUserdata(8)) = e.nextID
+		L.Insert(-2)
+		L.SetMetaTable(-2)
+	case Function:
+		L.PushGoClosure(e.goFunction("", v))
+	case *Func:
+		if v.engine != Engine(e) {
+			return &Error{Kind: RuntimeError, Message: "cannot push a function of another engine"}
+		}
+		L.RawGeti(lua.LUA_REGISTRYINDEX, v.ref.(int))
+	case Opaque:
+		return &Error{Kind: RuntimeError, Message: "cannot push a " + v.TypeName}
+	case time.Time, time.Duration:
+		return e.push(L, ToValue(v))
+	default:
+		if e.luar {
+			return e.pushProxy(L, v)
+		}
+		return e.push(L, ToValue(v))
+	}
+	return nil
+}
+
+// values converts the values from 'from' to 'to' of the stack.
+func (e *golua) values(L *lua.State, from, to int) []Value {
+	values := make([]Value, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		values = append(values, e.value(L, i))
+	}
+	return values
+}
+
+func (e *golua) value(L *lua.State, idx int) Value {
+	if idx < 0 {
+		idx = L.GetTop() + idx + 1
+	}
+	return e.convert(L, idx, map[uintptr]*Table{})
+}
+
+func (e *golua) convert(L *lua.State, idx int, seen map[uintptr]*Table) Value {
+	switch L.Type(idx) {
+	case lua.LUA_TNIL, lua.LUA_TNONE:
+		return nil
+	case lua.LUA_TBOOLEAN:
+		return L.ToBoolean(idx)
+	case lua.LUA_TNUMBER:
+		return L.ToNumber(idx)
+	case lua.LUA_TSTRING:
+		return L.ToString(idx)
+	case lua.LUA_TTABLE:
+		ptr := L.ToPointer(idx)
+		if t, ok := seen[ptr]; ok {
+			return t
+		}
+		t := NewTable()
+		seen[ptr] = t
+		n := int(L.ObjLen(idx))
+		for i := 1; i <= n; i++ {
+			L.RawGeti(idx, i)
+			if L.IsNil(-1) {
+				L.Pop(1)
+				n = i - 1
+				break
+			}
+			t.Array = append(t.Array, e.convert(L, L.GetTop(), seen))
+			L.Pop(1)
+		}
+		L.PushNil()
+		for L.Next(idx) != 0 {
+			if L.Type(-2) == lua.LUA_TNUMBER {
+				if k := L.ToNumber(-2); k == float64(int(k)) && k >= 1 && int(k) <= n {
+					L.Pop(1)
+					continue
+				}
+			}
+			t.Fields[keyString(e.convert(L, L.GetTop()-1, seen))] = e.convert(L, L.GetTop(), seen)
+			L.Pop(1)
+		}
+		return t
+	case lua.LUA_TFUNCTION:
+		return e.function(L, idx)
+	case lua.LUA_TUSERDATA:
+		if L.IsGoFunction(idx) {
+			return e.function(L, idx)
+		}
+		if L.IsGoStruct(idx) {
+			return &Object{Value: L.ToGoStruct(idx)}
+		}
+		if L.GetMetaTable(idx) {
+			t, ok := e.types[L.ToPointer(-1)]
+			L.Pop(1)
+			if ok {
+				if o := e.objects[*(*uint64)(L.ToUserdata(idx))]; o != nil {
+					return o
+				}
+				return &Object{Type: t}
+			}
+		}
+		if e.luar {
+			var v interface{}
+			if err := luar.LuaToGo(L, idx, &v); err == nil {
+				return &Object{Value: v}
+			}
+		}
+	}
+	return Opaque{TypeName: L.LTypename(idx), Address: formatAddress(L.ToPointer(idx))}
+}
+
+// setTimeConverters makes luar convert the time.Time and time.Duration values,
+// the fields of the structs it proxies included, to Time and Duration Objects
+// and back, as ToValue and FromValue do.
+func (e *golua) setTimeConverters() {
+	for _, t := range []reflect.Type{timeType, durationType} {
+		luar.SetConverter(e.L, t, luar.Converter{
+			Push: func(L *lua.State, v reflect.Value) error {
+				return e.push(L, ToValue(v.Interface()))
+			},
+			Read: func(L *lua.State, idx int, v reflect.Value) error {
+				return fromValue(e.value(L, idx), v, "")
+			},
+		})
+	}
+}
+
+// pushProxy pushes 'v' with luar, or nothing when its MarshalLua method fails.
+func (e *golua) pushProxy(L *lua.State, v interface{}) error {
+	if err := luar.GoToLuaProxy(L, v); err != nil {
+		L.Pop(1)
+		return err
+	}
+	return nil
+}
+
+// function returns a Func calling the function at 'idx' through a registry
+// reference, released once the Func is collected. The reference is shared by
+// all the threads of the state; the Func always runs on the main one, e.L.
+func (e *golua) function(L *lua.State, idx int) *Func {
+	L.PushValue(idx)
+	ref := L.Ref(lua.LUA_REGISTRYINDEX)
+	f := &Func{engine: e, ref: ref}
+	f.call = func(args []Value) ([]Value, error) {
+		top := e.L.GetTop()
+		e.L.RawGeti(lua.LUA_REGISTRYINDEX, ref)
+		for _, arg := range args {
+			if err := e.push(e.L, arg); err != nil {
+				e.L.SetTop(top)
+				return nil, err
+			}
+		}
+		return e.call(top, len(args), lua.LUA_MULTRET)
+	}
+	runtime.SetFinalizer(f, func(*Func) {
+		e.mu.Lock()
+		e.refs = append(e.refs, ref)
+		e.mu.Unlock()
+	})
+	return f
+}
+
+// release releases the references of the collected Funcs.
+func (e *golua) release() {
+	e.mu.Lock()
+	refs := e.refs
+	e.refs = nil
+	e.mu.Unlock()
+	for _, ref := range refs {
+		e.L.Unref(lua.LUA_REGISTRYINDEX, ref)
+	}
+}
@@ -0,0 +1,393 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+func init() {
+	engines["gopher-lua"] = func(opts Options) (Engine, error) {
+		e := &gopher{L: lua.NewState(), heap: goHeap(), stdio: newStdio(opts)}
+		e.log = newLogging(opts, e.where)
+		e.limits.memory = opts.MemoryLimit
+		if err := e.stdio.install(e); err != nil {
+			e.L.Close()
+			return nil, err
+		}
+		if err := e.log.install(e); err != nil {
+			e.L.Close()
+			return nil, err
+		}
+		if err := installLibraries(e, opts); err != nil {
+			e.L.Close()
+			return nil, err
+		}
+		e.trace = newTracer(opts, "gopher-lua", &e.limits)
+		e.stats.reset()
+		return e, nil
+	}
+}
+
+// gopher is the engine of yuin/gopher-lua. Objects are stored in userdata
+// directly.
+type gopher struct {
+	L *lua.LState
+
+	limits limits
+	stats  stats
+	stdio  *stdio
+	log    *logging
+	trace  *tracer
+	// caller is the state running the current Go function.
+	caller *lua.LState
+	// heap is the size of the Go heap when the engine was created.
+	heap int64
+}
+
+func (e *gopher) Name() string        { return "gopher-lua" }
+func (e *gopher) Stats() Stats        { return e.stats.get() }
+func (e *gopher) Native() interface{} { return e.L }
+func (e *gopher) Close()              { e.L.Close() }
+
+func (e *gopher) DoString(code, name string) (err error) {
+	end := e.trace.call("DoString", name)
+	defer func() { end(err) }()
+	_, err = e.run(code, name, 0)
+	return err
+}
+
+func (e *gopher) DoFile(path string) (err error) {
+	end := e.trace.call("DoFile", path)
+	defer func() { end(err) }()
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	_, err = e.run(string(code), path, 0)
+	return err
+}
+
+func (e *gopher) Eval(expr string) (values []Value, err error) {
+	end := e.trace.call("Eval", "eval")
+	defer func() { end(err) }()
+	return e.run("return "+expr, "=eval", lua.MultRet)
+}
+
+// run runs 'code'. The chunk names starting with "=" or "@" are used as is by
+// gopher-lua, they are removed like the C Lua does.
+func (e *gopher) run(code, name string, nresults int) ([]Value, error) {
+	if strings.HasPrefix(name, "=") || strings.HasPrefix(name, "@") {
+		name = name[1:]
+	}
+	fn, err := e.L.Load(strings.NewReader(code), name)
+	if err != nil {
+		lerr := luaError(err)
+		e.stats.record(lerr, e.memory())
+		return nil, lerr
+	}
+	return e.call(e.L, fn, nil, nresults)
+}
+
+// call calls 'fn' with 'args' from the Go side.
+func (e *gopher) call(L *lua.LState, fn lua.LValue, args []Value, nresults int) ([]Value, error) {
+	stop := func() {}
+	if e.limits.enabled() && L.Context() == nil {
+		stop = e.watch(L)
+	}
+	top := L.GetTop()
+	defer L.SetTop(top)
+	L.Push(fn)
+	for _, arg := range args {
+		v, err := e.lvalue(L, arg)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		L.Push(v)
+	}
+	err := L.PCall(len(args), nresults, nil)
+	stop()
+	e.stats.record(err, e.memory())
+	if err != nil {
+		return nil, e.limits.take(luaError(err))
+	}
+	e.limits.take(nil)
+	return e.values(L, top+1, L.GetTop()), nil
+}
+
+func (e *gopher) SetStdout(w io.Writer)    { e.stdio.setStdout(w) }
+func (e *gopher) SetStdin(r io.Reader)     { e.stdio.setStdin(r) }
+func (e *gopher) SetLogger(l *slog.Logger) { e.log.setLogger(l) }
+
+// where returns the position of the function at 'level' of the stack of the
+// running Go function, 1 being its caller.
+func (e *gopher) where(level int) string {
+	if e.caller == nil {
+		return ""
+	}
+	return e.caller.Where(level)
+}
+
+func (e *gopher) SetContext(ctx context.Context) {
+	e.limits.ctx = ctx
+}
+
+// memory returns the growth of the Go heap since the creation of the engine.
+func (e *gopher) memory() int64 {
+	return goHeap() - e.heap
+}
+
+// watch gives the state a context canceled when a limit is exceeded, as
+// gopher-lua has no hooks but checks its context, and returns the function
+// removing it.
+func (e *gopher) watch(L *lua.LState) func() {
+	parent := e.limits.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	L.SetContext(ctx)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				e.limits.check(e.memory)
+				return
+			case <-ticker.C:
+				if e.limits.check(e.memory) != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		cancel()
+		L.RemoveContext()
+	}
+}
+
+// luaError converts the errors of gopher-lua, leaving out their stack trace.
+func luaError(err error) error {
+	apiErr, ok := err.(*lua.ApiError)
+	if !ok {
+		return err
+	}
+	kind := RuntimeError
+	if apiErr.Type == lua.ApiErrorSyntax {
+		kind = SyntaxError
+	}
+	return &Error{Kind: kind, Message: strings.TrimSpace(apiErr.Object.String()), lua: true}
+}
+
+func (e *gopher) Call(name string, args ...Value) (values []Value, err error) {
+	end := e.trace.call("Call", name)
+	defer func() { end(err) }()
+	return e.call(e.L, e.L.GetGlobal(name), args, lua.MultRet)
+}
+
+func (e *gopher) Global(name string) (Value, error) {
+	return e.value(e.L, e.L.GetGlobal(name)), nil
+}
+
+func (e *gopher) SetGlobal(name string, v Value) error {
+	lv, err := e.lvalue(e.L, v)
+	if err != nil {
+		return err
+	}
+	e.L.SetGlobal(name, lv)
+	return nil
+}
+
+func (e *gopher) Register(m *Module) error {
+	L := e.L
+	for _, t := range m.Types {
+		mt := L.NewTypeMetatable(t.Name)
+		mt.RawSetString("__index", mt)
+		for method, f := range t.Methods {
+			mt.RawSetString(method, L.NewFunction(e.goFunction(t.Name+"."+method, f)))
+		}
+		L.SetGlobal(t.Name, mt)
+	}
+	if m.Name == "" {
+		for name, f := range m.Funcs {
+			L.SetGlobal(name, L.NewFunction(e.goFunction(name, f)))
+		}
+		return nil
+	}
+	tbl, ok := L.GetGlobal(m.Name).(*lua.LTable)
+	if !ok {
+		tbl = L.NewTable()
+		L.SetGlobal(m.Name, tbl)
+	}
+	for name, f := range m.Funcs {
+		tbl.RawSetString(name, L.NewFunction(e.goFunction(m.Name+"."+name, f)))
+	}
+	return nil
+}
+
+// goFunction wraps 'f' for gopher-lua, raising its errors as Lua errors.
+// 'name' is the name of its span, the name of the Go function when empty.
+func (e *gopher) goFunction(name string, f Function) lua.LGFunction {
+	return func(L *lua.LState) int {
+		caller := e.caller
+		e.caller = L
+		defer func() { e.caller = caller }()
+		end := e.trace.callback(name, f, e.where)
+		results, err := f(e.values(L, 1, L.GetTop()))
+		var lvalues []lua.LValue
+		if err == nil {
+			for _, r := range results {
+				var lv lua.LValue
+				if lv, err = e.lvalue(L, r); err != nil {
+					break
+				}
+				lvalues = append(lvalues, lv)
+			}
+		}
+		end(err)
+		if err != nil {
+			if lerr, ok := err.(*Error); ok && lerr.lua {
+				L.Error(lua.LString(err.Error()), 0)
+			}
+			L.RaiseError("%s", err.Error())
+		}
+		for _, lv := range lvalues {
+			L.Push(lv)
+		}
+		return len(lvalues)
+	}
+}
+
+func (e *gopher) lvalue(L *lua.LState, v Value) (lua.LValue, error) {
+	switch v := v.(type) {
+	case nil:
+		return lua.LNil, nil
+	case bool:
+		return lua.LBool(v), nil
+	case float64:
+		return lua.LNumber(v), nil
+	case string:
+		return lua.LString(v), nil
+	case *Table:
+		tbl := L.CreateTable(len(v.Array), len(v.Fields))
+		for i, item := range v.Array {
+			lv, err := e.lvalue(L, item)
+			if err != nil {
+				return nil, err
+			}
+			tbl.RawSetInt(i+1, lv)
+		}
+		for k, item := range v.Fields {
+			lv, err := e.lvalue(L, item)
+			if err != nil {
+				return nil, err
+			}
+			if n, ok := fieldKey(k); ok {
+				tbl.RawSet(lua.LNumber(n), lv)
+			} else {
+				tbl.RawSetString(k, lv)
+			}
+		}
+		return tbl, nil
+	case *Object:
+		ud := L.NewUserData()
+		ud.Value = v
+		if v.Type != nil {
+			mt, ok := L.GetTypeMetatable(v.Type.Name).(*lua.LTable)
+			if !ok {
+				return nil, &Error{Kind: RuntimeError, Message: "type " + v.Type.Name + " is not registered"}
+			}
+			ud.Metatable = mt
+		}
+		return ud, nil
+	case Function:
+		return L.NewFunction(e.goFunction("", v)), nil
+	case *Func:
+		if v.engine != Engine(e) {
+			return nil, &Error{Kind: RuntimeError, Message: "cannot push a function of another engine"}
+		}
+		return v.ref.(*lua.LFunction), nil
+	case Opaque:
+		return nil, &Error{Kind: RuntimeError, Message: "cannot push a " + v.TypeName}
+	}
+	return e.lvalue(L, ToValue(v))
+}
+
+// values converts the values from 'from' to 'to' of the stack.
+func (e *gopher) values(L *lua.LState, from, to int) []Value {
+	values := make([]Value, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		values = append(values, e.value(L, L.Get(i)))
+	}
+	return values
+}
+
+func (e *gopher) value(L *lua.LState, lv lua.LValue) Value {
+	return e.convert(L, lv, map[*lua.LTable]*Table{})
+}
+
+func (e *gopher) convert(L *lua.LState, lv lua.LValue, seen map[*lua.LTable]*Table) Value {
+	switch lv := lv.(type) {
+	case *lua.LNilType:
+		return nil
+	case lua.LBool:
+		return bool(lv)
+	case lua.LNumber:
+		return float64(lv)
+	case lua.LString:
+		return string(lv)
+	case *lua.LTable:
+		if t, ok := seen[lv]; ok {
+			return t
+		}
+		t := NewTable()
+		seen[lv] = t
+		n := lv.Len()
+		for i := 1; i <= n; i++ {
+			item := lv.RawGetInt(i)
+			if item == lua.LNil {
+				n = i - 1
+				break
+			}
+			t.Array = append(t.Array, e.convert(L, item, seen))
+		}
+		lv.ForEach(func(k, v lua.LValue) {
+			if k, ok := k.(lua.LNumber); ok && float64(k) == float64(int(k)) && int(k) >= 1 && int(k) <= n {
+				return
+			}
+			t.Fields[keyString(e.convert(L, k, seen))] = e.convert(L, v, seen)
+		})
+		return t
+	case *lua.LFunction:
+		f := &Func{engine: e, ref: lv}
+		f.call = func(args []Value) ([]Value, error) {
+			return e.call(L, lv, args, lua.MultRet)
+		}
+		return f
+	case *lua.LUserData:
+		if o, ok := lv.Value.(*Object); ok {
+			return o
+		}
+	}
+	s := lv.String()
+	if i := strings.Index(s, ": "); i >= 0 {
+		s = s[i+2:]
+	}
+	return Opaque{TypeName: lv.Type().String(), Address: s}
+}
@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"runtime/metrics"
+)
+
+// hookCount is the number of instructions between two checks of the limits
+// by the engines with a count hook.
+const hookCount = 1000
+
+// limits checks the context and the memory limit of an engine.
+type limits struct {
+	ctx    context.Context
+	memory int64
+	// err is the limit exceeded by the running call.
+	err *Error
+}
+
+// check returns the error of the first limit exceeded, given the memory used
+// by the state, and records it for take.
+func (l *limits) check(used func() int64) *Error {
+	if l.ctx != nil {
+		if err := l.ctx.Err(); err != nil {
+			l.err = &Error{Kind: LimitError, Message: err.Error()}
+			return l.err
+		}
+	}
+	if l.memory > 0 {
+		if n := used(); n > l.memory {
+			l.err = &Error{Kind: LimitError, Message: fmt.Sprintf("memory limit exceeded: %d bytes used, limit %d", n, l.memory)}
+			return l.err
+		}
+	}
+	return nil
+}
+
+// enabled reports whether there are limits to check.
+func (l *limits) enabled() bool {
+	return l.ctx != nil || l.memory > 0
+}
+
+// take returns the limit error of the call that just failed with 'err', or
+// 'err'.
+func (l *limits) take(err error) error {
+	lerr := l.err
+	l.err = nil
+	if err == nil || lerr == nil {
+		return err
+	}
+	return lerr
+}
+
+// goHeap returns the bytes of the Go heap in use. It is the memory used by the
+// engines written in Go, which share it with the rest of the program.
+func goHeap() int64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	return int64(sample[0].Value.Uint64())
+}
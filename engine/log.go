@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// logging is the log library of a state, writing to its slog.Logger:
+//
+//	log.info("withdrawl", {amount = 100})
+//	local l = log.with{account = id}
+//	l.warn("low balance", {balance = b})
+//
+// log.debug, log.info, log.warn and log.error take a message and an optional
+// table of fields, log.with returns a library adding its fields to every
+// message. The messages have the file and line of their call as source.
+type logging struct {
+	logger *slog.Logger
+	// where returns the position of the function at a level of the stack, as
+	// in the messages of errors.
+	where func(level int) string
+}
+
+func newLogging(opts Options, where func(level int) string) *logging {
+	g := &logging{where: where}
+	g.setLogger(opts.Logger)
+	return g
+}
+
+func (g *logging) setLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	g.logger = logger
+}
+
+// install registers the log library in 'e'.
+func (g *logging) install(e Engine) error {
+	return e.Register(&Module{Name: "log", Funcs: g.funcs(func() *slog.Logger { return g.logger })})
+}
+
+// funcs returns the functions of a log library writing to 'logger'.
+func (g *logging) funcs(logger func() *slog.Logger) map[string]Function {
+	return map[string]Function{
+		"debug": g.log(logger, slog.LevelDebug),
+		"info":  g.log(logger, slog.LevelInfo),
+		"warn":  g.log(logger, slog.LevelWarn),
+		"error": g.log(logger, slog.LevelError),
+		"with": func(args []Value) ([]Value, error) {
+			if _, ok := arg(args, 1).(*Table); ok {
+				// l:with{...}
+				args = args[1:]
+			}
+			fields, err := logFields(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			l := logger().With(fields...)
+			lib := NewTable()
+			for name, f := range g.funcs(func() *slog.Logger { return l }) {
+				lib.Set(name, f)
+			}
+			return []Value{lib}, nil
+		},
+	}
+}
+
+func (g *logging) log(logger func() *slog.Logger, level slog.Level) Function {
+	return func(args []Value) ([]Value, error) {
+		args = method(args)
+		msg, err := CheckString(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		fields, err := logFields(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		if source := g.source(); source != nil {
+			fields = append(fields, slog.Any(slog.SourceKey, source))
+		}
+		logger().Log(context.Background(), level, msg, fields...)
+		return nil, nil
+	}
+}
+
+// source returns the file and line of the Lua code calling the log function.
+func (g *logging) source() *slog.Source {
+	where := trimWhere(g.where(1))
+	i := strings.LastIndexByte(where, ':')
+	if i < 0 {
+		return nil
+	}
+	line, err := strconv.Atoi(where[i+1:])
+	if err != nil {
+		return nil
+	}
+	return &slog.Source{File: where[:i], Line: line}
+}
+
+// method removes the library from the arguments of a log function called as
+// a method, l:info("msg") rather than l.info("msg").
+func method(args []Value) []Value {
+	if _, ok := arg(args, 0).(*Table); ok {
+		switch arg(args, 1).(type) {
+		case string, float64:
+			return args[1:]
+		}
+	}
+	return args
+}
+
+// logFields converts the table argument 'i', if any, to attributes.
+func logFields(args []Value, i int) ([]interface{}, error) {
+	switch t := arg(args, i).(type) {
+	case nil:
+		return nil, nil
+	case *Table:
+		var fields []interface{}
+		for _, k := range t.Keys() {
+			fields = append(fields, slog.Any(k, ToGo(t.Get(k))))
+		}
+		return fields, nil
+	}
+	return nil, ArgError(i, "table expected, got "+TypeName(arg(args, i)))
+}
@@ -0,0 +1,135 @@
+package engine_test
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+// record is a message logged to a captureHandler, with its attributes and
+// those of the logger.
+type record struct {
+	Level   slog.Level
+	Message string
+	Attrs   map[string]interface{}
+}
+
+// captureHandler is a slog.Handler keeping the records it handles.
+type captureHandler struct {
+	records *[]record
+	attrs   []slog.Attr
+}
+
+func newCapture() (*slog.Logger, *[]record) {
+	records := &[]record{}
+	return slog.New(&captureHandler{records: records}), records
+}
+
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *captureHandler) Handle(_ context.Context, r slog.Record) error {
+	rec := record{Level: r.Level, Message: r.Message, Attrs: map[string]interface{}{}}
+	add := func(a slog.Attr) bool {
+		rec.Attrs[a.Key] = a.Value.Any()
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+	*h.records = append(*h.records, rec)
+	return nil
+}
+
+func (h *captureHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &captureHandler{records: h.records, attrs: append(append([]slog.Attr(nil), h.attrs...), attrs...)}
+}
+
+func (h *captureHandler) WithGroup(string) slog.Handler { return h }
+
+func TestLog(t *testing.T) {
+	const script = `log.info("hello", {user = "ada", n = 2})
+log.debug("d") log.warn("w") log.error("e")
+local l = log.with{account = 7}
+l.warn("low", {balance = 1})
+l:error("method", {list = {1, 2}})
+`
+	source := func(line int) *slog.Source { return &slog.Source{File: "test.lua", Line: line} }
+	want := []record{
+		{slog.LevelInfo, "hello", map[string]interface{}{"user": "ada", "n": 2.0, "source": source(1)}},
+		{slog.LevelDebug, "d", map[string]interface{}{"source": source(2)}},
+		{slog.LevelWarn, "w", map[string]interface{}{"source": source(2)}},
+		{slog.LevelError, "e", map[string]interface{}{"source": source(2)}},
+		{slog.LevelWarn, "low", map[string]interface{}{"account": 7.0, "balance": 1.0, "source": source(4)}},
+		{slog.LevelError, "method", map[string]interface{}{"account": 7.0, "list": []interface{}{1.0, 2.0}, "source": source(5)}},
+	}
+	for _, name := range engine.Names() {
+		logger, records := newCapture()
+		e, err := engine.New(name, engine.Options{Logger: logger})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		defer e.Close()
+		if err := e.DoString(script, "=test.lua"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(*records, want) {
+			t.Errorf("%s logged\n%+v\nwant\n%+v", name, *records, want)
+		}
+
+		for _, code := range []string{`log.info({})`, `log.info("m", 5)`, `log.with(1)`} {
+			if err := e.DoString(code, "=test.lua"); err == nil || !strings.Contains(err.Error(), "bad argument") {
+				t.Errorf("%s: %s returned %v", name, code, err)
+			}
+		}
+
+		other, moved := newCapture()
+		e.SetLogger(other)
+		if err := e.DoString(`log.info("moved")`, "=test.lua"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(*moved) != 1 || (*moved)[0].Message != "moved" {
+			t.Errorf("%s: SetLogger logged %+v", name, *moved)
+		}
+	}
+}
+
+func TestLineLogger(t *testing.T) {
+	for _, name := range engine.Names() {
+		logger, records := newCapture()
+		out := engine.NewLineLogger(logger, "test.lua", "req-1")
+		out.Level = slog.LevelWarn
+		e, err := engine.New(name, engine.Options{Stdout: out})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := e.DoString(`print("one", 1) io.write("two\r\nthr") io.write("ee")`, "=test.lua"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		e.Close()
+		if len(*records) != 2 {
+			t.Fatalf("%s: logged %+v before Flush", name, *records)
+		}
+		out.Flush()
+		out.Flush()
+		attrs := map[string]interface{}{"script": "test.lua", "request_id": "req-1"}
+		want := []record{
+			{slog.LevelWarn, "one\t1", attrs},
+			{slog.LevelWarn, "two", attrs},
+			{slog.LevelWarn, "three", attrs},
+		}
+		if !reflect.DeepEqual(*records, want) {
+			t.Errorf("%s logged\n%+v\nwant\n%+v", name, *records, want)
+		}
+	}
+
+	logger, records := newCapture()
+	engine.NewLineLogger(logger, "", "").Write([]byte("untagged\n"))
+	if len(*records) != 1 || len((*records)[0].Attrs) != 0 {
+		t.Errorf("untagged LineLogger logged %+v", *records)
+	}
+}
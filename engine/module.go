@@ -0,0 +1,91 @@
+package engine
+
+import "strconv"
+
+// Module is a library of Go functions and types.
+type Module struct {
+	// Name is the name of the global table holding Funcs. When empty, Funcs are
+	// registered as globals.
+	Name  string
+	Funcs map[string]Function
+	// Types are registered as globals.
+	Types []*Type
+	// Params are the parameters of Funcs, for the linter and the API manifest:
+	// their names, the optional ones ending with "?", and "..." last for a
+	// variable number, each optionally followed by a space and its Lua type,
+	// like "init? number". The functions without Params are not checked.
+	// Since every Function takes and returns []Value, Params and Results are
+	// the only source of these signatures, unlike the luar maps whose Go
+	// types the manifest reads, and they must be kept in step with Funcs.
+	Params map[string][]string
+	// Results are the Lua types of the results of Funcs, like "string" or
+	// "Account", for the API manifest.
+	Results map[string][]string
+}
+
+// Type is a userdata type, registered like the Account type of luac/main.go:
+// a global metatable whose __index is itself, holding both the methods and the
+// metamethods, so that
+//
+//	local acc = Account.create(1000)
+//	acc:withdrawl(100)
+//	print(Account.balance(acc))
+//
+// work. Functions returning an *Object of the Type create its userdata.
+type Type struct {
+	Name    string
+	Methods map[string]Function
+	// Params and Results describe Methods like those of a Module, the object
+	// being the first parameter, "self", of the methods called with ':'.
+	Params  map[string][]string
+	Results map[string][]string
+}
+
+// New returns an Object of type 't' holding 'v'.
+func (t *Type) New(v interface{}) *Object {
+	return &Object{Type: t, Value: v}
+}
+
+// Check returns the Go value of argument 'i' of a method, or an error if it
+// is not an Object of type 't'.
+func (t *Type) Check(args []Value, i int) (interface{}, error) {
+	if i < len(args) {
+		if o, ok := args[i].(*Object); ok && o.Type == t {
+			return o.Value, nil
+		}
+	}
+	return nil, ArgError(i, t.Name+" expected, got "+TypeName(arg(args, i)))
+}
+
+// ArgError returns the error of a bad argument 'i', counted from 0, of a
+// function.
+func ArgError(i int, msg string) error {
+	return &Error{Kind: RuntimeError, Message: "bad argument #" + strconv.Itoa(i+1) + " (" + msg + ")"}
+}
+
+// CheckString returns argument 'i' if it is a string, like luaL_checkstring it
+// also accepts numbers.
+func CheckString(args []Value, i int) (string, error) {
+	switch v := arg(args, i).(type) {
+	case string:
+		return v, nil
+	case float64:
+		return formatNumber(v), nil
+	}
+	return "", ArgError(i, "string expected, got "+TypeName(arg(args, i)))
+}
+
+// CheckNumber returns argument 'i' if it is a number.
+func CheckNumber(args []Value, i int) (float64, error) {
+	if v, ok := arg(args, i).(float64); ok {
+		return v, nil
+	}
+	return 0, ArgError(i, "number expected, got "+TypeName(arg(args, i)))
+}
+
+func arg(args []Value, i int) Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
@@ -0,0 +1,619 @@
+package engine
+
+import (
+	"strings"
+)
+
+// The pattern matching of string.find, string.match, string.gmatch and
+// string.gsub, ported from lstrlib.c of Lua 5.2 for go-lua, which only has a
+// plain string.find. They are installed by Options.Compat.
+
+const (
+	maxCaptures   = 32
+	maxMatchCalls = 200
+	// capUnfinished and capPosition are the lengths of the captures still
+	// open and of the position captures.
+	capUnfinished = -1
+	capPosition   = -2
+)
+
+// patternSpecials are the characters making string.find match a pattern.
+const patternSpecials = "^$*+?.([%-"
+
+// patternError is raised by the matcher, with panic, for malformed patterns.
+type patternError string
+
+type matchState struct {
+	src, pat string
+	level    int
+	depth    int
+	capture  [maxCaptures]struct{ init, len int }
+}
+
+func (ms *matchState) error(msg string) {
+	panic(patternError(msg))
+}
+
+// classEnd returns the end of the single character class at 'p'.
+func (ms *matchState) classEnd(p int) int {
+	c := ms.pat[p]
+	p++
+	switch c {
+	case '%':
+		if p >= len(ms.pat) {
+			ms.error("malformed pattern (ends with '%')")
+		}
+		return p + 1
+	case '[':
+		if p < len(ms.pat) && ms.pat[p] == '^' {
+			p++
+		}
+		for {
+			// look for a ']', the first character being part of the set
+			if p >= len(ms.pat) {
+				ms.error("malformed pattern (missing ']')")
+			}
+			c := ms.pat[p]
+			p++
+			if c == '%' && p < len(ms.pat) {
+				p++
+			}
+			if p >= len(ms.pat) {
+				ms.error("malformed pattern (missing ']')")
+			}
+			if ms.pat[p] == ']' {
+				return p + 1
+			}
+		}
+	}
+	return p
+}
+
+// matchClass reports whether 'c' is in the class %'cl'.
+func matchClass(c, cl byte) bool {
+	var res bool
+	switch cl | 0x20 {
+	case 'a':
+		res = isAlpha(c)
+	case 'c':
+		res = c < 32 || c == 127
+	case 'd':
+		res = c >= '0' && c <= '9'
+	case 'g':
+		res = c > 32 && c < 127
+	case 'l':
+		res = c >= 'a' && c <= 'z'
+	case 'p':
+		res = c > 32 && c < 127 && !isAlpha(c) && !(c >= '0' && c <= '9')
+	case 's':
+		res = c == ' ' || c >= '\t' && c <= '\r'
+	case 'u':
+		res = c >= 'A' && c <= 'Z'
+	case 'w':
+		res = isAlpha(c) || c >= '0' && c <= '9'
+	case 'x':
+		res = c >= '0' && c <= '9' || c|0x20 >= 'a' && c|0x20 <= 'f'
+	case 'z':
+		res = c == 0
+	default:
+		return cl == c
+	}
+	if cl >= 'A' && cl <= 'Z' {
+		return !res
+	}
+	return res
+}
+
+func isAlpha(c byte) bool {
+	return c|0x20 >= 'a' && c|0x20 <= 'z'
+}
+
+// matchBracketClass reports whether 'c' is in the set from 'p', its '[', to
+// 'ec', its ']'.
+func (ms *matchState) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	if ms.pat[p+1] == '^' {
+		sig = false
+		p++
+	}
+	for p++; p < ec; p++ {
+		switch {
+		case ms.pat[p] == '%':
+			p++
+			if matchClass(c, ms.pat[p]) {
+				return sig
+			}
+		case ms.pat[p+1] == '-' && p+2 < ec:
+			p += 2
+			if ms.pat[p-2] <= c && c <= ms.pat[p] {
+				return sig
+			}
+		case ms.pat[p] == c:
+			return sig
+		}
+	}
+	return !sig
+}
+
+// singleMatch reports whether the character at 's' matches the class from 'p'
+// to 'ep'.
+func (ms *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pat[p] {
+	case '.':
+		return true
+	case '%':
+		return matchClass(c, ms.pat[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	}
+	return ms.pat[p] == c
+}
+
+// match returns the end of the match of the pattern from 'p' at 's', -1 if
+// it does not match.
+func (ms *matchState) match(s, p int) int {
+	if ms.depth == 0 {
+		ms.error("pattern too complex")
+	}
+	ms.depth--
+	defer func() { ms.depth++ }()
+	for p < len(ms.pat) {
+		switch ms.pat[p] {
+		case '(':
+			if p+1 < len(ms.pat) && ms.pat[p+1] == ')' {
+				return ms.startCapture(s, p+2, capPosition)
+			}
+			return ms.startCapture(s, p+1, capUnfinished)
+		case ')':
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(ms.pat) {
+				if s == len(ms.src) {
+					return s
+				}
+				return -1
+			}
+		case '%':
+			if p+1 >= len(ms.pat) {
+				break
+			}
+			switch c := ms.pat[p+1]; {
+			case c == 'b':
+				if s = ms.matchBalance(s, p+2); s == -1 {
+					return -1
+				}
+				p += 4
+				continue
+			case c == 'f':
+				p += 2
+				if p >= len(ms.pat) || ms.pat[p] != '[' {
+					ms.error("missing '[' after '%f' in pattern")
+				}
+				ep := ms.classEnd(p)
+				var prev, cur byte
+				if s > 0 {
+					prev = ms.src[s-1]
+				}
+				if s < len(ms.src) {
+					cur = ms.src[s]
+				}
+				if ms.matchBracketClass(prev, p, ep-1) || !ms.matchBracketClass(cur, p, ep-1) {
+					return -1
+				}
+				p = ep
+				continue
+			case c >= '0' && c <= '9':
+				if s = ms.matchCapture(s, c); s == -1 {
+					return -1
+				}
+				p += 2
+				continue
+			}
+		}
+		ep := ms.classEnd(p)
+		var next byte
+		if ep < len(ms.pat) {
+			next = ms.pat[ep]
+		}
+		if !ms.singleMatch(s, p, ep) {
+			if next == '*' || next == '?' || next == '-' {
+				// accept empty
+				p = ep + 1
+				continue
+			}
+			return -1
+		}
+		switch next {
+		case '?':
+			if res := ms.match(s+1, ep+1); res != -1 {
+				return res
+			}
+			p = ep + 1
+			continue
+		case '+':
+			return ms.maxExpand(s+1, p, ep)
+		case '*':
+			return ms.maxExpand(s, p, ep)
+		case '-':
+			return ms.minExpand(s, p, ep)
+		}
+		s++
+		p = ep
+	}
+	return s
+}
+
+func (ms *matchState) maxExpand(s, p, ep int) int {
+	i := 0
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	// try with the maximum repetitions, then less
+	for ; i >= 0; i-- {
+		if res := ms.match(s+i, ep+1); res != -1 {
+			return res
+		}
+	}
+	return -1
+}
+
+func (ms *matchState) minExpand(s, p, ep int) int {
+	for {
+		if res := ms.match(s, ep+1); res != -1 {
+			return res
+		}
+		if !ms.singleMatch(s, p, ep) {
+			return -1
+		}
+		s++
+	}
+}
+
+func (ms *matchState) startCapture(s, p, what int) int {
+	if ms.level >= maxCaptures {
+		ms.error("too many captures")
+	}
+	ms.capture[ms.level].init = s
+	ms.capture[ms.level].len = what
+	ms.level++
+	res := ms.match(s, p)
+	if res == -1 {
+		ms.level--
+	}
+	return res
+}
+
+func (ms *matchState) endCapture(s, p int) int {
+	l := -1
+	for i := ms.level - 1; i >= 0; i-- {
+		if ms.capture[i].len == capUnfinished {
+			l = i
+			break
+		}
+	}
+	if l < 0 {
+		ms.error("invalid pattern capture")
+	}
+	ms.capture[l].len = s - ms.capture[l].init
+	res := ms.match(s, p)
+	if res == -1 {
+		ms.capture[l].len = capUnfinished
+	}
+	return res
+}
+
+func (ms *matchState) matchBalance(s, p int) int {
+	if p+1 >= len(ms.pat) {
+		ms.error("missing arguments to '%b'")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pat[p] {
+		return -1
+	}
+	b, e := ms.pat[p], ms.pat[p+1]
+	cont := 1
+	for s++; s < len(ms.src); s++ {
+		switch ms.src[s] {
+		case e:
+			if cont--; cont == 0 {
+				return s + 1
+			}
+		case b:
+			cont++
+		}
+	}
+	return -1
+}
+
+func (ms *matchState) matchCapture(s int, c byte) int {
+	l := int(c - '1')
+	if l < 0 || l >= ms.level || ms.capture[l].len == capUnfinished {
+		ms.error("invalid capture index")
+	}
+	n := ms.capture[l].len
+	init := ms.capture[l].init
+	if len(ms.src)-s >= n && ms.src[init:init+n] == ms.src[s:s+n] {
+		return s + n
+	}
+	return -1
+}
+
+// doMatch matches the pattern at 's' from scratch.
+func (ms *matchState) doMatch(s, p int) int {
+	ms.level = 0
+	ms.depth = maxMatchCalls
+	return ms.match(s, p)
+}
+
+// oneCapture returns capture 'i' of the match from 's' to 'e'.
+func (ms *matchState) oneCapture(i, s, e int) Value {
+	if i >= ms.level {
+		if i != 0 {
+			ms.error("invalid capture index")
+		}
+		return ms.src[s:e]
+	}
+	c := ms.capture[i]
+	switch c.len {
+	case capUnfinished:
+		ms.error("unfinished capture")
+	case capPosition:
+		return float64(c.init + 1)
+	}
+	return ms.src[c.init : c.init+c.len]
+}
+
+// captures returns the captures of the match from 's' to 'e', the whole match
+// if there are none and 'whole' is set.
+func (ms *matchState) captures(s, e int, whole bool) []Value {
+	n := ms.level
+	if n == 0 && whole {
+		n = 1
+	}
+	values := make([]Value, n)
+	for i := range values {
+		values[i] = ms.oneCapture(i, s, e)
+	}
+	return values
+}
+
+// patternFunc recovers the errors of the matcher in 'f'.
+func patternFunc(f Function) Function {
+	return func(args []Value) (results []Value, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				msg, ok := r.(patternError)
+				if !ok {
+					panic(r)
+				}
+				err = &Error{Kind: RuntimeError, Message: string(msg)}
+			}
+		}()
+		return f(args)
+	}
+}
+
+// patternFuncs are string.find, string.match, string.gmatch and string.gsub.
+var patternFuncs = map[string]Function{
+	"find":   patternFunc(func(args []Value) ([]Value, error) { return strFind(args, true) }),
+	"match":  patternFunc(func(args []Value) ([]Value, error) { return strFind(args, false) }),
+	"gmatch": patternFunc(strGmatch),
+	"gsub":   patternFunc(strGsub),
+}
+
+// strFind is string.find with 'find', string.match otherwise.
+func strFind(args []Value, find bool) ([]Value, error) {
+	s, err := CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	p, err := CheckString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	init := 1
+	if arg(args, 2) != nil {
+		n, err := CheckNumber(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		if init = int(n); init < 0 {
+			init += len(s) + 1
+		}
+		if init < 1 {
+			init = 1
+		}
+	}
+	if init > len(s)+1 {
+		return []Value{nil}, nil
+	}
+	plain := arg(args, 3) != nil && arg(args, 3) != false
+	if find && (plain || !strings.ContainsAny(p, patternSpecials)) {
+		if i := strings.Index(s[init-1:], p); i >= 0 {
+			return []Value{float64(init + i), float64(init + i + len(p) - 1)}, nil
+		}
+		return []Value{nil}, nil
+	}
+	ms := &matchState{src: s, pat: p}
+	anchor := len(p) > 0 && p[0] == '^'
+	start := 0
+	if anchor {
+		start = 1
+	}
+	for s1 := init - 1; s1 <= len(s); s1++ {
+		if e := ms.doMatch(s1, start); e != -1 {
+			if find {
+				return append([]Value{float64(s1 + 1), float64(e)}, ms.captures(-1, -1, false)...), nil
+			}
+			return ms.captures(s1, e, true), nil
+		}
+		if anchor {
+			break
+		}
+	}
+	return []Value{nil}, nil
+}
+
+func strGmatch(args []Value) ([]Value, error) {
+	s, err := CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	p, err := CheckString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	ms := &matchState{src: s, pat: p}
+	src := 0
+	iter := patternFunc(func([]Value) ([]Value, error) {
+		for ; src <= len(s); src++ {
+			if e := ms.doMatch(src, 0); e != -1 {
+				start := src
+				if e == src {
+					// empty match, go at least one position
+					src++
+				} else {
+					src = e
+				}
+				return ms.captures(start, e, true), nil
+			}
+		}
+		return []Value{nil}, nil
+	})
+	return []Value{iter}, nil
+}
+
+func strGsub(args []Value) ([]Value, error) {
+	s, err := CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	p, err := CheckString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	repl := arg(args, 2)
+	switch repl.(type) {
+	case string, float64, *Table, *Func, Function:
+	default:
+		return nil, ArgError(2, "string/function/table expected, got "+TypeName(repl))
+	}
+	maxN := len(s) + 1
+	if arg(args, 3) != nil {
+		n, err := CheckNumber(args, 3)
+		if err != nil {
+			return nil, err
+		}
+		maxN = int(n)
+	}
+	anchor := len(p) > 0 && p[0] == '^'
+	start := 0
+	if anchor {
+		start = 1
+	}
+	ms := &matchState{src: s, pat: p}
+	var b strings.Builder
+	src, n := 0, 0
+	for n < maxN {
+		e := ms.doMatch(src, start)
+		if e != -1 {
+			n++
+			if err := ms.addValue(&b, src, e, repl); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case e != -1 && e > src:
+			src = e
+		case src < len(s):
+			b.WriteByte(s[src])
+			src++
+		default:
+			src = len(s) + 1
+		}
+		if src > len(s) || anchor {
+			break
+		}
+	}
+	if src < len(s) {
+		b.WriteString(s[src:])
+	}
+	return []Value{b.String(), float64(n)}, nil
+}
+
+// addValue adds the replacement of the match from 's' to 'e' to 'b'.
+func (ms *matchState) addValue(b *strings.Builder, s, e int, repl Value) error {
+	var v Value
+	switch repl := repl.(type) {
+	case float64:
+		ms.addString(b, s, e, formatNumber(repl))
+		return nil
+	case string:
+		ms.addString(b, s, e, repl)
+		return nil
+	case *Table:
+		v = repl.Get(keyString(ms.oneCapture(0, s, e)))
+	case Function:
+		results, err := repl(ms.captures(s, e, true))
+		if err != nil {
+			return err
+		}
+		v = arg(results, 0)
+	case *Func:
+		results, err := repl.Call(ms.captures(s, e, true)...)
+		if err != nil {
+			return err
+		}
+		v = arg(results, 0)
+	}
+	switch v := v.(type) {
+	case nil, bool:
+		if v == nil || v == false {
+			// keep the original text
+			b.WriteString(ms.src[s:e])
+			return nil
+		}
+	case string:
+		b.WriteString(v)
+		return nil
+	case float64:
+		b.WriteString(formatNumber(v))
+		return nil
+	}
+	return &Error{Kind: RuntimeError, Message: "invalid replacement value (a " + TypeName(v) + ")"}
+}
+
+// addString adds the replacement string 'repl', with its %0 to %9 captures,
+// of the match from 's' to 'e' to 'b'.
+func (ms *matchState) addString(b *strings.Builder, s, e int, repl string) {
+	for i := 0; i < len(repl); i++ {
+		c := repl[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i >= len(repl) {
+			ms.error("invalid use of '%' in replacement string")
+		}
+		c = repl[i]
+		switch {
+		case c == '%':
+			b.WriteByte(c)
+		case c == '0':
+			b.WriteString(ms.src[s:e])
+		case c >= '1' && c <= '9':
+			switch v := ms.oneCapture(int(c-'1'), s, e).(type) {
+			case string:
+				b.WriteString(v)
+			case float64:
+				b.WriteString(formatNumber(v))
+			}
+		default:
+			ms.error("invalid use of '%' in replacement string")
+		}
+	}
+}
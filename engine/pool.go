@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"sync"
+)
+
+// Pool keeps idle engines created with the same implementation and Options,
+// for the programs running scripts on many goroutines, each taking an engine
+// for the time of a call:
+//
+//	pool, err := engine.NewPool("gopher-lua", opts, 8, func(e engine.Engine) error {
+//		return e.DoFile("handlers.lua")
+//	})
+//	e, err := pool.Get()
+//	defer pool.Put(e)
+//
+// A Pool is safe for concurrent use.
+type Pool struct {
+	name  string
+	opts  Options
+	setup func(Engine) error
+	idle  chan Engine
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewPool returns a pool of engines of the implementation 'name' created with
+// 'opts', keeping up to 'size' idle ones. 'setup', when not nil, runs on each
+// new engine, usually to load scripts. One engine is created to check them.
+func NewPool(name string, opts Options, size int, setup func(Engine) error) (*Pool, error) {
+	if size < 1 {
+		size = 1
+	}
+	p := &Pool{name: name, opts: opts, setup: setup, idle: make(chan Engine, size)}
+	e, err := p.create()
+	if err != nil {
+		return nil, err
+	}
+	p.idle <- e
+	return p, nil
+}
+
+func (p *Pool) create() (Engine, error) {
+	e, err := New(p.name, p.opts)
+	if err != nil {
+		return nil, err
+	}
+	if p.setup != nil {
+		if err := p.setup(e); err != nil {
+			e.Close()
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// Get returns an idle engine, or a new one when there is none.
+func (p *Pool) Get() (Engine, error) {
+	select {
+	case e := <-p.idle:
+		return e, nil
+	default:
+		return p.create()
+	}
+}
+
+// Put returns an engine taken with Get to the pool, with the context, the
+// standard input and output and the logger of the Options again. The engine is
+// closed when the pool is full or closed.
+func (p *Pool) Put(e Engine) {
+	e.SetContext(nil)
+	e.SetStdout(p.opts.Stdout)
+	e.SetStdin(p.opts.Stdin)
+	e.SetLogger(p.opts.Logger)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		select {
+		case p.idle <- e:
+			return
+		default:
+		}
+	}
+	e.Close()
+}
+
+// Close closes the idle engines, and those put back later.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for {
+		select {
+		case e := <-p.idle:
+			e.Close()
+		default:
+			return
+		}
+	}
+}
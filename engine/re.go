@@ -0,0 +1,266 @@
+package engine
+
+import (
+	"regexp"
+	"strings"
+)
+
+// regexpCacheSize is the number of patterns compiled by a state which are
+// kept, the cache being emptied when it is full.
+const regexpCacheSize = 256
+
+// regexps is the re library of a state, Go regular expressions for what Lua
+// patterns cannot express, like alternatives and Unicode classes:
+//
+//	local r = re.compile([[(?P<user>\w+)@(?P<host>[\w.]+)]])
+//	local m = r:match("mail bob@example.com")   -- m[0] the match, m[1] and m.user "bob"
+//	for _, m in ipairs(r:find_all(text)) do ... end
+//	local s, n = r:gsub(text, "${user} at ${host}")
+//	local s, n = r:gsub(text, function(m) return m.user:upper() end)
+//
+// The methods of Regexp, match(s [, init]), find_all(s [, n]), gsub(s, repl
+// [, n]) and test(s), are also functions taking a pattern, re.match(pattern,
+// s), which compile it once per state. Matches are tables of the whole match
+// at 0, the groups from 1, "" when they did not match, and the named groups.
+// The replacement of gsub is a string expanding $1 or ${name}, a table indexed
+// by the first group, or the whole match without groups, or a function called
+// with the match, which keeps the match by returning nil or false. re.quote(s)
+// escapes the special characters of s.
+type regexps struct {
+	typ   *Type
+	cache map[string]*regexp.Regexp
+}
+
+func newRegexps() *regexps {
+	r := &regexps{typ: &Type{Name: "Regexp"}, cache: map[string]*regexp.Regexp{}}
+	r.typ.Methods = map[string]Function{
+		"__tostring": func(args []Value) ([]Value, error) {
+			re, err := r.check(args)
+			if err != nil {
+				return nil, err
+			}
+			return []Value{re.String()}, nil
+		},
+	}
+	return r
+}
+
+// install registers the re library and the Regexp type in 'e'.
+func (r *regexps) install(e Engine) error {
+	funcs := map[string]Function{
+		"compile": func(args []Value) ([]Value, error) {
+			re, err := r.compile(args)
+			if err != nil {
+				return nil, err
+			}
+			return []Value{r.typ.New(re)}, nil
+		},
+		"quote": stringFunc(regexp.QuoteMeta),
+	}
+	for name, op := range map[string]func(re *regexp.Regexp, args []Value) ([]Value, error){
+		"match":    reMatch,
+		"find_all": reFindAll,
+		"gsub":     reGsub,
+		"test":     reTest,
+	} {
+		op := op
+		r.typ.Methods[name] = func(args []Value) ([]Value, error) {
+			re, err := r.check(args)
+			if err != nil {
+				return nil, err
+			}
+			return op(re, args)
+		}
+		funcs[name] = func(args []Value) ([]Value, error) {
+			re, err := r.compile(args)
+			if err != nil {
+				return nil, err
+			}
+			return op(re, args)
+		}
+	}
+	return e.Register(&Module{Name: "re", Funcs: funcs, Types: []*Type{r.typ}})
+}
+
+// check returns the Regexp of a method.
+func (r *regexps) check(args []Value) (*regexp.Regexp, error) {
+	v, err := r.typ.Check(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*regexp.Regexp), nil
+}
+
+// compile returns the regular expression of the pattern in the first argument,
+// from the cache if it was already compiled.
+func (r *regexps) compile(args []Value) (*regexp.Regexp, error) {
+	pattern, err := CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if re, ok := r.cache[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, ArgError(0, err.Error())
+	}
+	if len(r.cache) >= regexpCacheSize {
+		r.cache = map[string]*regexp.Regexp{}
+	}
+	r.cache[pattern] = re
+	return re, nil
+}
+
+// reMatch returns the first match in argument 1 from the byte position of
+// argument 2, 1 by default, or nil.
+func reMatch(re *regexp.Regexp, args []Value) ([]Value, error) {
+	s, err := CheckString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	init := 0
+	if arg(args, 2) != nil {
+		n, err := CheckNumber(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		if init = int(n) - 1; init < 0 {
+			init += len(s) + 1
+		}
+		if init < 0 {
+			init = 0
+		}
+		if init > len(s) {
+			return []Value{nil}, nil
+		}
+	}
+	loc := re.FindStringSubmatchIndex(s[init:])
+	if loc == nil {
+		return []Value{nil}, nil
+	}
+	return []Value{matchTable(re, s[init:], loc)}, nil
+}
+
+// reFindAll returns the table of the matches in argument 1, at most argument 2
+// if given.
+func reFindAll(re *regexp.Regexp, args []Value) ([]Value, error) {
+	s, err := CheckString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	n, err := optCount(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	t := NewTable()
+	for _, loc := range re.FindAllStringSubmatchIndex(s, n) {
+		t.Array = append(t.Array, matchTable(re, s, loc))
+	}
+	return []Value{t}, nil
+}
+
+// reGsub replaces the matches in argument 1 by argument 2, at most argument 3
+// if given, and returns the string and the number of matches.
+func reGsub(re *regexp.Regexp, args []Value) ([]Value, error) {
+	s, err := CheckString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	repl := arg(args, 2)
+	switch repl.(type) {
+	case string, float64, *Table, *Func, Function:
+	default:
+		return nil, ArgError(2, "string/function/table expected, got "+TypeName(repl))
+	}
+	n, err := optCount(args, 3)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	matches := re.FindAllStringSubmatchIndex(s, n)
+	last := 0
+	for _, loc := range matches {
+		b.WriteString(s[last:loc[0]])
+		last = loc[1]
+		var v Value
+		switch repl := repl.(type) {
+		case string:
+			b.Write(re.ExpandString(nil, repl, s, loc))
+			continue
+		case float64:
+			b.WriteString(formatNumber(repl))
+			continue
+		case *Table:
+			key := s[loc[0]:loc[1]]
+			if len(loc) > 2 && loc[2] >= 0 {
+				key = s[loc[2]:loc[3]]
+			}
+			v = repl.Get(key)
+		case *Func, Function:
+			call, _ := repl.(Function)
+			if f, ok := repl.(*Func); ok {
+				call = func(args []Value) ([]Value, error) { return f.Call(args...) }
+			}
+			results, err := call([]Value{matchTable(re, s, loc)})
+			if err != nil {
+				return nil, err
+			}
+			v = arg(results, 0)
+		}
+		if v == nil || v == false {
+			b.WriteString(s[loc[0]:loc[1]])
+			continue
+		}
+		switch v := v.(type) {
+		case string:
+			b.WriteString(v)
+		case float64:
+			b.WriteString(formatNumber(v))
+		default:
+			return nil, &Error{Kind: RuntimeError, Message: "invalid replacement value (a " + TypeName(v) + ")"}
+		}
+	}
+	b.WriteString(s[last:])
+	return []Value{b.String(), float64(len(matches))}, nil
+}
+
+// reTest reports whether argument 1 matches.
+func reTest(re *regexp.Regexp, args []Value) ([]Value, error) {
+	s, err := CheckString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return []Value{re.MatchString(s)}, nil
+}
+
+// matchTable returns the table of the match at 'loc' in 's'.
+func matchTable(re *regexp.Regexp, s string, loc []int) *Table {
+	t := NewTable()
+	t.Set("0", s[loc[0]:loc[1]])
+	names := re.SubexpNames()
+	for i := 1; i < len(loc)/2; i++ {
+		group := ""
+		if loc[2*i] >= 0 {
+			group = s[loc[2*i]:loc[2*i+1]]
+		}
+		t.Array = append(t.Array, group)
+		if names[i] != "" {
+			t.Set(names[i], group)
+		}
+	}
+	return t
+}
+
+// optCount returns the optional maximum number of matches of argument 'i', -1
+// for all of them.
+func optCount(args []Value, i int) (int, error) {
+	if arg(args, i) == nil {
+		return -1, nil
+	}
+	n, err := CheckNumber(args, i)
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
@@ -0,0 +1,105 @@
+package engine_test
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+func TestRe(t *testing.T) {
+	const setup = `
+email = re.compile([[(?P<user>\w+)@(?P<host>[\w.]+)]])
+text = "bob@example.com, ann@test.org and é@x"
+`
+	tests := []struct {
+		expr string
+		want []engine.Value
+	}{
+		{`tostring(email), re.compile("a|b"):test("cab"), email:test("nobody")`, []engine.Value{`(?P<user>\w+)@(?P<host>[\w.]+)`, true, false}},
+		{`(function()
+			local m = email:match("mail bob@example.com")
+			return m[0], m[1], m[2], m.user, m.host, #m
+		end)()`, []engine.Value{"bob@example.com", "bob", "example.com", "bob", "example.com", 2.0}},
+		{`email:match(text, 5).user, email:match(text, -22).host, email:match(text, 100), email:match("none")`, []engine.Value{"ann", "test.org", nil, nil}},
+		{`re.match("(a)|(b)", "b")[1], re.match("(a)|(b)", "b")[2]`, []engine.Value{"", "b"}},
+		{`(function()
+			local hosts = {}
+			for _, m in ipairs(email:find_all(text)) do hosts[#hosts + 1] = m.host end
+			return table.concat(hosts, " "), #email:find_all(text, 1), #re.find_all("x", "abc")
+		end)()`, []engine.Value{"example.com test.org", 1.0, 0.0}},
+		{`email:gsub(text, "${user} at $host")`, []engine.Value{"bob at example.com, ann at test.org and é@x", 2.0}},
+		{`email:gsub(text, function(m) if m.user ~= "ann" then return m.user:upper() end end)`, []engine.Value{"BOB, ann@test.org and é@x", 2.0}},
+		{`re.gsub("\\pL+", "día ünico", function(m) return #m[0] end, 1)`, []engine.Value{"4 ünico", 1.0}},
+		{`email:gsub(text, {bob = "B", ann = false})`, []engine.Value{"B, ann@test.org and é@x", 2.0}},
+		{`re.gsub("o", "foo", 0)`, []engine.Value{"f00", 2.0}},
+		{`re.quote("a.b*c"), re.test(re.quote("a.b*c"), "xa.b*c"), re.test(re.quote("a.b*c"), "aab")`, []engine.Value{`a\.b\*c`, true, false}},
+	}
+	for _, name := range engine.Names() {
+		e, err := engine.New(name, engine.Options{})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		defer e.Close()
+		if err := e.DoString(setup, "=test"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for _, tt := range tests {
+			got, err := e.Eval(tt.expr)
+			if err != nil {
+				t.Errorf("%s: %s: %v", name, tt.expr, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: %s = %q, want %q", name, tt.expr, got, tt.want)
+			}
+		}
+		for expr, msg := range map[string]string{
+			`re.compile("(")`:                              "missing closing )",
+			`re.match("(", "x")`:                           "missing closing )",
+			`email:gsub(text, true)`:                       "string/function/table expected",
+			`email:gsub(text, function() return {} end)`:   "invalid replacement value (a table)",
+			`email:gsub(text, function() error("cb") end)`: "cb",
+			`email.match("bob@x", "bob@x")`:                "bad argument #1",
+		} {
+			if _, err := e.Eval(expr); err == nil || !strings.Contains(err.Error(), msg) {
+				t.Errorf("%s: %s returned %v", name, expr, err)
+			}
+		}
+	}
+}
+
+// TestReCache checks that the patterns compiled by re functions are cached per
+// state, states running concurrently, and that the cache stays correct when it
+// is emptied.
+func TestReCache(t *testing.T) {
+	const script = `
+for round = 1, 2 do
+	for i = 1, 300 do
+		local m = re.match("(\\d+)-" .. i .. "$", "x" .. i .. "-" .. i)
+		assert(m and m[1] == tostring(i), "pattern " .. i)
+		assert(not re.test("^" .. i .. "$", "y"))
+	end
+end
+`
+	for _, name := range engine.Names() {
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			e, err := engine.New(name, engine.Options{})
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			defer e.Close()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := e.DoString(script, "=test"); err != nil {
+					t.Errorf("%s: %v", name, err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
@@ -0,0 +1,386 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	lua "github.com/Shopify/go-lua"
+)
+
+func init() {
+	engines["go-lua"] = func(opts Options) (Engine, error) {
+		l := lua.NewState()
+		lua.OpenLibraries(l)
+		e := &shopify{l: l, heap: goHeap(), stdio: newStdio(opts)}
+		e.log = newLogging(opts, e.where)
+		e.limits.memory = opts.MemoryLimit
+		e.count = opts.CountInstructions
+		e.setHook()
+		if err := e.stdio.install(e); err != nil {
+			return nil, err
+		}
+		if err := e.log.install(e); err != nil {
+			return nil, err
+		}
+		if err := installLibraries(e, opts); err != nil {
+			return nil, err
+		}
+		e.trace = newTracer(opts, "go-lua", &e.limits)
+		e.stats.reset()
+		return e, nil
+	}
+}
+
+// shopify is the engine of Shopify/go-lua. Objects are stored in userdata
+// directly.
+type shopify struct {
+	l *lua.State
+
+	limits limits
+	// count is set to count the instructions in 'stats'.
+	count bool
+	stats stats
+	stdio *stdio
+	log   *logging
+	trace *tracer
+	// caller is the state running the current Go function.
+	caller *lua.State
+	// heap is the size of the Go heap when the engine was created.
+	heap int64
+}
+
+func (e *shopify) Name() string        { return "go-lua" }
+func (e *shopify) Stats() Stats        { return e.stats.get() }
+func (e *shopify) Native() interface{} { return e.l }
+func (e *shopify) Close()              {}
+
+func (e *shopify) DoString(code, name string) (err error) {
+	end := e.trace.call("DoString", name)
+	defer func() { end(err) }()
+	_, err = e.run(func() error { return lua.LoadBuffer(e.l, code, name, "") }, 0)
+	return err
+}
+
+func (e *shopify) DoFile(path string) (err error) {
+	end := e.trace.call("DoFile", path)
+	defer func() { end(err) }()
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	_, err = e.run(func() error { return lua.LoadFile(e.l, path, "") }, 0)
+	return err
+}
+
+func (e *shopify) Eval(expr string) (values []Value, err error) {
+	end := e.trace.call("Eval", "eval")
+	defer func() { end(err) }()
+	return e.run(func() error { return lua.LoadBuffer(e.l, "return "+expr, "=eval", "") }, lua.MultipleReturns)
+}
+
+// run loads a chunk with 'load' and calls it.
+func (e *shopify) run(load func() error, nresults int) ([]Value, error) {
+	top := e.l.Top()
+	if err := load(); err != nil {
+		defer e.l.SetTop(top)
+		kind := RuntimeError
+		if err == lua.SyntaxError {
+			kind = SyntaxError
+		}
+		msg, _ := e.l.ToString(-1)
+		lerr := &Error{Kind: kind, Message: msg, lua: true}
+		e.stats.record(lerr, e.memory())
+		return nil, lerr
+	}
+	return e.call(e.l, top, 0, nresults)
+}
+
+// call calls the function at 'top'+1 with 'nargs' arguments above it.
+func (e *shopify) call(l *lua.State, top, nargs, nresults int) ([]Value, error) {
+	defer l.SetTop(top)
+	err := l.ProtectedCall(nargs, nresults, 0)
+	e.stats.record(err, e.memory())
+	if err != nil {
+		msg, ok := l.ToString(-1)
+		if !ok {
+			msg = err.Error()
+		}
+		return nil, e.limits.take(&Error{Kind: RuntimeError, Message: msg, lua: true})
+	}
+	e.limits.take(nil)
+	return e.values(l, top+1, l.Top()), nil
+}
+
+func (e *shopify) SetStdout(w io.Writer)    { e.stdio.setStdout(w) }
+func (e *shopify) SetStdin(r io.Reader)     { e.stdio.setStdin(r) }
+func (e *shopify) SetLogger(l *slog.Logger) { e.log.setLogger(l) }
+
+// where returns the position of the function at 'level' of the stack of the
+// running Go function, 1 being its caller.
+func (e *shopify) where(level int) string {
+	if e.caller == nil {
+		return ""
+	}
+	lua.Where(e.caller, level)
+	defer e.caller.Pop(1)
+	s, _ := e.caller.ToString(-1)
+	return s
+}
+
+func (e *shopify) SetContext(ctx context.Context) {
+	e.limits.ctx = ctx
+	e.setHook()
+}
+
+// setHook installs the count hook checking the limits and counting the
+// instructions when needed, the only hook working in go-lua.
+func (e *shopify) setHook() {
+	if !e.limits.enabled() && !e.count {
+		lua.SetDebugHook(e.l, nil, 0, 0)
+		return
+	}
+	lua.SetDebugHook(e.l, func(l *lua.State, ar lua.Debug) {
+		if e.count {
+			e.stats.instructions.Add(hookCount)
+		}
+		if err := e.limits.check(e.memory); err != nil {
+			lua.Errorf(l, "%s", err.Message)
+		}
+	}, lua.MaskCount, hookCount)
+}
+
+// memory returns the growth of the Go heap since the creation of the engine.
+func (e *shopify) memory() int64 {
+	return goHeap() - e.heap
+}
+
+func (e *shopify) Call(name string, args ...Value) (values []Value, err error) {
+	end := e.trace.call("Call", name)
+	defer func() { end(err) }()
+	top := e.l.Top()
+	e.l.Global(name)
+	for _, arg := range args {
+		if err := e.push(e.l, arg); err != nil {
+			e.l.SetTop(top)
+			return nil, err
+		}
+	}
+	return e.call(e.l, top, len(args), lua.MultipleReturns)
+}
+
+func (e *shopify) Global(name string) (Value, error) {
+	e.l.Global(name)
+	defer e.l.Pop(1)
+	return e.value(e.l, -1), nil
+}
+
+func (e *shopify) SetGlobal(name string, v Value) error {
+	if err := e.push(e.l, v); err != nil {
+		return err
+	}
+	e.l.SetGlobal(name)
+	return nil
+}
+
+func (e *shopify) Register(m *Module) error {
+	l := e.l
+	for _, t := range m.Types {
+		lua.NewMetaTable(l, t.Name)
+		l.PushValue(-1)
+		l.SetField(-2, "__index")
+		for method, f := range t.Methods {
+			l.PushGoFunction(e.goFunction(t.Name+"."+method, f))
+			l.SetField(-2, method)
+		}
+		l.SetGlobal(t.Name)
+	}
+	if m.Name == "" {
+		for name, f := range m.Funcs {
+			l.Register(name, e.goFunction(name, f))
+		}
+		return nil
+	}
+	l.Global(m.Name)
+	if !l.IsTable(-1) {
+		l.Pop(1)
+		l.NewTable()
+		l.PushValue(-1)
+		l.SetGlobal(m.Name)
+	}
+	for name, f := range m.Funcs {
+		l.PushGoFunction(e.goFunction(m.Name+"."+name, f))
+		l.SetField(-2, name)
+	}
+	l.Pop(1)
+	return nil
+}
+
+// goFunction wraps 'f' for go-lua, raising its errors as Lua errors. 'name'
+// is the name of its span, the name of the Go function when empty.
+func (e *shopify) goFunction(name string, f Function) lua.Function {
+	return func(l *lua.State) int {
+		caller := e.caller
+		e.caller = l
+		defer func() { e.caller = caller }()
+		end := e.trace.callback(name, f, e.where)
+		results, err := f(e.values(l, 1, l.Top()))
+		if err == nil {
+			l.SetTop(0)
+			for _, r := range results {
+				if err = e.push(l, r); err != nil {
+					break
+				}
+			}
+		}
+		end(err)
+		if err != nil {
+			if lerr, ok := err.(*Error); ok && lerr.lua {
+				l.PushString(err.Error())
+				l.Error()
+			}
+			lua.Errorf(l, "%s", err.Error())
+		}
+		return len(results)
+	}
+}
+
+func (e *shopify) push(l *lua.State, v Value) error {
+	switch v := v.(type) {
+	case nil:
+		l.PushNil()
+	case bool:
+		l.PushBoolean(v)
+	case float64:
+		l.PushNumber(v)
+	case string:
+		l.PushString(v)
+	case *Table:
+		l.CreateTable(len(v.Array), len(v.Fields))
+		for i, item := range v.Array {
+			if err := e.push(l, item); err != nil {
+				l.Pop(1)
+				return err
+			}
+			l.RawSetInt(-2, i+1)
+		}
+		for k, item := range v.Fields {
+			if n, ok := fieldKey(k); ok {
+				l.PushNumber(n)
+			} else {
+				l.PushString(k)
+			}
+			if err := e.push(l, item); err != nil {
+				l.Pop(2)
+				return err
+			}
+			l.RawSet(-3)
+		}
+	case *Object:
+		l.PushUserData(v)
+		if v.Type != nil {
+			lua.MetaTableNamed(l, v.Type.Name)
+			if !l.IsTable(-1) {
+				l.Pop(2)
+				return &Error{Kind: RuntimeError, Message: "type " + v.Type.Name + " is not registered"}
+			}
+			l.SetMetaTable(-2)
+		}
+	case Function:
+		l.PushGoFunction(e.goFunction("", v))
+	case *Func:
+		if v.engine != Engine(e) {
+			return &Error{Kind: RuntimeError, Message: "cannot push a function of another engine"}
+		}
+		// PushLightUserData pushes any Go value as is, here the closure
+		// returned by ToValue.
+		l.PushLightUserData(v.ref)
+	case Opaque:
+		return &Error{Kind: RuntimeError, Message: "cannot push a " + v.TypeName}
+	default:
+		return e.push(l, ToValue(v))
+	}
+	return nil
+}
+
+// values converts the values from 'from' to 'to' of the stack.
+func (e *shopify) values(l *lua.State, from, to int) []Value {
+	values := make([]Value, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		values = append(values, e.value(l, i))
+	}
+	return values
+}
+
+func (e *shopify) value(l *lua.State, idx int) Value {
+	return e.convert(l, l.AbsIndex(idx), map[interface{}]*Table{})
+}
+
+func (e *shopify) convert(l *lua.State, idx int, seen map[interface{}]*Table) Value {
+	switch l.TypeOf(idx) {
+	case lua.TypeNil, lua.TypeNone:
+		return nil
+	case lua.TypeBoolean:
+		return l.ToBoolean(idx)
+	case lua.TypeNumber:
+		n, _ := l.ToNumber(idx)
+		return n
+	case lua.TypeString:
+		s, _ := l.ToString(idx)
+		return s
+	case lua.TypeTable:
+		ptr := l.ToValue(idx)
+		if t, ok := seen[ptr]; ok {
+			return t
+		}
+		t := NewTable()
+		seen[ptr] = t
+		n := l.RawLength(idx)
+		for i := 1; i <= n; i++ {
+			l.RawGetInt(idx, i)
+			if l.IsNil(-1) {
+				l.Pop(1)
+				n = i - 1
+				break
+			}
+			t.Array = append(t.Array, e.convert(l, l.Top(), seen))
+			l.Pop(1)
+		}
+		l.PushNil()
+		for l.Next(idx) {
+			if l.TypeOf(-2) == lua.TypeNumber {
+				if k, _ := l.ToNumber(-2); k == float64(int(k)) && k >= 1 && int(k) <= n {
+					l.Pop(1)
+					continue
+				}
+			}
+			t.Fields[keyString(e.convert(l, l.Top()-1, seen))] = e.convert(l, l.Top(), seen)
+			l.Pop(1)
+		}
+		return t
+	case lua.TypeFunction:
+		return e.function(l, l.ToValue(idx))
+	case lua.TypeUserData:
+		if o, ok := l.ToUserData(idx).(*Object); ok {
+			return o
+		}
+	}
+	return Opaque{TypeName: lua.TypeNameOf(l, idx), Address: fmt.Sprintf("%p", l.ToValue(idx))}
+}
+
+func (e *shopify) function(l *lua.State, ref interface{}) *Func {
+	f := &Func{engine: e, ref: ref}
+	f.call = func(args []Value) ([]Value, error) {
+		top := l.Top()
+		l.PushLightUserData(ref)
+		for _, arg := range args {
+			if err := e.push(l, arg); err != nil {
+				l.SetTop(top)
+				return nil, err
+			}
+		}
+		return e.call(l, top, len(args), lua.MultipleReturns)
+	}
+	return f
+}
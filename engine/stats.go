@@ -0,0 +1,53 @@
+package engine
+
+import "sync/atomic"
+
+// Stats are the counters of an engine. They can be read while it runs.
+type Stats struct {
+	// Calls is the number of calls into the state: chunks run, Calls and
+	// Func calls.
+	Calls int64
+	// Errors is the number of calls which failed, syntax errors included.
+	Errors int64
+	// Instructions is the number of Lua instructions run, counted by
+	// thousands with Options.CountInstructions. It stays 0 on gopher-lua,
+	// which has no hooks.
+	Instructions int64
+	// Memory is the number of bytes used after the last call, measured as
+	// for Options.MemoryLimit.
+	Memory int64
+}
+
+// stats holds the Stats of an engine, updated by its calls.
+type stats struct {
+	calls        atomic.Int64
+	errors       atomic.Int64
+	instructions atomic.Int64
+	memory       atomic.Int64
+}
+
+func (s *stats) get() Stats {
+	return Stats{
+		Calls:        s.calls.Load(),
+		Errors:       s.errors.Load(),
+		Instructions: s.instructions.Load(),
+		Memory:       s.memory.Load(),
+	}
+}
+
+// record records a call, failed when 'err' is not nil, after which the state
+// uses 'memory' bytes.
+func (s *stats) record(err error, memory int64) {
+	s.calls.Add(1)
+	if err != nil {
+		s.errors.Add(1)
+	}
+	s.memory.Store(memory)
+}
+
+// reset clears the counters, of the calls made to set up a new state.
+func (s *stats) reset() {
+	s.calls.Store(0)
+	s.errors.Store(0)
+	s.instructions.Store(0)
+}
@@ -0,0 +1,267 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// stdio is the standard input and output of a state: print, io.write, io.read
+// and io.lines without a file are replaced by functions using them, so that
+// every engine can be redirected the same way. io.stdout is replaced by a table
+// whose write and flush methods write to the output too, and which io.write
+// returns, so that io.write(a):write(b) works. io.output() and the other files
+// are not redirected.
+type stdio struct {
+	out io.Writer
+	in  *bufio.Reader
+}
+
+func newStdio(opts Options) *stdio {
+	s := &stdio{}
+	s.setStdout(opts.Stdout)
+	s.setStdin(opts.Stdin)
+	return s
+}
+
+func (s *stdio) setStdout(w io.Writer) {
+	if w == nil {
+		w = os.Stdout
+	}
+	s.out = w
+}
+
+func (s *stdio) setStdin(r io.Reader) {
+	if r == nil {
+		r = os.Stdin
+	}
+	s.in = bufio.NewReader(r)
+}
+
+// stdioLua replaces the functions of the standard library writing to the
+// standard output or reading the standard input by functions calling the Go
+// functions __stdio_write and __stdio_read.
+const stdioLua = `
+local write, read = __stdio_write, __stdio_read
+__stdio_write, __stdio_read = nil, nil
+local select, type, error, concat = select, type, error, table.concat
+local unpack = unpack or table.unpack
+local lines = io.lines
+
+function print(...)
+	local s = {}
+	for i = 1, select("#", ...) do
+		s[i] = tostring((select(i, ...)))
+	end
+	write(concat(s, "\t") .. "\n")
+end
+
+-- writeAll writes the values of io.write and stdout:write, returning the
+-- message of a bad argument for them to raise
+local function writeAll(...)
+	for i = 1, select("#", ...) do
+		local v = select(i, ...)
+		if type(v) ~= "string" and type(v) ~= "number" then
+			return "bad argument #" .. i .. " to 'write' (string expected, got " .. type(v) .. ")"
+		end
+		write(tostring(v))
+	end
+end
+
+local stdout = setmetatable({}, {__tostring = function() return "file (stdout)" end})
+function stdout:write(...)
+	local err = writeAll(...)
+	if err then
+		error(err, 2)
+	end
+	return self
+end
+function stdout:flush()
+	return self
+end
+io.stdout = stdout
+
+function io.write(...)
+	local err = writeAll(...)
+	if err then
+		error(err, 2)
+	end
+	return stdout
+end
+
+function io.read(...)
+	local n = select("#", ...)
+	if n == 0 then
+		return read("*l")
+	end
+	local values = {}
+	for i = 1, n do
+		values[i] = read((select(i, ...)))
+		if values[i] == nil then
+			return unpack(values, 1, i)
+		end
+	end
+	return unpack(values, 1, n)
+end
+
+function io.lines(...)
+	if select("#", ...) > 0 then
+		return lines(...)
+	end
+	return function()
+		return read("*l")
+	end
+end
+`
+
+// install registers the Go functions of 's' in 'e' and replaces the functions
+// of the standard library.
+func (s *stdio) install(e Engine) error {
+	err := e.Register(&Module{Funcs: map[string]Function{
+		"__stdio_write": s.write,
+		"__stdio_read":  s.read,
+	}})
+	if err != nil {
+		return err
+	}
+	return e.DoString(stdioLua, "=stdio")
+}
+
+func (s *stdio) write(args []Value) ([]Value, error) {
+	str, err := CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(s.out, str)
+	return nil, err
+}
+
+// read reads a value of the standard input in one of the formats of io.read:
+// "*l" a line, "*L" a line with its end of line, "*n" a number, "*a" the rest
+// of the input or a number of bytes. It returns nil at the end of the input.
+func (s *stdio) read(args []Value) ([]Value, error) {
+	if len(args) > 0 {
+		if n, ok := args[0].(float64); ok {
+			return s.readBytes(int(n))
+		}
+	}
+	format := "*l"
+	if len(args) > 0 {
+		f, err := CheckString(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		format = f
+	}
+	switch strings.TrimPrefix(format, "*") {
+	case "l", "L":
+		line, err := s.in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line == "" {
+			return []Value{nil}, nil
+		}
+		if format[len(format)-1] == 'l' {
+			line = strings.TrimSuffix(line, "\n")
+		}
+		return []Value{line}, nil
+	case "n":
+		var n float64
+		if _, err := fmt.Fscan(s.in, &n); err != nil {
+			return []Value{nil}, nil
+		}
+		return []Value{n}, nil
+	case "a":
+		data, err := io.ReadAll(s.in)
+		if err != nil {
+			return nil, err
+		}
+		return []Value{string(data)}, nil
+	}
+	return nil, ArgError(0, "invalid format")
+}
+
+func (s *stdio) readBytes(n int) ([]Value, error) {
+	if n <= 0 {
+		if _, err := s.in.Peek(1); err != nil {
+			return []Value{nil}, nil
+		}
+		return []Value{""}, nil
+	}
+	buf := make([]byte, n)
+	n, err := io.ReadFull(s.in, buf)
+	if n == 0 {
+		if err == io.EOF {
+			return []Value{nil}, nil
+		}
+		return nil, err
+	}
+	return []Value{string(buf[:n])}, nil
+}
+
+// LineLogger is an io.Writer logging each line written to it as a message of
+// a slog.Logger, with the name of the script and the ID of the request it runs
+// for. Used as the standard output of a state, it turns the output of print
+// into structured logs:
+//
+//	out := engine.NewLineLogger(slog.Default(), "test.lua", requestID)
+//	defer out.Flush()
+//	e.SetStdout(out)
+type LineLogger struct {
+	logger *slog.Logger
+	// Level is the level of the messages, slog.LevelInfo by default.
+	Level slog.Level
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewLineLogger returns a LineLogger adding the attributes "script" and
+// "request_id" to the messages of 'logger', when they are not empty.
+func NewLineLogger(logger *slog.Logger, script, requestID string) *LineLogger {
+	if script != "" {
+		logger = logger.With("script", script)
+	}
+	if requestID != "" {
+		logger = logger.With("request_id", requestID)
+	}
+	return &LineLogger{logger: logger, Level: slog.LevelInfo}
+}
+
+// Write logs the complete lines of 'p', keeping the end of a line for the next
+// writes.
+func (l *LineLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.log(string(l.buf[:i]))
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush logs the last line when it has no end of line.
+func (l *LineLogger) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.buf) > 0 {
+		l.log(string(l.buf))
+		l.buf = nil
+	}
+}
+
+func (l *LineLogger) log(line string) {
+	l.logger.Log(context.Background(), l.Level, strings.TrimSuffix(line, "\r"))
+}
@@ -0,0 +1,92 @@
+package engine_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+func TestStdout(t *testing.T) {
+	for _, name := range engine.Names() {
+		var out bytes.Buffer
+		e, err := engine.New(name, engine.Options{Stdout: &out})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		defer e.Close()
+		if err := e.DoString(`
+print(1, "a", nil, true, 2.5)
+print()
+io.write("x", 3, "\n")
+io.write()
+`, "=test"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if want := "1\ta\tnil\ttrue\t2.5\n\nx3\n"; out.String() != want {
+			t.Errorf("%s printed %q, want %q", name, out.String(), want)
+		}
+		for _, code := range []string{`io.write("ok", {})`, `io.stdout:write("ok", {})`} {
+			if err := e.DoString(code, "=test"); err == nil || !strings.Contains(err.Error(), "bad argument #2 to 'write'") {
+				t.Errorf("%s: %s returned %v", name, code, err)
+			}
+		}
+		out.Reset()
+		if err := e.DoString(`io.write("a"):write("b", 1):flush() io.stdout:write(tostring(io.stdout), "\n")`, "=test"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if want := "ab1file (stdout)\n"; out.String() != want {
+			t.Errorf("%s: chained writes printed %q, want %q", name, out.String(), want)
+		}
+
+		var other bytes.Buffer
+		e.SetStdout(&other)
+		if err := e.DoString(`print("moved")`, "=test"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if other.String() != "moved\n" || strings.Contains(out.String(), "moved") {
+			t.Errorf("%s: SetStdout printed %q, first writer got %q", name, other.String(), out.String())
+		}
+	}
+}
+
+func TestStdin(t *testing.T) {
+	const input = "line1\nline2\n42 rest\nlast"
+	tests := []struct {
+		code string
+		want []engine.Value
+	}{
+		{`io.read(), io.read("*L"), io.read("*n"), io.read(3), io.read("*a")`, []engine.Value{"line1", "line2\n", 42.0, " re", "st\nlast"}},
+		{`io.read("*l", "*l")`, []engine.Value{"line1", "line2"}},
+		{`io.read("*a"), io.read(), io.read(1), io.read(0), io.read("*a")`, []engine.Value{input, nil, nil, nil, ""}},
+		{`io.read(0), io.read("*n")`, []engine.Value{"", nil}},
+		{`(function()
+			local lines = {}
+			for line in io.lines() do lines[#lines + 1] = line end
+			return table.concat(lines, "|")
+		end)()`, []engine.Value{"line1|line2|42 rest|last"}},
+	}
+	for _, name := range engine.Names() {
+		e, err := engine.New(name, engine.Options{})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		defer e.Close()
+		for _, tt := range tests {
+			e.SetStdin(strings.NewReader(input))
+			got, err := e.Eval(tt.code)
+			if err != nil {
+				t.Errorf("%s: %s: %v", name, tt.code, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: %s = %q, want %q", name, tt.code, got, tt.want)
+			}
+		}
+		if _, err := e.Eval(`io.read("*x")`); err == nil || !strings.Contains(err.Error(), "invalid format") {
+			t.Errorf("%s: io.read(\"*x\") returned %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// TimeType and DurationType are the userdata types of time.Time and
+// time.Duration, the Objects of which ToValue converts them to and the time
+// library creates:
+//
+//	local t = time.parse(time.RFC3339, "2024-05-01T10:00:00Z")
+//	local later = t + 90 * time.minute
+//	print(later:format("15:04"), later - t, t < later)
+//
+// Times have the methods format([layout]), add(d), sub(t or d), unix(),
+// utc(), zone(name) and date(), the last returning a table like
+// os.date("*t"). Durations have seconds(), milliseconds(), minutes() and
+// hours(). Both compare with ==, < and <=, print and concatenate, and
+// durations can be given as numbers of seconds.
+var (
+	TimeType     = &Type{Name: "Time"}
+	DurationType = &Type{Name: "Duration"}
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+func init() {
+	TimeType.Methods = map[string]Function{
+		"format": func(args []Value) ([]Value, error) {
+			t, err := checkTime(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			layout := time.RFC3339Nano
+			if arg(args, 1) != nil {
+				if layout, err = CheckString(args, 1); err != nil {
+					return nil, err
+				}
+			}
+			return []Value{t.Format(layout)}, nil
+		},
+		"add":  timeAdd,
+		"sub":  timeSub,
+		"unix": timeMethod(func(t time.Time) Value { return float64(t.UnixNano()) / 1e9 }),
+		"utc":  timeMethod(func(t time.Time) Value { return TimeType.New(t.UTC()) }),
+		"zone": func(args []Value) ([]Value, error) {
+			t, err := checkTime(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			name, err := CheckString(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			loc, err := time.LoadLocation(name)
+			if err != nil {
+				return nil, ArgError(1, "unknown time zone "+name)
+			}
+			return []Value{TimeType.New(t.In(loc))}, nil
+		},
+		"date": timeMethod(func(t time.Time) Value {
+			date := NewTable()
+			date.Set("year", float64(t.Year()))
+			date.Set("month", float64(t.Month()))
+			date.Set("day", float64(t.Day()))
+			date.Set("hour", float64(t.Hour()))
+			date.Set("min", float64(t.Minute()))
+			date.Set("sec", float64(t.Second()))
+			date.Set("wday", float64(t.Weekday()+1))
+			date.Set("yday", float64(t.YearDay()))
+			return date
+		}),
+		"__add":      timeAdd,
+		"__sub":      timeSub,
+		"__eq":       timeCompare(func(t, u time.Time) bool { return t.Equal(u) }),
+		"__lt":       timeCompare(func(t, u time.Time) bool { return t.Before(u) }),
+		"__le":       timeCompare(func(t, u time.Time) bool { return !t.After(u) }),
+		"__concat":   timeConcat,
+		"__tostring": timeMethod(func(t time.Time) Value { return t.Format(time.RFC3339Nano) }),
+	}
+	DurationType.Methods = map[string]Function{
+		"seconds":      durationMethod(time.Duration.Seconds),
+		"milliseconds": durationMethod(func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }),
+		"minutes":      durationMethod(time.Duration.Minutes),
+		"hours":        durationMethod(time.Duration.Hours),
+		"__add": func(args []Value) ([]Value, error) {
+			if isType(arg(args, 1), TimeType) {
+				return timeAdd(args)
+			}
+			return durationOp(args, func(d, e time.Duration) time.Duration { return d + e })
+		},
+		"__sub": func(args []Value) ([]Value, error) {
+			return durationOp(args, func(d, e time.Duration) time.Duration { return d - e })
+		},
+		"__mul": func(args []Value) ([]Value, error) {
+			if !isType(arg(args, 0), DurationType) {
+				args = []Value{arg(args, 1), arg(args, 0)}
+			}
+			d, err := checkDuration(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			n, err := CheckNumber(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			return []Value{DurationType.New(time.Duration(math.Round(float64(d) * n)))}, nil
+		},
+		"__div": func(args []Value) ([]Value, error) {
+			d, err := checkDuration(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			if e, ok := arg(args, 1).(*Object); ok && e.Type == DurationType {
+				return []Value{float64(d) / float64(e.Value.(time.Duration))}, nil
+			}
+			n, err := CheckNumber(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			return []Value{DurationType.New(time.Duration(math.Round(float64(d) / n)))}, nil
+		},
+		"__unm": func(args []Value) ([]Value, error) {
+			d, err := checkDuration(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return []Value{DurationType.New(-d)}, nil
+		},
+		"__eq":     durationCompare(func(d, e time.Duration) bool { return d == e }),
+		"__lt":     durationCompare(func(d, e time.Duration) bool { return d < e }),
+		"__le":     durationCompare(func(d, e time.Duration) bool { return d <= e }),
+		"__concat": timeConcat,
+		"__tostring": func(args []Value) ([]Value, error) {
+			d, err := checkDuration(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return []Value{d.String()}, nil
+		},
+	}
+}
+
+// clock is the time library of a state, reading the current time from 'now':
+//
+//	time.now()              the current time
+//	time.since(t)           the duration elapsed since t
+//	time.parse(layout, s)   the time in s, with a layout of the time package
+//	time.unix(seconds)      the UTC time of a Unix timestamp
+//	time.duration(s)        a duration like "1h30m", or a number of seconds
+//
+// time.nanosecond to time.hour are durations, and time.RFC3339 and the other
+// layouts of the time package are strings.
+type clock struct {
+	now func() time.Time
+}
+
+func newClock(opts Options) *clock {
+	c := &clock{now: opts.Clock}
+	if c.now == nil {
+		c.now = time.Now
+	}
+	return c
+}
+
+var (
+	timeUnits = []struct {
+		name string
+		d    time.Duration
+	}{
+		{"nanosecond", time.Nanosecond},
+		{"microsecond", time.Microsecond},
+		{"millisecond", time.Millisecond},
+		{"second", time.Second},
+		{"minute", time.Minute},
+		{"hour", time.Hour},
+	}
+	timeLayouts = []struct{ name, layout string }{
+		{"ANSIC", time.ANSIC},
+		{"RFC822", time.RFC822},
+		{"RFC1123", time.RFC1123},
+		{"RFC3339", time.RFC3339},
+		{"RFC3339Nano", time.RFC3339Nano},
+		{"Kitchen", time.Kitchen},
+		{"DateTime", time.DateTime},
+		{"DateOnly", time.DateOnly},
+		{"TimeOnly", time.TimeOnly},
+	}
+)
+
+// install registers the time library and its types in 'e'.
+func (c *clock) install(e Engine) error {
+	err := e.Register(&Module{Name: "time", Types: []*Type{TimeType, DurationType}, Funcs: map[string]Function{
+		"now": func(args []Value) ([]Value, error) {
+			// Round(0) removes the monotonic clock reading, which String prints.
+			return []Value{TimeType.New(c.now().Round(0))}, nil
+		},
+		"since": func(args []Value) ([]Value, error) {
+			t, err := checkTime(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return []Value{DurationType.New(c.now().Sub(t))}, nil
+		},
+		"parse": func(args []Value) ([]Value, error) {
+			layout, err := CheckString(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			s, err := CheckString(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			t, err := time.Parse(layout, s)
+			if err != nil {
+				return nil, ArgError(1, err.Error())
+			}
+			return []Value{TimeType.New(t)}, nil
+		},
+		"unix": func(args []Value) ([]Value, error) {
+			s, err := CheckNumber(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return []Value{TimeType.New(time.Unix(0, 0).Add(seconds(s)).UTC())}, nil
+		},
+		"duration": func(args []Value) ([]Value, error) {
+			if s, ok := arg(args, 0).(string); ok {
+				d, err := time.ParseDuration(s)
+				if err != nil {
+					return nil, ArgError(0, err.Error())
+				}
+				return []Value{DurationType.New(d)}, nil
+			}
+			d, err := checkDuration(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return []Value{DurationType.New(d)}, nil
+		},
+	}})
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, u := range timeUnits {
+		fmt.Fprintf(&b, "time.%s = time.duration(%q)\n", u.name, u.d.String())
+	}
+	for _, l := range timeLayouts {
+		fmt.Fprintf(&b, "time.%s = %q\n", l.name, l.layout)
+	}
+	return e.DoString(b.String(), "=time")
+}
+
+// seconds converts a number of seconds to a Duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Round(s * float64(time.Second)))
+}
+
+func isType(v Value, t *Type) bool {
+	o, ok := v.(*Object)
+	return ok && o.Type == t
+}
+
+func checkTime(args []Value, i int) (time.Time, error) {
+	v, err := TimeType.Check(args, i)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return v.(time.Time), nil
+}
+
+// checkDuration returns argument 'i' if it is a Duration or a number of
+// seconds.
+func checkDuration(args []Value, i int) (time.Duration, error) {
+	switch v := arg(args, i).(type) {
+	case float64:
+		return seconds(v), nil
+	case *Object:
+		if v.Type == DurationType {
+			return v.Value.(time.Duration), nil
+		}
+	}
+	return 0, ArgError(i, "Duration expected, got "+TypeName(arg(args, i)))
+}
+
+// timeAdd adds a duration to a time, in either order for __add.
+func timeAdd(args []Value) ([]Value, error) {
+	if !isType(arg(args, 0), TimeType) {
+		args = []Value{arg(args, 1), arg(args, 0)}
+	}
+	t, err := checkTime(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	d, err := checkDuration(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return []Value{TimeType.New(t.Add(d))}, nil
+}
+
+// timeSub returns the duration between two times, or a time minus a duration.
+func timeSub(args []Value) ([]Value, error) {
+	t, err := checkTime(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if isType(arg(args, 1), TimeType) {
+		u, _ := checkTime(args, 1)
+		return []Value{DurationType.New(t.Sub(u))}, nil
+	}
+	d, err := checkDuration(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return []Value{TimeType.New(t.Add(-d))}, nil
+}
+
+func timeMethod(f func(t time.Time) Value) Function {
+	return func(args []Value) ([]Value, error) {
+		t, err := checkTime(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []Value{f(t)}, nil
+	}
+}
+
+func timeCompare(f func(t, u time.Time) bool) Function {
+	return func(args []Value) ([]Value, error) {
+		t, err := checkTime(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		u, err := checkTime(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return []Value{f(t, u)}, nil
+	}
+}
+
+func durationMethod(f func(d time.Duration) float64) Function {
+	return func(args []Value) ([]Value, error) {
+		d, err := checkDuration(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []Value{f(d)}, nil
+	}
+}
+
+// durationOp applies 'f' to two durations, either of which can be a number.
+func durationOp(args []Value, f func(d, e time.Duration) time.Duration) ([]Value, error) {
+	d, err := checkDuration(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	e, err := checkDuration(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return []Value{DurationType.New(f(d, e))}, nil
+}
+
+func durationCompare(f func(d, e time.Duration) bool) Function {
+	return func(args []Value) ([]Value, error) {
+		d, err := checkDuration(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		e, err := checkDuration(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return []Value{f(d, e)}, nil
+	}
+}
+
+// timeConcat is the __concat of times and durations, which concatenate as
+// they print.
+func timeConcat(args []Value) ([]Value, error) {
+	var b strings.Builder
+	for i := 0; i < 2; i++ {
+		v := arg(args, i)
+		switch x := v.(type) {
+		case string:
+			b.WriteString(x)
+			continue
+		case float64:
+			b.WriteString(formatNumber(x))
+			continue
+		case *Object:
+			if s, ok := timeString(x); ok {
+				b.WriteString(s)
+				continue
+			}
+		}
+		return nil, &Error{Kind: RuntimeError, Message: "attempt to concatenate a " + TypeName(v) + " value"}
+	}
+	return []Value{b.String()}, nil
+}
+
+// timeString returns the string of a Time or Duration Object.
+func timeString(o *Object) (string, bool) {
+	switch v := o.Value.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano), true
+	case time.Duration:
+		return v.String(), true
+	}
+	return "", false
+}
@@ -0,0 +1,118 @@
+package engine_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+var testNow = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+func TestTime(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []engine.Value
+	}{
+		{`time.now() == time.parse(time.RFC3339, "2024-05-01T10:00:00Z")`, []engine.Value{true}},
+		{`time.now():format("2006-01-02 15:04"), time.now():format()`, []engine.Value{"2024-05-01 10:00", "2024-05-01T10:00:00Z"}},
+		{`(time.now() + 90 * time.minute):format(time.Kitchen), (90 + time.now()):format("15:04:05")`, []engine.Value{"11:30AM", "10:01:30"}},
+		{`time.now():add(time.second):sub(time.now()):seconds(), (time.now() - 60):format("15:04")`, []engine.Value{1.0, "09:59"}},
+		{`(time.now() - time.parse(time.RFC3339, "2024-05-01T08:30:00Z")):minutes()`, []engine.Value{90.0}},
+		{`time.since(time.parse(time.DateTime, "2024-05-01 09:00:00")):hours()`, []engine.Value{1.0}},
+		{`time.now() < time.now() + 1, time.now() <= time.now(), time.now() > time.now(), time.hour > time.minute`, []engine.Value{true, true, false, true}},
+		{`time.now() - time.hour == time.parse(time.RFC3339, "2024-05-01T09:00:00Z")`, []engine.Value{true}},
+		{`tostring(time.duration("1h30m")), time.duration(90):minutes(), (time.hour / 4):minutes(), time.hour / time.minute`, []engine.Value{"1h30m0s", 1.5, 15.0, 60.0}},
+		{`"at " .. time.unix(0)`, []engine.Value{"at 1970-01-01T00:00:00Z"}},
+		{`tostring(-time.second), time.millisecond:milliseconds()`, []engine.Value{"-1s", 1.0}},
+		{`time.now():unix(), time.now():date().yday, time.now():date().wday, time.now():utc() == time.now()`, []engine.Value{1714557600.0, 122.0, 4.0, true}},
+	}
+	for _, name := range engine.Names() {
+		e, err := engine.New(name, engine.Options{Clock: func() time.Time { return testNow }})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		defer e.Close()
+		for _, tt := range tests {
+			got, err := e.Eval(tt.expr)
+			if err != nil {
+				t.Errorf("%s: %s: %v", name, tt.expr, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: %s = %v, want %v", name, tt.expr, got, tt.want)
+			}
+		}
+		for _, expr := range []string{`time.parse(time.RFC3339, "noon")`, `time.duration("soon")`, `time.now() + "x"`, `time.now():zone("Nowhere/City")`} {
+			if _, err := e.Eval(expr); err == nil || !strings.Contains(err.Error(), "bad argument") {
+				t.Errorf("%s: %s returned %v", name, expr, err)
+			}
+		}
+
+		values, err := e.Eval("time.now(), time.minute")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var now time.Time
+		var minute time.Duration
+		if err := engine.FromValue(values[0], &now); err != nil || !now.Equal(testNow) {
+			t.Errorf("%s: time.now() is %v, %v", name, now, err)
+		}
+		if err := engine.FromValue(values[1], &minute); err != nil || minute != time.Minute {
+			t.Errorf("%s: time.minute is %v, %v", name, minute, err)
+		}
+	}
+}
+
+type member struct {
+	Name   string
+	Joined time.Time
+	Term   time.Duration
+	Dates  []time.Time
+}
+
+// TestTimeFields checks that the time fields of Go structs are Times and
+// Durations in Lua, whether the struct is converted to a table or proxied by
+// luar on golua-luar.
+func TestTimeFields(t *testing.T) {
+	for _, name := range engine.Names() {
+		e, err := engine.New(name, engine.Options{})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		defer e.Close()
+		m := &member{Name: "ada", Joined: testNow, Term: time.Hour, Dates: []time.Time{testNow}}
+		if err := e.SetGlobal("m", m); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := e.DoString(`
+local Time, Duration = getmetatable(time.now()), getmetatable(time.second)
+assert(getmetatable(m.Joined) == Time, "Joined is not a Time")
+assert(getmetatable(m.Term) == Duration, "Term is not a Duration")
+assert(getmetatable(m.Dates[1]) == Time, "Dates[1] is not a Time")
+assert(m.Joined:format("15:04") == "10:00" and m.Term:minutes() == 60)
+m.Joined = m.Joined + 24 * time.hour
+m.Term = m.Term * 2
+`, "=test"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		v, err := e.Global("m")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var got member
+		if o, ok := v.(*engine.Object); ok {
+			// a luar proxy, which changed 'm'
+			got = *o.Value.(*member)
+		} else if err := engine.FromValue(v, &got); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		want := member{Name: "ada", Joined: testNow.Add(24 * time.Hour), Term: 2 * time.Hour, Dates: []time.Time{testNow}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", name, got, want)
+		}
+	}
+}
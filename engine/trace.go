@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+
+	"github.com/rickcrawford/go-lua-test/tracing"
+)
+
+// tracer creates the spans of the calls into a state and of the Go functions
+// they call. The spans of the calls are children of the span of the context
+// set with SetContext.
+type tracer struct {
+	tracer *tracing.Tracer
+	engine string
+	limits *limits
+	// ctx holds the running span.
+	ctx context.Context
+}
+
+// newTracer returns the tracer of an engine, nil without Options.Tracer.
+func newTracer(opts Options, engine string, l *limits) *tracer {
+	if opts.Tracer == nil {
+		return nil
+	}
+	return &tracer{tracer: opts.Tracer, engine: engine, limits: l}
+}
+
+// call starts the span of a call into the state: DoString, DoFile, Eval or
+// Call, of the function or chunk 'name'. It returns the function ending the
+// span with the error of the call.
+func (t *tracer) call(method, name string) func(error) {
+	if t == nil {
+		return func(error) {}
+	}
+	key := "lua.source"
+	if method == "Call" {
+		key = "lua.function"
+	}
+	return t.start("lua."+method, key, name)
+}
+
+// callback starts the span of the Go function 'name' called by Lua code at
+// the position where(1).
+func (t *tracer) callback(name string, f Function, where func(level int) string) func(error) {
+	if t == nil {
+		return func(error) {}
+	}
+	if name == "" {
+		name = funcName(f)
+	}
+	return t.start("go.Function", "go.function", name, "lua.source", trimWhere(where(1)))
+}
+
+// start starts a span with the attributes 'attrs', given as key and value
+// pairs.
+func (t *tracer) start(spanName string, attrs ...string) func(error) {
+	parent := t.ctx
+	if parent == nil {
+		parent = t.limits.ctx
+	}
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, span := t.tracer.Start(parent, spanName)
+	span.SetAttribute("lua.engine", t.engine)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if attrs[i+1] != "" {
+			span.SetAttribute(attrs[i], attrs[i+1])
+		}
+	}
+	previous := t.ctx
+	t.ctx = ctx
+	return func(err error) {
+		t.ctx = previous
+		if err != nil {
+			span.SetError(err)
+		}
+		span.End()
+	}
+}
+
+// funcName returns the name of the Go function of 'f', as the Functions passed
+// as values are not named.
+func funcName(f Function) string {
+	if fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return "?"
+}
+
+// trimWhere returns the position "chunk:line" of a position of an error
+// message, "chunk:line: ".
+func trimWhere(where string) string {
+	for len(where) > 0 && (where[len(where)-1] == ' ' || where[len(where)-1] == ':') {
+		where = where[:len(where)-1]
+	}
+	return where
+}
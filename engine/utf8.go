@@ -0,0 +1,299 @@
+package engine
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// utf8Lib is the utf8 library, working on the UTF-8 characters of strings the
+// same way on every engine:
+//
+//	utf8.len(s)                 the number of characters, nil and the position
+//	                            of the first invalid byte if s is not UTF-8
+//	utf8.sub(s, i [, j])        string.sub counting characters
+//	utf8.upper(s), utf8.lower(s)
+//	utf8.codepoints(s [, i [, j]])  the table of the code points of characters
+//	                            i to j
+//	utf8.char(...)              the string of code points
+//	utf8.offset(s, n [, i])     the byte position of character n, counted
+//	                            from byte i, as in Lua 5.3
+//	utf8.reverse(s)             s with its characters reversed, keeping the
+//	                            combining marks, emoji sequences and flags
+//
+// Invalid bytes count as one character, except for utf8.len and
+// utf8.codepoints.
+var utf8Lib = &Module{Name: "utf8", Funcs: map[string]Function{
+	"len": func(args []Value) ([]Value, error) {
+		s, err := CheckString(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(s); {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				return []Value{nil, float64(i + 1)}, nil
+			}
+			i += size
+		}
+		return []Value{float64(utf8.RuneCountInString(s))}, nil
+	},
+	"sub":   utf8Sub,
+	"upper": stringFunc(strings.ToUpper),
+	"lower": stringFunc(strings.ToLower),
+	"codepoints": func(args []Value) ([]Value, error) {
+		s, err := CheckString(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		offsets := runeOffsets(s)
+		i, j, err := runeRange(args, 1, len(offsets))
+		if err != nil {
+			return nil, err
+		}
+		t := NewTable()
+		for k := i; k <= j; k++ {
+			r, size := utf8.DecodeRuneInString(s[offsets[k-1]:])
+			if r == utf8.RuneError && size == 1 {
+				return nil, ArgError(0, "invalid UTF-8 code")
+			}
+			t.Array = append(t.Array, float64(r))
+		}
+		return []Value{t}, nil
+	},
+	"char": func(args []Value) ([]Value, error) {
+		var b strings.Builder
+		for i := range args {
+			n, err := CheckNumber(args, i)
+			if err != nil {
+				return nil, err
+			}
+			if n < 0 || n > unicode.MaxRune || n != float64(rune(n)) {
+				return nil, ArgError(i, "value out of range")
+			}
+			b.WriteRune(rune(n))
+		}
+		return []Value{b.String()}, nil
+	},
+	"offset": func(args []Value) ([]Value, error) {
+		s, err := CheckString(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		n, err := CheckNumber(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		i := 1
+		if n < 0 {
+			i = len(s) + 1
+		}
+		if arg(args, 2) != nil {
+			f, err := CheckNumber(args, 2)
+			if err != nil {
+				return nil, err
+			}
+			if i = int(f); i < 0 {
+				i += len(s) + 1
+			}
+			if i < 1 || i > len(s)+1 {
+				return nil, ArgError(2, "position out of range")
+			}
+		}
+		if pos := utf8Offset(s, int(n), i-1); pos >= 0 {
+			return []Value{float64(pos + 1)}, nil
+		}
+		return []Value{nil}, nil
+	},
+	"reverse": stringFunc(func(s string) string {
+		clusters := graphemes(s)
+		var b strings.Builder
+		b.Grow(len(s))
+		for i := len(clusters) - 1; i >= 0; i-- {
+			b.WriteString(clusters[i])
+		}
+		return b.String()
+	}),
+}}
+
+// utf8Strings replaces string.len and string.sub by functions counting
+// characters. string.len counts the invalid bytes as characters rather than
+// failing like utf8.len.
+var utf8Strings = &Module{Name: "string", Funcs: map[string]Function{
+	"len": func(args []Value) ([]Value, error) {
+		s, err := CheckString(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []Value{float64(utf8.RuneCountInString(s))}, nil
+	},
+	"sub": utf8Sub,
+}}
+
+// installUTF8 registers the utf8 library in 'e', and with 'runes' replaces
+// string.len and string.sub.
+func installUTF8(e Engine, runes bool) error {
+	if err := e.Register(utf8Lib); err != nil {
+		return err
+	}
+	if runes {
+		return e.Register(utf8Strings)
+	}
+	return nil
+}
+
+func utf8Sub(args []Value) ([]Value, error) {
+	s, err := CheckString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	offsets := runeOffsets(s)
+	i, j, err := runeRange(args, 1, len(offsets))
+	if err != nil {
+		return nil, err
+	}
+	if i > j {
+		return []Value{""}, nil
+	}
+	end := len(s)
+	if j < len(offsets) {
+		end = offsets[j]
+	}
+	return []Value{s[offsets[i-1]:end]}, nil
+}
+
+// runeOffsets returns the byte offsets of the characters of 's', the invalid
+// bytes being characters.
+func runeOffsets(s string) []int {
+	offsets := make([]int, 0, len(s))
+	for i := 0; i < len(s); {
+		offsets = append(offsets, i)
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return offsets
+}
+
+// runeRange returns the optional arguments 'i' and 'i'+1 as a range of 1 to
+// 'n' characters, counted from the end when negative as in string.sub.
+// 'i' is 1 and 'j' -1 by default, 'i' > 'j' for an empty range.
+func runeRange(args []Value, i, n int) (int, int, error) {
+	bounds := [2]int{1, -1}
+	for k := range bounds {
+		if arg(args, i+k) == nil {
+			continue
+		}
+		f, err := CheckNumber(args, i+k)
+		if err != nil {
+			return 0, 0, err
+		}
+		bounds[k] = int(f)
+	}
+	from, to := bounds[0], bounds[1]
+	if from < 0 {
+		from += n + 1
+	}
+	if to < 0 {
+		to += n + 1
+	}
+	if from < 1 {
+		from = 1
+	}
+	if to > n {
+		to = n
+	}
+	return from, to, nil
+}
+
+// utf8Offset returns the byte offset of character 'n' of 's' counted from
+// the character at byte offset 'i', or of the character holding byte 'i' when
+// 'n' is 0, -1 if there is none.
+func utf8Offset(s string, n, i int) int {
+	start := func(i int) bool { return i >= len(s) || utf8.RuneStart(s[i]) }
+	if n == 0 {
+		for i > 0 && !start(i) {
+			i--
+		}
+		return i
+	}
+	if !start(i) {
+		return -1
+	}
+	if n < 0 {
+		for ; n < 0 && i > 0; n++ {
+			i--
+			for i > 0 && !start(i) {
+				i--
+			}
+		}
+	} else {
+		for n--; n > 0 && i < len(s); n-- {
+			i++
+			for !start(i) {
+				i++
+			}
+		}
+	}
+	if n != 0 {
+		return -1
+	}
+	return i
+}
+
+func stringFunc(f func(s string) string) Function {
+	return func(args []Value) ([]Value, error) {
+		s, err := CheckString(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []Value{f(s)}, nil
+	}
+}
+
+// graphemes splits 's' into its user-perceived characters, approximating the
+// grapheme clusters of Unicode: a character with the combining marks,
+// variation selectors, emoji modifiers and tags following it, characters
+// joined by zero width joiners, pairs of regional indicators forming a flag,
+// and CR LF.
+func graphemes(s string) []string {
+	var clusters []string
+	start, regional := 0, 0
+	prev := rune(-1)
+	for i, r := range s {
+		if i > start && !extends(prev, r, regional) {
+			clusters = append(clusters, s[start:i])
+			start, regional = i, 0
+		}
+		if isRegional(r) {
+			regional++
+		}
+		prev = r
+	}
+	if start < len(s) {
+		clusters = append(clusters, s[start:])
+	}
+	return clusters
+}
+
+// zwj is the zero width joiner.
+const zwj = '\u200d'
+
+// extends reports whether 'r' continues the cluster ending with 'prev', which
+// holds 'regional' regional indicators.
+func extends(prev, r rune, regional int) bool {
+	switch {
+	case r == zwj || prev == zwj:
+		return true
+	case unicode.Is(unicode.M, r):
+		return true
+	case r >= 0xfe00 && r <= 0xfe0f, r >= 0x1f3fb && r <= 0x1f3ff, r >= 0xe0020 && r <= 0xe007f:
+		return true
+	case isRegional(r) && isRegional(prev):
+		return regional%2 == 1
+	}
+	return prev == '\r' && r == '\n'
+}
+
+func isRegional(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
@@ -0,0 +1,87 @@
+package engine_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+// utf8Globals are set from Go, since Lua 5.1 has no \u escapes.
+var utf8Globals = map[string]string{
+	"s":    "héllo wörld",
+	"comb": "noël", // 'e' and a combining diaeresis
+	"flag": "🇫🇷!",
+	"bad":  "a\xffb",
+}
+
+func newUTF8Engine(t *testing.T, name string, opts engine.Options) engine.Engine {
+	t.Helper()
+	e, err := engine.New(name, opts)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	t.Cleanup(e.Close)
+	for k, v := range utf8Globals {
+		if err := e.SetGlobal(k, v); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	return e
+}
+
+func TestUTF8(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []engine.Value
+	}{
+		{`utf8.len(s), utf8.len(comb), utf8.len(""), utf8.len(bad)`, []engine.Value{11.0, 5.0, 0.0, nil, 2.0}},
+		{`utf8.sub(s, 2, 4), utf8.sub(s, -5), utf8.sub(s, 4, 2), utf8.sub(comb, 3, 4), utf8.sub(bad, 2, 2)`, []engine.Value{"éll", "wörld", "", "ë", "\xff"}},
+		{`utf8.offset(s, 3), utf8.offset(s, -1), utf8.offset(s, 0, 3), utf8.offset(s, 2, 3), utf8.offset(s, 20)`, []engine.Value{4.0, 13.0, 2.0, nil, nil}},
+		{`utf8.char(72, 233, 0x1F600), utf8.char()`, []engine.Value{"Hé😀", ""}},
+		{`table.concat(utf8.codepoints(comb), ","), table.concat(utf8.codepoints(s, 2, -9), ",")`, []engine.Value{"110,111,101,776,108", "233,108"}},
+		{`utf8.reverse(comb), utf8.reverse(flag), utf8.reverse("ab\r\n"), utf8.reverse("")`, []engine.Value{"lëon", "!🇫🇷", "\r\nba", ""}},
+		{`utf8.upper(s), utf8.lower("ÉTÉ")`, []engine.Value{"HÉLLO WÖRLD", "été"}},
+		{`s:len(), #s, s:sub(2, 3)`, []engine.Value{13.0, 13.0, "\xc3\xa9"}},
+	}
+	for _, name := range engine.Names() {
+		e := newUTF8Engine(t, name, engine.Options{})
+		for _, tt := range tests {
+			got, err := e.Eval(tt.expr)
+			if err != nil {
+				t.Errorf("%s: %s: %v", name, tt.expr, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: %s = %q, want %q", name, tt.expr, got, tt.want)
+			}
+		}
+		for expr, msg := range map[string]string{
+			`utf8.char(-1)`:         "value out of range",
+			`utf8.char(1.5)`:        "value out of range",
+			`utf8.codepoints(bad)`:  "invalid UTF-8 code",
+			`utf8.offset(s, 1, 20)`: "position out of range",
+			`utf8.len({})`:          "bad argument #1",
+		} {
+			if _, err := e.Eval(expr); err == nil || !strings.Contains(err.Error(), msg) {
+				t.Errorf("%s: %s returned %v", name, expr, err)
+			}
+		}
+	}
+}
+
+func TestUTF8Strings(t *testing.T) {
+	const expr = `s:len(), #s, s:sub(2, 2), string.sub(comb, -2), comb:len(), bad:len(), bad:sub(2, 3)`
+	want := []engine.Value{11.0, 13.0, "é", "̈l", 5.0, 3.0, "\xffb"}
+	for _, name := range engine.Names() {
+		e := newUTF8Engine(t, name, engine.Options{UTF8Strings: true})
+		got, err := e.Eval(expr)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %s = %q, want %q", name, expr, got, want)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Value is a Lua value seen from Go. It is one of:
+//
+//	nil            nil
+//	bool           boolean
+//	float64        number
+//	string         string
+//	*Table         table, copied
+//	*Object        userdata of a Type, or a Go value proxied by luar
+//	*Func          Lua function, or Go function not created from a Function
+//	Function       Go function
+//	Opaque         anything else: coroutines, foreign userdata
+//
+// Other Go values given to an engine, integers, slices, maps and structs, are
+// converted with ToValue, except on golua-luar which pushes luar proxies for
+// them.
+type Value interface{}
+
+// Function is a Go function callable from Lua. Methods receive the object as
+// the first argument.
+type Function func(args []Value) ([]Value, error)
+
+// Table is a copy of a Lua table. The values of the keys 1 to n are in Array,
+// the other keys are converted to strings in Fields. The keys of Fields which
+// are integers, like "0", are numbers in Lua.
+type Table struct {
+	Array  []Value
+	Fields map[string]Value
+}
+
+// NewTable returns an empty table.
+func NewTable() *Table {
+	return &Table{Fields: map[string]Value{}}
+}
+
+// Get returns the field 'key'.
+func (t *Table) Get(key string) Value {
+	if i, err := strconv.Atoi(key); err == nil && i >= 1 && i <= len(t.Array) {
+		return t.Array[i-1]
+	}
+	return t.Fields[key]
+}
+
+// Set sets the field 'key'.
+func (t *Table) Set(key string, v Value) {
+	if t.Fields == nil {
+		t.Fields = map[string]Value{}
+	}
+	t.Fields[key] = v
+}
+
+// Keys returns the keys of the table: the indexes of Array then the keys of
+// Fields, sorted.
+func (t *Table) Keys() []string {
+	keys := make([]string, 0, len(t.Array)+len(t.Fields))
+	for i := range t.Array {
+		keys = append(keys, strconv.Itoa(i+1))
+	}
+	return append(keys, sortedKeys(t.Fields)...)
+}
+
+// Object is a Go value in a Lua userdata. Type is nil for the values proxied
+// by luar on golua-luar, and for the Go values without a Lua conversion.
+type Object struct {
+	Type  *Type
+	Value interface{}
+}
+
+// Func is a Lua function. Calling it runs the function on the engine that
+// returned it, so, like the engine, it must not be used concurrently.
+type Func struct {
+	// engine is the engine of the function and ref its handle there.
+	engine Engine
+	ref    interface{}
+	call   func(args []Value) ([]Value, error)
+}
+
+// Call calls the function.
+func (f *Func) Call(args ...Value) ([]Value, error) {
+	return f.call(args)
+}
+
+// Opaque is a Lua value without a Go conversion.
+type Opaque struct {
+	// TypeName is the Lua type of the value: "thread", "userdata"...
+	TypeName string
+	// Address identifies the value, as printed by tostring.
+	Address string
+}
+
+func (o Opaque) String() string {
+	return o.TypeName + ": " + o.Address
+}
+
+// TypeName returns the Lua type of 'v', as returned by the type function.
+func TypeName(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *Table:
+		return "table"
+	case *Func, Function:
+		return "function"
+	case Opaque:
+		return v.TypeName
+	}
+	return "userdata"
+}
+
+// ToValue converts a Go value to a Value: numbers to float64, pointers to the
+// value they point to, slices and arrays to tables with an Array part, maps and
+// structs to tables with Fields. Struct fields are named after their 'lua' tag
+// if they have one. Errors are converted to their message, time.Time and
+// time.Duration values to Objects of TimeType and DurationType. Values without
+// a conversion, channels and funcs, become an Object without a Type.
+func ToValue(v interface{}) Value {
+	switch v := v.(type) {
+	case nil, bool, float64, string, *Table, *Object, *Func, Function, Opaque:
+		return v
+	case error:
+		return v.Error()
+	case time.Time:
+		return TimeType.New(v)
+	case time.Duration:
+		return DurationType.New(v)
+	}
+	return toValue(reflect.ValueOf(v), map[uintptr]*Table{})
+}
+
+func toValue(v reflect.Value, seen map[uintptr]*Table) Value {
+	if v.IsValid() && (v.Type() == timeType || v.Type() == durationType) {
+		return ToValue(v.Interface())
+	}
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return ToValue(v.Interface())
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		if t, ok := seen[v.Pointer()]; ok {
+			return t
+		}
+		if v.Elem().Kind() == reflect.Struct && v.Elem().Type() != timeType {
+			t := NewTable()
+			seen[v.Pointer()] = t
+			structFields(t, v.Elem(), seen)
+			return t
+		}
+		return toValue(v.Elem(), seen)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice {
+			if v.IsNil() {
+				return nil
+			}
+			if v.Type().Elem().Kind() == reflect.Uint8 {
+				return string(v.Bytes())
+			}
+		}
+		t := NewTable()
+		if v.Kind() == reflect.Slice && v.Len() > 0 {
+			seen[v.Pointer()] = t
+		}
+		for i := 0; i < v.Len(); i++ {
+			t.Array = append(t.Array, toValue(v.Index(i), seen))
+		}
+		return t
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		if t, ok := seen[v.Pointer()]; ok {
+			return t
+		}
+		t := NewTable()
+		seen[v.Pointer()] = t
+		for _, k := range v.MapKeys() {
+			t.Fields[fmt.Sprint(k.Interface())] = toValue(v.MapIndex(k), seen)
+		}
+		return t
+	case reflect.Struct:
+		t := NewTable()
+		structFields(t, v, seen)
+		return t
+	}
+	return &Object{Value: v.Interface()}
+}
+
+func structFields(t *Table, v reflect.Value, seen map[uintptr]*Table) {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("lua"); tag != "" {
+			name = tag
+		}
+		t.Fields[name] = toValue(v.Field(i), seen)
+	}
+}
+
+func sortedKeys(m map[string]Value) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sortKeys(keys)
+	return keys
+}
+
+// sortKeys sorts numeric keys by value before the other keys.
+func sortKeys(keys []string) {
+	less := func(a, b string) bool {
+		na, erra := strconv.ParseFloat(a, 64)
+		nb, errb := strconv.ParseFloat(b, 64)
+		switch {
+		case erra == nil && errb == nil:
+			return na < nb
+		case erra == nil:
+			return true
+		case errb == nil:
+			return false
+		}
+		return a < b
+	}
+	sort.Slice(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
+}
+
+// keyString converts a table key to the string used in Table.Fields.
+func keyString(k Value) string {
+	switch k := k.(type) {
+	case string:
+		return k
+	case float64:
+		return formatNumber(k)
+	case fmt.Stringer:
+		return k.String()
+	}
+	return fmt.Sprint(k)
+}
+
+// formatNumber formats a number like Lua's tostring.
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+func formatAddress(p uintptr) string {
+	return fmt.Sprintf("%#x", p)
+}
+
+// ToGo converts a value to plain Go values, as expected by encoding/json:
+// tables with only an Array part to []interface{}, other tables to
+// map[string]interface{}, Objects to their Go value and functions to nil.
+// Tables found again while converting them are converted to nil.
+func ToGo(v Value) interface{} {
+	return toGo(v, map[*Table]bool{})
+}
+
+func toGo(v Value, seen map[*Table]bool) interface{} {
+	switch v := v.(type) {
+	case *Table:
+		if seen[v] {
+			return nil
+		}
+		seen[v] = true
+		defer delete(seen, v)
+		if len(v.Fields) == 0 && len(v.Array) > 0 {
+			s := make([]interface{}, len(v.Array))
+			for i, item := range v.Array {
+				s[i] = toGo(item, seen)
+			}
+			return s
+		}
+		m := make(map[string]interface{}, len(v.Array)+len(v.Fields))
+		for i, item := range v.Array {
+			m[strconv.Itoa(i+1)] = toGo(item, seen)
+		}
+		for k, item := range v.Fields {
+			m[k] = toGo(item, seen)
+		}
+		return m
+	case *Object:
+		return v.Value
+	case *Func, Function:
+		return nil
+	case Opaque:
+		return v.String()
+	}
+	return v
+}
+
+// FromValue sets the Go value pointed to by 'ptr' from 'v', the reverse of
+// ToValue: numbers to any number type, tables to slices, arrays, maps and
+// structs, whose fields are matched by their 'lua' tag or name, and Objects to
+// their Go value when it is assignable. time.Time values can also be RFC 3339
+// strings, and time.Duration values numbers of seconds or strings like "1h30m".
+// nil sets the zero value, the fields missing from a table are kept.
+func FromValue(v Value, ptr interface{}) error {
+	p := reflect.ValueOf(ptr)
+	if p.Kind() != reflect.Ptr || p.IsNil() {
+		return fmt.Errorf("FromValue needs a non-nil pointer, got %T", ptr)
+	}
+	return fromValue(v, p.Elem(), "")
+}
+
+// fromValue sets 'dst' from 'v', at 'path' in the converted value.
+func fromValue(v Value, dst reflect.Value, path string) error {
+	if v == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	if o, ok := v.(*Object); ok && o.Value != nil {
+		if ov := reflect.ValueOf(o.Value); ov.Type().AssignableTo(dst.Type()) {
+			dst.Set(ov)
+			return nil
+		}
+	}
+	switch dst.Type() {
+	case timeType:
+		if s, ok := v.(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return fromValueError(v, dst, path)
+			}
+			dst.Set(reflect.ValueOf(t))
+			return nil
+		}
+		return fromValueError(v, dst, path)
+	case durationType:
+		d, err := checkDuration([]Value{v}, 0)
+		if s, ok := v.(string); ok {
+			d, err = time.ParseDuration(s)
+		}
+		if err != nil {
+			return fromValueError(v, dst, path)
+		}
+		dst.SetInt(int64(d))
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.Interface:
+		if dst.NumMethod() == 0 {
+			if g := ToGo(v); g != nil {
+				dst.Set(reflect.ValueOf(g))
+			}
+			return nil
+		}
+	case reflect.Ptr:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return fromValue(v, dst.Elem(), path)
+	case reflect.Bool:
+		if b, ok := v.(bool); ok {
+			dst.SetBool(b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, ok := v.(float64); ok && n == math.Trunc(n) && !dst.OverflowInt(int64(n)) {
+			dst.SetInt(int64(n))
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n, ok := v.(float64); ok && n >= 0 && n == math.Trunc(n) && !dst.OverflowUint(uint64(n)) {
+			dst.SetUint(uint64(n))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if n, ok := v.(float64); ok {
+			dst.SetFloat(n)
+			return nil
+		}
+	case reflect.String:
+		if s, ok := v.(string); ok {
+			dst.SetString(s)
+			return nil
+		}
+	case reflect.Slice:
+		if s, ok := v.(string); ok && dst.Type().Elem().Kind() == reflect.Uint8 {
+			dst.SetBytes([]byte(s))
+			return nil
+		}
+		if t, ok := v.(*Table); ok && len(t.Fields) == 0 {
+			s := reflect.MakeSlice(dst.Type(), len(t.Array), len(t.Array))
+			for i, item := range t.Array {
+				if err := fromValue(item, s.Index(i), path+"["+strconv.Itoa(i+1)+"]"); err != nil {
+					return err
+				}
+			}
+			dst.Set(s)
+			return nil
+		}
+	case reflect.Array:
+		if t, ok := v.(*Table); ok && len(t.Fields) == 0 && len(t.Array) <= dst.Len() {
+			for i := 0; i < dst.Len(); i++ {
+				if err := fromValue(arg(t.Array, i), dst.Index(i), path+"["+strconv.Itoa(i+1)+"]"); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		if t, ok := v.(*Table); ok {
+			if dst.IsNil() {
+				dst.Set(reflect.MakeMap(dst.Type()))
+			}
+			for _, k := range t.Keys() {
+				key := reflect.New(dst.Type().Key()).Elem()
+				var kv Value = k
+				if n, ok := fieldKey(k); ok && key.Kind() != reflect.String {
+					kv = n
+				}
+				if err := fromValue(kv, key, path); err != nil {
+					return err
+				}
+				item := reflect.New(dst.Type().Elem()).Elem()
+				if err := fromValue(t.Get(k), item, valuePath(path, k)); err != nil {
+					return err
+				}
+				dst.SetMapIndex(key, item)
+			}
+			return nil
+		}
+	case reflect.Struct:
+		if t, ok := v.(*Table); ok {
+			typ := dst.Type()
+			for i := 0; i < typ.NumField(); i++ {
+				f := typ.Field(i)
+				if f.PkgPath != "" {
+					continue
+				}
+				name := f.Name
+				if tag := f.Tag.Get("lua"); tag != "" {
+					name = tag
+				}
+				if item := t.Get(name); item != nil {
+					if err := fromValue(item, dst.Field(i), valuePath(path, name)); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+	}
+	return fromValueError(v, dst, path)
+}
+
+// valuePath returns the path of the field 'key' of the table at 'path'.
+func valuePath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	if identifier.MatchString(key) {
+		return path + "." + key
+	}
+	return path + "[" + strconv.Quote(key) + "]"
+}
+
+func fromValueError(v Value, dst reflect.Value, path string) error {
+	msg := "cannot convert " + TypeName(v) + " to " + dst.Type().String()
+	if path != "" {
+		msg = path + ": " + msg
+	}
+	return errors.New(msg)
+}
+
+// fieldKey returns the number of the key 'k' of Table.Fields when it is an
+// integer.
+func fieldKey(k string) (float64, bool) {
+	n, err := strconv.Atoi(k)
+	if err != nil || strconv.Itoa(n) != k {
+		return 0, false
+	}
+	return float64(n), true
+}
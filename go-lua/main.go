@@ -199,6 +199,26 @@ func runMemberTest(L *lua.State) {
 	}
 }
 
+// runHookTest counts the instructions executed by account_test with a count
+// hook. Tested with each mask alone, go-lua runs the count and return hooks,
+// but fails with an index out of range [-1] on the call and line hooks, and on
+// lua.Info for the current line from a count hook.
+func runHookTest(L *lua.State) {
+	fmt.Printf("runHookTest, top stack: %d\n", L.Top())
+
+	instructions := 0
+	lua.SetDebugHook(L, func(L *lua.State, ar lua.Debug) {
+		instructions++
+	}, lua.MaskCount, 1)
+	defer lua.SetDebugHook(L, nil, 0, 0)
+
+	L.Global("account_test")
+	if err := L.ProtectedCall(0, 0, 0); err != nil {
+		log.Println(err)
+	}
+	log.Printf("account_test: %d instructions executed\n", instructions)
+}
+
 func main() {
 	L := lua.NewState()
 	lua.OpenLibraries(L)
@@ -216,6 +236,8 @@ func main() {
 
 	runMemberTest(L)
 
+	runHookTest(L)
+
 	fmt.Printf("top stack: %d\n", L.Top())
 
 }
@@ -0,0 +1,617 @@
+// Package lint checks Lua scripts against the API of the states running them,
+// a manifest.Manifest, without running them:
+//
+//	m, err := manifest.New("golua", engine.Options{Modules: bindings.Modules()})
+//	issues, err := lint.File(m, "luac/test.lua")
+//	for _, issue := range issues {
+//		fmt.Println(issue)   // luac/test.lua:19: unknown method 'withdraw', did you mean 'withdrawl'?
+//	}
+//
+// It reports the globals which are neither in the manifest nor set by the
+// script, the globals set in functions without being set at the top level of
+// the script, the fields and methods of the manifest which do not exist, the
+// calls of functions of the manifest with a wrong number of arguments and the
+// local variables which are never read. The local variables named "_" or
+// starting with "_", the parameters and the loop variables are not reported.
+package lint
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+
+	"github.com/rickcrawford/go-lua-test/manifest"
+)
+
+// Issue is a problem found in a script.
+type Issue struct {
+	File    string
+	Line    int
+	Message string
+}
+
+// String formats the issue as "file:line: message".
+func (i Issue) String() string {
+	return fmt.Sprintf("%s:%d: %s", i.File, i.Line, i.Message)
+}
+
+// File checks the Lua file at 'path'.
+func File(m *manifest.Manifest, path string) ([]Issue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Check(m, path, f)
+}
+
+// Check checks the Lua code read from 'r', 'name' being the file of the
+// issues. A syntax error is returned as the only issue.
+func Check(m *manifest.Manifest, name string, r io.Reader) ([]Issue, error) {
+	code, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	chunk, err := parse.Parse(strings.NewReader(string(code)), name)
+	if err != nil {
+		perr, ok := err.(*parse.Error)
+		if !ok {
+			return nil, err
+		}
+		line := perr.Pos.Line
+		if line == parse.EOF {
+			line = strings.Count(string(code), "\n") + 1
+		}
+		return []Issue{{File: name, Line: line, Message: "syntax error: " + perr.Message}}, nil
+	}
+	l := &linter{
+		m:        m,
+		file:     name,
+		declared: map[string]bool{},
+		set:      map[string]bool{},
+		fields:   map[string]bool{},
+		names:    map[string]bool{},
+	}
+	l.function(nil, chunk, false)
+	l.check()
+	sort.SliceStable(l.issues, func(i, j int) bool { return l.issues[i].Line < l.issues[j].Line })
+	return l.issues, nil
+}
+
+// linter walks a chunk, reporting the unused locals when their scope ends and
+// recording the uses of the globals, which are checked once the globals set by
+// the whole chunk are known.
+type linter struct {
+	m      *manifest.Manifest
+	file   string
+	issues []Issue
+
+	scope *scope
+	// depth is the number of functions around the statement walked, 1 at the
+	// top level of the chunk.
+	depth int
+
+	// declared are the globals set at the top level, set the globals set
+	// anywhere.
+	declared, set map[string]bool
+	// fields are the fields "global.name" set by the chunk, names the names of
+	// all the fields and methods it sets.
+	fields, names map[string]bool
+	refs          []ref
+}
+
+// scope is a block of local variables.
+type scope struct {
+	parent *scope
+	vars   map[string]*variable
+}
+
+type variable struct {
+	name string
+	line int
+	used bool
+	// quiet is set for the variables never reported: parameters and loop
+	// variables.
+	quiet bool
+}
+
+// ref is a use of a global: a read, a write, a field, a call or a method call.
+type ref struct {
+	kind  refKind
+	line  int
+	name  string
+	field string
+	// args is the number of arguments of a call, more being possible with
+	// multi, when the last argument is a call or '...'.
+	args  int
+	multi bool
+	// depth is the depth of the linter at the write of a global.
+	depth int
+}
+
+type refKind int
+
+const (
+	readRef refKind = iota
+	writeRef
+	fieldRef
+	callRef
+	methodRef
+)
+
+func (l *linter) report(line int, format string, args ...interface{}) {
+	l.issues = append(l.issues, Issue{File: l.file, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) open() {
+	l.scope = &scope{parent: l.scope, vars: map[string]*variable{}}
+}
+
+// close ends the current scope, reporting its unused variables.
+func (l *linter) close() {
+	vars := make([]*variable, 0, len(l.scope.vars))
+	for _, v := range l.scope.vars {
+		vars = append(vars, v)
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].line < vars[j].line })
+	for _, v := range vars {
+		if !v.used && !v.quiet && !strings.HasPrefix(v.name, "_") {
+			l.report(v.line, "unused local '%s'", v.name)
+		}
+	}
+	l.scope = l.scope.parent
+}
+
+// declare declares a local variable in the current scope. A variable declared
+// again in the same scope is a new variable, the previous one is checked.
+func (l *linter) declare(name string, line int, quiet bool) {
+	if v, ok := l.scope.vars[name]; ok && !v.used && !v.quiet && !strings.HasPrefix(name, "_") {
+		l.report(v.line, "unused local '%s'", name)
+	}
+	l.scope.vars[name] = &variable{name: name, line: line, quiet: quiet}
+}
+
+// lookup returns the local variable 'name', nil for a global.
+func (l *linter) lookup(name string) *variable {
+	for s := l.scope; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// global returns the name of the global of 'e', "" if it is not a global
+// variable.
+func (l *linter) global(e ast.Expr) string {
+	if id, ok := e.(*ast.IdentExpr); ok && l.lookup(id.Value) == nil {
+		return id.Value
+	}
+	return ""
+}
+
+// function walks a function, or the chunk when 'f' is nil.
+func (l *linter) function(f *ast.FunctionExpr, stmts []ast.Stmt, method bool) {
+	l.depth++
+	l.open()
+	if method {
+		l.declare("self", f.Line(), true)
+	}
+	if f != nil {
+		for _, name := range f.ParList.Names {
+			l.declare(name, f.Line(), true)
+		}
+	}
+	l.stmts(stmts)
+	l.close()
+	l.depth--
+}
+
+func (l *linter) block(stmts []ast.Stmt) {
+	l.open()
+	l.stmts(stmts)
+	l.close()
+}
+
+func (l *linter) stmts(stmts []ast.Stmt) {
+	for _, stmt := range stmts {
+		l.stmt(stmt)
+	}
+}
+
+func (l *linter) stmt(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case *ast.AssignStmt:
+		l.exprs(s.Rhs)
+		for _, lhs := range s.Lhs {
+			l.assign(lhs, s.Line())
+		}
+	case *ast.LocalAssignStmt:
+		if len(s.Names) == 1 && len(s.Exprs) == 1 {
+			if f, ok := s.Exprs[0].(*ast.FunctionExpr); ok {
+				// local function, visible in its body
+				l.declare(s.Names[0], s.Line(), false)
+				l.function(f, f.Stmts, false)
+				return
+			}
+		}
+		l.exprs(s.Exprs)
+		for _, name := range s.Names {
+			l.declare(name, s.Line(), false)
+		}
+	case *ast.FuncCallStmt:
+		l.expr(s.Expr)
+	case *ast.DoBlockStmt:
+		l.block(s.Stmts)
+	case *ast.WhileStmt:
+		l.expr(s.Condition)
+		l.block(s.Stmts)
+	case *ast.RepeatStmt:
+		// the condition sees the locals of the block
+		l.open()
+		l.stmts(s.Stmts)
+		l.expr(s.Condition)
+		l.close()
+	case *ast.IfStmt:
+		l.expr(s.Condition)
+		l.block(s.Then)
+		l.block(s.Else)
+	case *ast.NumberForStmt:
+		l.exprs([]ast.Expr{s.Init, s.Limit, s.Step})
+		l.open()
+		l.declare(s.Name, s.Line(), true)
+		l.stmts(s.Stmts)
+		l.close()
+	case *ast.GenericForStmt:
+		l.exprs(s.Exprs)
+		l.open()
+		for _, name := range s.Names {
+			l.declare(name, s.Line(), true)
+		}
+		l.stmts(s.Stmts)
+		l.close()
+	case *ast.FuncDefStmt:
+		if s.Name.Receiver != nil {
+			l.expr(s.Name.Receiver)
+			l.setField(s.Name.Receiver, s.Name.Method)
+			l.function(s.Func, s.Func.Stmts, true)
+			return
+		}
+		l.assign(s.Name.Func, s.Line())
+		l.function(s.Func, s.Func.Stmts, false)
+	case *ast.ReturnStmt:
+		l.exprs(s.Exprs)
+	}
+}
+
+// assign walks the target of an assignment.
+func (l *linter) assign(target ast.Expr, line int) {
+	switch t := target.(type) {
+	case *ast.IdentExpr:
+		if l.lookup(t.Value) != nil {
+			return
+		}
+		l.set[t.Value] = true
+		if l.depth == 1 {
+			l.declared[t.Value] = true
+		}
+		l.refs = append(l.refs, ref{kind: writeRef, line: line, name: t.Value, depth: l.depth})
+	case *ast.AttrGetExpr:
+		l.expr(t.Object)
+		l.expr(t.Key)
+		if key, ok := t.Key.(*ast.StringExpr); ok {
+			l.setField(t.Object, key.Value)
+		}
+	default:
+		l.expr(target)
+	}
+}
+
+// setField records the field 'name' set in the table 'object'.
+func (l *linter) setField(object ast.Expr, name string) {
+	l.names[name] = true
+	if g := l.global(object); g != "" {
+		l.fields[g+"."+name] = true
+	}
+}
+
+func (l *linter) exprs(exprs []ast.Expr) {
+	for _, e := range exprs {
+		if e != nil {
+			l.expr(e)
+		}
+	}
+}
+
+func (l *linter) expr(expr ast.Expr) {
+	switch e := expr.(type) {
+	case *ast.IdentExpr:
+		if v := l.lookup(e.Value); v != nil {
+			v.used = true
+			return
+		}
+		l.refs = append(l.refs, ref{kind: readRef, line: e.Line(), name: e.Value})
+	case *ast.AttrGetExpr:
+		l.expr(e.Object)
+		l.expr(e.Key)
+		if key, ok := e.Key.(*ast.StringExpr); ok {
+			if g := l.global(e.Object); g != "" {
+				l.refs = append(l.refs, ref{kind: fieldRef, line: e.Line(), name: g, field: key.Value})
+			}
+		}
+	case *ast.TableExpr:
+		for _, f := range e.Fields {
+			if key, ok := f.Key.(*ast.StringExpr); ok {
+				l.names[key.Value] = true
+			}
+			l.exprs([]ast.Expr{f.Key, f.Value})
+		}
+	case *ast.FuncCallExpr:
+		r := ref{line: e.Line(), args: len(e.Args)}
+		if n := len(e.Args); n > 0 {
+			switch e.Args[n-1].(type) {
+			case *ast.FuncCallExpr, *ast.Comma3Expr:
+				r.multi = true
+			}
+		}
+		if e.Receiver != nil {
+			l.expr(e.Receiver)
+			r.kind, r.name, r.field = methodRef, l.global(e.Receiver), e.Method
+			l.refs = append(l.refs, r)
+		} else {
+			l.expr(e.Func)
+			r.kind = callRef
+			switch f := e.Func.(type) {
+			case *ast.IdentExpr:
+				r.name = l.global(f)
+			case *ast.AttrGetExpr:
+				if key, ok := f.Key.(*ast.StringExpr); ok {
+					r.name, r.field = l.global(f.Object), key.Value
+				}
+			}
+			if r.name != "" {
+				l.refs = append(l.refs, r)
+			}
+		}
+		l.exprs(e.Args)
+	case *ast.LogicalOpExpr:
+		l.exprs([]ast.Expr{e.Lhs, e.Rhs})
+	case *ast.RelationalOpExpr:
+		l.exprs([]ast.Expr{e.Lhs, e.Rhs})
+	case *ast.StringConcatOpExpr:
+		l.exprs([]ast.Expr{e.Lhs, e.Rhs})
+	case *ast.ArithmeticOpExpr:
+		l.exprs([]ast.Expr{e.Lhs, e.Rhs})
+	case *ast.UnaryMinusOpExpr:
+		l.expr(e.Expr)
+	case *ast.UnaryNotOpExpr:
+		l.expr(e.Expr)
+	case *ast.UnaryLenOpExpr:
+		l.expr(e.Expr)
+	case *ast.FunctionExpr:
+		l.function(e, e.Stmts, false)
+	}
+}
+
+// check checks the uses of the globals.
+func (l *linter) check() {
+	for _, r := range l.refs {
+		switch r.kind {
+		case readRef:
+			if l.m.Globals[r.name] == nil && !l.set[r.name] {
+				l.report(r.line, "undefined global '%s'%s", r.name, suggest(r.name, l.globalNames()))
+			}
+		case writeRef:
+			if r.depth > 1 && l.m.Globals[r.name] == nil && !l.declared[r.name] {
+				l.report(r.line, "setting undeclared global '%s' in a function, missing local?", r.name)
+			}
+		case fieldRef:
+			l.field(r, "field")
+		case callRef:
+			if r.field == "" {
+				if sym := l.m.Globals[r.name]; sym != nil && !l.set[r.name] {
+					l.arity(r, r.name, sym.Func, 0)
+				}
+			} else if sym := l.member(r); sym != nil {
+				l.arity(r, r.name+"."+r.field, sym.Func, 0)
+			}
+		case methodRef:
+			if r.name != "" && l.m.Globals[r.name] != nil {
+				if sym := l.field(r, "method"); sym != nil {
+					l.arity(r, r.name+":"+r.field, sym.Func, 1)
+				}
+				continue
+			}
+			l.method(r)
+		}
+	}
+}
+
+// member returns the field of a global of the manifest used by 'r', nil if
+// it is unknown or set by the chunk.
+func (l *linter) member(r ref) *manifest.Symbol {
+	sym := l.m.Globals[r.name]
+	if sym == nil || sym.Fields == nil || l.set[r.name] || l.fields[r.name+"."+r.field] {
+		return nil
+	}
+	return sym.Fields[r.field]
+}
+
+// field checks the field of a global of the manifest used by 'r', and returns
+// it.
+func (l *linter) field(r ref, what string) *manifest.Symbol {
+	sym := l.m.Globals[r.name]
+	if sym == nil || sym.Fields == nil || l.set[r.name] || l.fields[r.name+"."+r.field] {
+		return nil
+	}
+	field, ok := sym.Fields[r.field]
+	if !ok {
+		names := make([]string, 0, len(sym.Fields))
+		for name := range sym.Fields {
+			names = append(names, name)
+		}
+		l.report(r.line, "unknown %s '%s' of '%s'%s", what, r.field, r.name, suggest(r.field, names))
+	}
+	return field
+}
+
+// method checks a method call on a value of an unknown type: the method must
+// be a method of a type of the manifest, a string method or a field set by the
+// chunk. The number of arguments is checked when all the methods of that name
+// take the same number.
+func (l *linter) method(r ref) {
+	if l.names[r.field] {
+		return
+	}
+	types := make([]string, 0, len(l.m.Types))
+	for name := range l.m.Types {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	var owners []string
+	var methods []*manifest.Symbol
+	for _, name := range types {
+		if m, ok := l.m.Types[name].Fields[r.field]; ok {
+			owners = append(owners, name)
+			methods = append(methods, m)
+		}
+	}
+	if s := l.m.Globals["string"]; s != nil && s.Fields[r.field] != nil {
+		owners = append(owners, "string")
+		methods = append(methods, s.Fields[r.field])
+	}
+	if len(methods) == 0 {
+		l.report(r.line, "unknown method '%s'%s", r.field, suggest(r.field, l.methodNames()))
+		return
+	}
+	params := methods[0].Func
+	for _, m := range methods[1:] {
+		if params == nil || m.Func == nil || m.Func.Min() != params.Min() || m.Func.Max() != params.Max() {
+			return
+		}
+	}
+	name := ":" + r.field
+	if len(owners) == 1 {
+		name = owners[0] + name
+	}
+	l.arity(r, name, params, 1)
+}
+
+// arity checks the number of arguments of the call 'r' of the function
+// 'name', 'self' being 1 for the method calls passing the object.
+func (l *linter) arity(r ref, name string, params *manifest.Func, self int) {
+	if params == nil {
+		return
+	}
+	// the last argument of a multi call can be no value
+	args := r.args + self
+	if r.multi {
+		args--
+	}
+	min, max := params.Min(), params.Max()
+	if !(max >= 0 && args > max) && !(args < min && !r.multi) {
+		return
+	}
+	var want string
+	switch {
+	case max < 0:
+		want = "at least " + plural(min-self)
+	case min == max:
+		want = plural(min - self)
+	default:
+		want = fmt.Sprintf("%d to %s", min-self, plural(max-self))
+	}
+	l.report(r.line, "'%s' takes %s, got %d", name, want, r.args)
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
+
+func (l *linter) globalNames() []string {
+	names := make([]string, 0, len(l.m.Globals)+len(l.set))
+	for name := range l.m.Globals {
+		names = append(names, name)
+	}
+	for name := range l.set {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (l *linter) methodNames() []string {
+	var names []string
+	for _, t := range l.m.Types {
+		for name := range t.Fields {
+			names = append(names, name)
+		}
+	}
+	if s := l.m.Globals["string"]; s != nil {
+		for name := range s.Fields {
+			names = append(names, name)
+		}
+	}
+	for name := range l.names {
+		names = append(names, name)
+	}
+	return names
+}
+
+// suggest returns ", did you mean 'x'?" for the name of 'names' closest to
+// 'name', "" if none is close enough to be a typo: 1 edit for the names of
+// up to 4 letters, 2 for the others.
+func suggest(name string, names []string) string {
+	sort.Strings(names)
+	best, dist := "", 3
+	if len(name) <= 4 {
+		dist = 2
+	}
+	for _, n := range names {
+		if d := distance(name, n); d > 0 && d < dist {
+			best, dist = n, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean '%s'?", best)
+}
+
+// distance returns the Levenshtein distance of 'a' and 'b'.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
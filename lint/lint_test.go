@@ -0,0 +1,91 @@
+package lint
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rickcrawford/go-lua-test/bindings"
+	"github.com/rickcrawford/go-lua-test/engine"
+	"github.com/rickcrawford/go-lua-test/manifest"
+)
+
+const script = `local unused = 1
+local acc = Account.create(1000)
+acc:withdraw(100)
+print(Account.balance(acc, 2))
+print(acc:balance(1))
+Account.create()
+json.prety({})
+function f()
+  counter = 1
+  local _skip = 2
+  for i, v in ipairs({}) do end
+  return undefined
+end
+print(("x"):upper(), ("x"):uper(), add(1, 2), add(1))
+local t = {}
+function t:go() end
+t:go()
+acc:withdrawl(unpack({1}))
+acc:withdrawl()
+`
+
+const want = `test.lua:1: unused local 'unused'
+test.lua:3: unknown method 'withdraw', did you mean 'withdrawl'?
+test.lua:4: 'Account.balance' takes 1 argument, got 2
+test.lua:5: 'Account:balance' takes 0 arguments, got 1
+test.lua:6: 'Account.create' takes 1 argument, got 0
+test.lua:7: unknown field 'prety' of 'json', did you mean 'pretty'?
+test.lua:9: setting undeclared global 'counter' in a function, missing local?
+test.lua:12: undefined global 'undefined'
+test.lua:14: unknown method 'uper', did you mean 'upper'?
+test.lua:14: 'add' takes 2 arguments, got 1
+test.lua:19: 'Account:withdrawl' takes 1 argument, got 0
+`
+
+func newManifest(t *testing.T) *manifest.Manifest {
+	m, err := manifest.New("gopher-lua", engine.Options{Modules: bindings.Modules()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.AddGo("", map[string]interface{}{"add": func(a, b int) int { return a + b }})
+	return m
+}
+
+func format(issues []Issue) string {
+	var b strings.Builder
+	for _, issue := range issues {
+		b.WriteString(issue.String() + "\n")
+	}
+	return b.String()
+}
+
+func TestCheck(t *testing.T) {
+	issues, err := Check(newManifest(t), "test.lua", strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := format(issues); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	issues, err := Check(newManifest(t), "test.lua", strings.NewReader("print(1)\nlocal x = = 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || issues[0].Line != 2 || !strings.HasPrefix(issues[0].Message, "syntax error") {
+		t.Errorf("got %v", issues)
+	}
+}
+
+func TestDemo(t *testing.T) {
+	issues, err := File(newManifest(t), "../luac/test.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) > 0 {
+		t.Errorf("got\n%s", format(issues))
+	}
+}
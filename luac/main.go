@@ -227,6 +227,29 @@ func runMemberTest(L *lua.State) {
 	}
 }
 
+// runHookTest counts the lines executed by account_test with a debug hook
+func runHookTest(L *lua.State) {
+	fmt.Printf("runHookTest, top stack: %d\n", L.GetTop())
+
+	lines := map[int]int{}
+	calls := 0
+	L.SetHook(lua.LUA_MASKCALL|lua.LUA_MASKLINE, 0, func(L *lua.State, ev lua.HookEvent) {
+		switch ev.Event {
+		case lua.LUA_HOOKCALL:
+			calls++
+		case lua.LUA_HOOKLINE:
+			lines[ev.CurrentLine]++
+		}
+	})
+	defer L.SetHook(0, 0, nil)
+
+	L.GetGlobal("account_test")
+	if err := L.Call(0, 0); err != nil {
+		log.Println(err)
+	}
+	log.Printf("account_test: %d calls, lines executed %v\n", calls, lines)
+}
+
 func runLuaC(filename string) {
 	log.Println("Running LUAC bindings")
 
@@ -250,6 +273,8 @@ func runLuaC(filename string) {
 
 	runMemberTest(L)
 
+	runHookTest(L)
+
 	fmt.Printf("top: %d\n", L.GetTop())
 
 }
@@ -0,0 +1,398 @@
+// Package manifest describes the API a Lua state offers to the scripts: its
+// globals, the fields of its tables, the parameters and results of its
+// functions and the methods of its userdata types. It is generated from a
+// running state and the Go registrations, the engine Modules and the luar maps,
+// for the linter and the editors:
+//
+//	m, err := manifest.New("gopher-lua", engine.Options{Modules: bindings.Modules()})
+//	m.AddGo("", luar.Map{"Print": fmt.Println})
+//	json.NewEncoder(os.Stdout).Encode(m) // read back by Read
+//	m.Stubs(w)                           // EmmyLua annotations
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+// Kinds of the symbols which are not Lua types.
+const (
+	// KindType is a userdata type, a global metatable whose fields are the
+	// methods of its values, like the Account type of luac/main.go.
+	KindType = "type"
+)
+
+// Manifest is the API of a state.
+type Manifest struct {
+	// Globals are the global variables.
+	Globals map[string]*Symbol `json:"globals"`
+	// Types are the userdata types, by name: the Types of the Modules and the
+	// Go types the functions of the luar maps take and return.
+	Types map[string]*Symbol `json:"types"`
+}
+
+// Symbol is a value of the API.
+type Symbol struct {
+	// Kind is the Lua type of the value, as returned by the type function, or
+	// KindType.
+	Kind string `json:"kind"`
+	// Type is the Lua type of the values of the luar maps in the syntax of
+	// the EmmyLua annotations, like "integer" or "string[]".
+	Type string `json:"type,omitempty"`
+	// GoType is the Go type of the values of the luar maps.
+	GoType string `json:"go_type,omitempty"`
+	// Fields are the fields of a table or the methods of a type, nil when they
+	// are not known.
+	Fields map[string]*Symbol `json:"fields"`
+	// Func is the signature of a function, nil when it is not known.
+	Func *Func `json:"func,omitempty"`
+}
+
+// Func is the signature of a function.
+type Func struct {
+	Params []Param `json:"params"`
+	// Optional is the number of optional parameters at the end of Params.
+	Optional int `json:"optional,omitempty"`
+	// Variadic is the type of the arguments the function takes after Params,
+	// nil when it takes no more.
+	Variadic *Param `json:"variadic,omitempty"`
+	// Results are the results, nil when they are not known.
+	Results []Param `json:"results"`
+}
+
+// Param is a parameter or a result of a function.
+type Param struct {
+	Name string `json:"name,omitempty"`
+	// Type is the Lua type in the syntax of the EmmyLua annotations, like
+	// "number", "string[]" or "Account", empty when it is not known.
+	Type string `json:"type,omitempty"`
+	// GoType is the Go type of the functions of the luar maps.
+	GoType string `json:"go_type,omitempty"`
+}
+
+// ParseFunc parses the Params and Results of a Module: the names of the
+// parameters, the optional ones ending with "?", and "..." last for a variable
+// number, each optionally followed by a space and its Lua type, then the Lua
+// types of the results, nil when they are not known.
+func ParseFunc(params, results []string) *Func {
+	f := &Func{Params: []Param{}}
+	for _, p := range params {
+		name, typ := p, ""
+		if i := strings.IndexByte(p, ' '); i >= 0 {
+			name, typ = p[:i], strings.TrimSpace(p[i+1:])
+		}
+		switch {
+		case name == "...":
+			f.Variadic = &Param{Type: typ}
+		case strings.HasSuffix(name, "?"):
+			f.Params = append(f.Params, Param{Name: strings.TrimSuffix(name, "?"), Type: typ})
+			f.Optional++
+		default:
+			f.Params = append(f.Params, Param{Name: name, Type: typ})
+			f.Optional = 0
+		}
+	}
+	if results != nil {
+		f.Results = []Param{}
+		for _, typ := range results {
+			f.Results = append(f.Results, Param{Type: typ})
+		}
+	}
+	return f
+}
+
+// Min returns the minimum number of arguments.
+func (f *Func) Min() int {
+	return len(f.Params) - f.Optional
+}
+
+// Max returns the maximum number of arguments, -1 when there is none.
+func (f *Func) Max() int {
+	if f.Variadic != nil {
+		return -1
+	}
+	return len(f.Params)
+}
+
+// apiLua lists the globals of a state and the fields of its tables, the
+// tables whose __index is themselves being types.
+const apiLua = `(function()
+	local api = {}
+	for name, v in pairs(_G) do
+		if type(name) == "string" then
+			local kind, fields = type(v), nil
+			if kind == "table" and v ~= _G then
+				fields = {}
+				for field, fv in pairs(v) do
+					if type(field) == "string" then
+						fields[field] = type(fv)
+					end
+				end
+				if rawget(v, "__index") == v then
+					kind = "type"
+				end
+			end
+			api[name] = {kind = kind, fields = fields}
+		end
+	end
+	return api
+end)()`
+
+// New creates a state of the engine 'name' with 'opts' and returns its API,
+// with the signatures of the Modules of 'opts'.
+func New(name string, opts engine.Options) (*Manifest, error) {
+	e, err := engine.New(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer e.Close()
+	values, err := e.Eval(apiLua)
+	if err != nil {
+		return nil, err
+	}
+	api, ok := values[0].(*engine.Table)
+	if !ok {
+		return nil, fmt.Errorf("manifest: the globals of %s are a %s", name, engine.TypeName(values[0]))
+	}
+	m := &Manifest{Globals: map[string]*Symbol{}, Types: map[string]*Symbol{}}
+	for _, name := range api.Keys() {
+		global, _ := api.Get(name).(*engine.Table)
+		if global == nil {
+			continue
+		}
+		kind, _ := global.Get("kind").(string)
+		sym := &Symbol{Kind: kind}
+		if fields, ok := global.Get("fields").(*engine.Table); ok {
+			sym.Fields = map[string]*Symbol{}
+			for _, field := range fields.Keys() {
+				kind, _ := fields.Get(field).(string)
+				sym.Fields[field] = &Symbol{Kind: kind}
+			}
+		}
+		m.Globals[name] = sym
+		if kind == KindType {
+			m.Types[name] = sym
+		}
+	}
+	for _, mod := range opts.Modules {
+		m.AddModule(mod)
+	}
+	return m, nil
+}
+
+// Read reads a manifest written as JSON.
+func Read(r io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, fmt.Errorf("manifest: %v", err)
+	}
+	if m.Globals == nil {
+		m.Globals = map[string]*Symbol{}
+	}
+	if m.Types == nil {
+		m.Types = map[string]*Symbol{}
+	}
+	// the types are globals too, share them like New does
+	for name, sym := range m.Globals {
+		if sym.Kind == KindType {
+			m.Types[name] = sym
+		}
+	}
+	return m, nil
+}
+
+// AddModule adds the functions and types of 'mod' to the API, with their
+// Params and Results. The functions of a Module are engine.Functions, whose Go
+// type says nothing of their arguments, so unlike AddGo it cannot find their
+// signatures by reflection: those without Params have none, and the Params
+// and Results of the functions 'mod' lacks are ignored.
+func (m *Manifest) AddModule(mod *engine.Module) {
+	funcs := m.Globals
+	if mod.Name != "" {
+		funcs = m.table(mod.Name).Fields
+	}
+	for name := range mod.Funcs {
+		funcs[name] = function(funcs[name], mod.Params[name], mod.Results[name])
+	}
+	for _, t := range mod.Types {
+		sym := m.Globals[t.Name]
+		if sym == nil || sym.Fields == nil {
+			sym = &Symbol{Fields: map[string]*Symbol{}}
+			m.Globals[t.Name] = sym
+		}
+		sym.Kind = KindType
+		m.Types[t.Name] = sym
+		for name := range t.Methods {
+			sym.Fields[name] = function(sym.Fields[name], t.Params[name], t.Results[name])
+		}
+	}
+}
+
+// table returns the global table 'name', creating it if needed.
+func (m *Manifest) table(name string) *Symbol {
+	sym := m.Globals[name]
+	if sym == nil || sym.Fields == nil {
+		sym = &Symbol{Kind: "table", Fields: map[string]*Symbol{}}
+		m.Globals[name] = sym
+	}
+	return sym
+}
+
+// function returns the function 'sym' with the signature of 'params' and
+// 'results', if any.
+func function(sym *Symbol, params, results []string) *Symbol {
+	if sym == nil {
+		sym = &Symbol{}
+	}
+	sym.Kind = "function"
+	if params != nil {
+		sym.Func = ParseFunc(params, results)
+	}
+	return sym
+}
+
+// AddGo adds the values of a luar map registered in the global table 'table',
+// or as globals when it is empty, like luar.Register does. The signatures of
+// the functions come from their Go types, and the methods and fields of the
+// named types they take and return are added to Types.
+func (m *Manifest) AddGo(table string, values map[string]interface{}) {
+	fields := m.Globals
+	if table != "" {
+		fields = m.table(table).Fields
+	}
+	for name, v := range values {
+		fields[name] = m.goSymbol(reflect.TypeOf(v))
+	}
+}
+
+// goSymbol returns the symbol of a Go value of type 't' converted by luar.
+func (m *Manifest) goSymbol(t reflect.Type) *Symbol {
+	if t == nil {
+		return &Symbol{Kind: "nil"}
+	}
+	sym := &Symbol{Kind: "userdata", GoType: t.String()}
+	switch t.Kind() {
+	case reflect.Func:
+		sym.Kind, sym.Func = "function", m.goFunc(t, 0)
+	case reflect.Bool:
+		sym.Kind = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		sym.Kind = "number"
+	case reflect.String:
+		sym.Kind = "string"
+	default:
+		m.addGoType(t)
+	}
+	if sym.Func == nil {
+		sym.Type = m.luaType(t)
+	}
+	return sym
+}
+
+// goFunc returns the signature of the Go function type 't' from parameter
+// 'from', nil for the functions of luar taking the state, which check their
+// arguments themselves.
+func (m *Manifest) goFunc(t reflect.Type, from int) *Func {
+	f := &Func{Params: []Param{}, Results: []Param{}}
+	for i := from; i < t.NumIn(); i++ {
+		in := t.In(i)
+		if in.String() == "*lua.State" {
+			return nil
+		}
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			f.Variadic = m.goParam("", in.Elem())
+			break
+		}
+		f.Params = append(f.Params, *m.goParam(fmt.Sprintf("arg%d", i-from+1), in))
+	}
+	for i := 0; i < t.NumOut(); i++ {
+		f.Results = append(f.Results, *m.goParam("", t.Out(i)))
+	}
+	return f
+}
+
+// goParam returns the parameter 'name' of Go type 't', adding 't' to Types.
+func (m *Manifest) goParam(name string, t reflect.Type) *Param {
+	m.addGoType(t)
+	return &Param{Name: name, Type: m.luaType(t), GoType: t.String()}
+}
+
+// luaType returns the Lua type of the Go type 't' in the syntax of the
+// EmmyLua annotations.
+func (m *Manifest) luaType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Func:
+		return "function"
+	case reflect.Ptr:
+		return m.luaType(t.Elem())
+	}
+	if _, ok := m.Types[t.Name()]; ok && t.PkgPath() != "" {
+		return t.Name()
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string"
+		}
+		return m.luaType(t.Elem()) + "[]"
+	case reflect.Map:
+		return fmt.Sprintf("table<%s, %s>", m.luaType(t.Key()), m.luaType(t.Elem()))
+	}
+	return "any"
+}
+
+// addGoType adds the methods and fields of the named Go type 't', or of the
+// type it points to, to Types, the methods of its pointer type included.
+func (m *Manifest) addGoType(t reflect.Type) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Name() == "" || t.PkgPath() == "" {
+		return
+	}
+	if _, ok := m.Types[t.Name()]; ok {
+		return
+	}
+	sym := &Symbol{Kind: KindType, GoType: t.String(), Fields: map[string]*Symbol{}}
+	m.Types[t.Name()] = sym
+	pt := reflect.PtrTo(t)
+	for i := 0; i < pt.NumMethod(); i++ {
+		method := pt.Method(i)
+		// the receiver is the object of the calls with ':'
+		f := m.goFunc(method.Type, 1)
+		if f != nil {
+			self := Param{Name: "self", Type: t.Name(), GoType: pt.String()}
+			f.Params = append([]Param{self}, f.Params...)
+		}
+		sym.Fields[method.Name] = &Symbol{Kind: "function", GoType: method.Type.String(), Func: f}
+	}
+	if t.Kind() == reflect.Struct {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := f.Name
+			if tag := f.Tag.Get("lua"); tag != "" {
+				name = tag
+			}
+			sym.Fields[name] = m.goSymbol(f.Type)
+		}
+	}
+}
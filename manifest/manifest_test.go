@@ -0,0 +1,167 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rickcrawford/go-lua-test/bindings"
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+type point struct{}
+
+type Shape struct {
+	Name   string `lua:"name"`
+	Points []*point
+}
+
+func (s *Shape) Scale(by float64, names ...string) (*Shape, error) { return s, nil }
+
+func newManifest(t *testing.T) *Manifest {
+	m, err := New("gopher-lua", engine.Options{Modules: bindings.Modules()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.AddGo("shapes", map[string]interface{}{
+		"new": func(name string, sides int) *Shape { return &Shape{Name: name} },
+	})
+	return m
+}
+
+func TestFunc(t *testing.T) {
+	f := ParseFunc([]string{"self", "init? number", "...  string"}, []string{"Account"})
+	want := &Func{
+		Params:   []Param{{Name: "self"}, {Name: "init", Type: "number"}},
+		Optional: 1,
+		Variadic: &Param{Type: "string"},
+		Results:  []Param{{Type: "Account"}},
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("got %+v, want %+v", f, want)
+	}
+	if f.Min() != 1 || f.Max() != -1 {
+		t.Errorf("got %d to %d arguments", f.Min(), f.Max())
+	}
+}
+
+// TestModuleSignatures checks that the hand-written Params and Results of the
+// bindings describe functions which exist, since they cannot be checked
+// against the Go types of engine.Functions.
+func TestModuleSignatures(t *testing.T) {
+	check := func(owner string, funcs map[string]engine.Function, params, results map[string][]string) {
+		for _, names := range []map[string][]string{params, results} {
+			for name := range names {
+				if funcs[name] == nil {
+					t.Errorf("%s: signature of the missing function %s", owner, name)
+				}
+			}
+		}
+		for name := range results {
+			if params[name] == nil {
+				t.Errorf("%s: %s has Results but no Params", owner, name)
+			}
+		}
+	}
+	for _, mod := range bindings.Modules() {
+		check("module "+mod.Name, mod.Funcs, mod.Params, mod.Results)
+		for _, typ := range mod.Types {
+			check("type "+typ.Name, typ.Methods, typ.Params, typ.Results)
+		}
+	}
+}
+
+func TestGo(t *testing.T) {
+	m := newManifest(t)
+	f := m.Globals["shapes"].Fields["new"].Func
+	want := &Func{
+		Params:  []Param{{Name: "arg1", Type: "string", GoType: "string"}, {Name: "arg2", Type: "integer", GoType: "int"}},
+		Results: []Param{{Type: "Shape", GoType: "*manifest.Shape"}},
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("got %+v, want %+v", f, want)
+	}
+	shape := m.Types["Shape"]
+	if shape == nil || shape.Fields["name"].Type != "string" || shape.Fields["Points"].Type != "point[]" || shape.Fields["Scale"].Func.Variadic.Type != "string" {
+		t.Errorf("got %+v", shape)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	m := newManifest(t)
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, m) {
+		t.Errorf("read\n%+v\nwant\n%+v", read, m)
+	}
+	if read.Globals["Account"] != read.Types["Account"] {
+		t.Error("the global Account is not the type")
+	}
+}
+
+const stubs = `---@meta
+
+---@class Account
+Account = {}
+
+---@return number
+function Account:balance() end
+
+---@param balance number
+---@return Account
+function Account.create(balance) end
+
+---@param amount number
+function Account:withdrawl(amount) end
+
+json = {}
+
+---@param value any
+---@return string
+function json.pretty(value) end
+
+person = {}
+
+---@param name string
+---@return {name: string}
+function person.new(name) end
+
+shapes = {}
+
+---@param arg1 string
+---@param arg2 integer
+---@return Shape
+function shapes.new(arg1, arg2) end
+
+---@class Shape
+---@field Points point[]
+---@field name string
+local Shape = {}
+
+---@param arg1 number
+---@param ... string
+---@return Shape
+---@return any
+function Shape:Scale(arg1, ...) end
+
+---@class point
+local point = {}
+`
+
+func TestStubs(t *testing.T) {
+	var b strings.Builder
+	if err := newManifest(t).Stubs(&b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != stubs {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), stubs)
+	}
+}
@@ -0,0 +1,155 @@
+package manifest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Stubs writes the API as a Lua file of EmmyLua annotations, the format of
+// the Lua language server, for the editors to complete and check the scripts.
+// Only the symbols with a signature or a Go type are written, those of the
+// Modules and the luar maps, the editors knowing the standard library:
+//
+//	---@class Account
+//	Account = {}
+//
+//	---@param amount number
+//	function Account:withdrawl(amount) end
+func (m *Manifest) Stubs(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "---@meta")
+	for _, name := range sortedKeys(m.Globals) {
+		sym := m.Globals[name]
+		switch {
+		case !registered(sym):
+		case sym.Kind == KindType:
+			m.writeType(b, name, "")
+		case sym.Kind == "function":
+			writeFunc(b, name, sym.Func)
+		case sym.Fields != nil:
+			fmt.Fprintf(b, "\n%s = {}\n", name)
+			for _, field := range sortedKeys(sym.Fields) {
+				if f := sym.Fields[field]; f.Kind == "function" {
+					writeFunc(b, index(name, field), f.Func)
+				} else if registered(f) {
+					fmt.Fprintf(b, "\n---@type %s\n%s = nil\n", valueType(f), index(name, field))
+				}
+			}
+		default:
+			fmt.Fprintf(b, "\n---@type %s\n%s = nil\n", valueType(sym), name)
+		}
+	}
+	for _, name := range sortedKeys(m.Types) {
+		if m.Globals[name] != m.Types[name] && m.Types[name].GoType != "" {
+			m.writeType(b, name, "local ")
+		}
+	}
+	return b.Flush()
+}
+
+// registered reports whether 'sym' comes from Go, the functions of the tables
+// of the standard library having no signature.
+func registered(sym *Symbol) bool {
+	if sym.Func != nil || sym.GoType != "" {
+		return true
+	}
+	for _, f := range sym.Fields {
+		if registered(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeType writes the class of the type 'name', its fields and its methods,
+// the metamethods being left out.
+func (m *Manifest) writeType(b *bufio.Writer, name, local string) {
+	sym := m.Types[name]
+	fmt.Fprintf(b, "\n---@class %s\n", name)
+	fields := sortedKeys(sym.Fields)
+	for _, field := range fields {
+		if f := sym.Fields[field]; f.Kind != "function" && !strings.HasPrefix(field, "__") {
+			fmt.Fprintf(b, "---@field %s %s\n", field, valueType(f))
+		}
+	}
+	fmt.Fprintf(b, "%s%s = {}\n", local, name)
+	for _, field := range fields {
+		if f := sym.Fields[field]; f.Kind == "function" && !strings.HasPrefix(field, "__") {
+			writeFunc(b, index(name, field), f.Func)
+		}
+	}
+}
+
+// writeFunc writes the function 'name' of signature 'f', taking any arguments
+// when 'f' is nil. The functions whose first parameter is self are methods.
+func writeFunc(b *bufio.Writer, name string, f *Func) {
+	if f == nil {
+		fmt.Fprintf(b, "\n---@param ... any\nfunction %s(...) end\n", name)
+		return
+	}
+	params, skip := f.Params, 0
+	if len(params) > 0 && params[0].Name == "self" && strings.Contains(name, ".") {
+		i := strings.LastIndexByte(name, '.')
+		name = name[:i] + ":" + name[i+1:]
+		params, skip = params[1:], 1
+	}
+	fmt.Fprintln(b)
+	names := make([]string, 0, len(params)+1)
+	for i, p := range params {
+		optional := ""
+		if i+skip >= f.Min() {
+			optional = "?"
+		}
+		fmt.Fprintf(b, "---@param %s%s %s\n", p.Name, optional, luaType(p.Type))
+		names = append(names, p.Name)
+	}
+	if f.Variadic != nil {
+		fmt.Fprintf(b, "---@param ... %s\n", luaType(f.Variadic.Type))
+		names = append(names, "...")
+	}
+	for _, r := range f.Results {
+		fmt.Fprintf(b, "---@return %s\n", luaType(r.Type))
+	}
+	fmt.Fprintf(b, "function %s(%s) end\n", name, strings.Join(names, ", "))
+}
+
+// valueType returns the type of the annotations of the value 'sym'.
+func valueType(sym *Symbol) string {
+	if sym.Type != "" {
+		return sym.Type
+	}
+	switch sym.Kind {
+	case "nil", "boolean", "number", "string", "table", "function", "userdata":
+		return sym.Kind
+	}
+	return "any"
+}
+
+func luaType(typ string) string {
+	if typ == "" {
+		return "any"
+	}
+	return typ
+}
+
+// index returns the expression of the field 'field' of the table 'table'.
+func index(table, field string) string {
+	for i, c := range field {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			return fmt.Sprintf("%s[%q]", table, field)
+		}
+	}
+	return table + "." + field
+}
+
+func sortedKeys(symbols map[string]*Symbol) []string {
+	keys := make([]string, 0, len(symbols))
+	for key := range symbols {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
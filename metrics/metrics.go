@@ -0,0 +1,271 @@
+// Package metrics lets Lua scripts emit counters, gauges and histograms into a
+// Registry shared by all the states of a pool, and exposes them in the
+// Prometheus text format:
+//
+//	reg := metrics.NewRegistry()
+//	e, _ := engine.New("gopher-lua", engine.Options{Modules: []*engine.Module{reg.Module()}})
+//	reg.AddState("worker-1", e)
+//	http.Handle("/metrics", reg.Handler())
+//
+// In Lua:
+//
+//	metrics.counter("withdrawals_total", {currency = "usd"}):inc()
+//	metrics.gauge("balance"):set(acc:balance())
+//	metrics.histogram("withdrawal_amount", nil, {10, 100, 1000}):observe(amount)
+//
+// The names of the metrics and labels are validated as Prometheus does, and a
+// metric keeps its kind and label names once created.
+package metrics
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+// Kind is the kind of a metric.
+type Kind int
+
+const (
+	// KindCounter is a value which only goes up.
+	KindCounter Kind = iota
+	// KindGauge is a value which goes up and down.
+	KindGauge
+	// KindHistogram counts observations in buckets.
+	KindHistogram
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindGauge:
+		return "gauge"
+	case KindHistogram:
+		return "histogram"
+	}
+	return "counter"
+}
+
+// DefaultBuckets are the upper bounds of the buckets of the histograms created
+// without buckets, those of the Prometheus client.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	metricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelName  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Registry holds metrics, it can be used concurrently.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+	states   map[string]engine.Engine
+}
+
+// family is a metric with all the values of its labels.
+type family struct {
+	name    string
+	help    string
+	kind    Kind
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+// series is a metric with values for its labels.
+type series struct {
+	values []string
+	// value of a counter or gauge.
+	value float64
+	// buckets of a histogram, the counts of the observations lower or equal
+	// to them, their sum and count.
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}, states: map[string]engine.Engine{}}
+}
+
+// Help sets the description of a metric.
+func (r *Registry) Help(name, help string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		f.help = help
+		return
+	}
+	r.families[name] = &family{name: name, help: help, kind: -1, series: map[string]*series{}}
+}
+
+// Counter returns the counter 'name' with the values of its labels, creating
+// it when needed.
+func (r *Registry) Counter(name string, labels map[string]string) (*Counter, error) {
+	s, err := r.series(name, KindCounter, labels, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Counter{r: r, s: s}, nil
+}
+
+// Gauge returns the gauge 'name' with the values of its labels, creating it
+// when needed.
+func (r *Registry) Gauge(name string, labels map[string]string) (*Gauge, error) {
+	s, err := r.series(name, KindGauge, labels, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Gauge{r: r, s: s}, nil
+}
+
+// Histogram returns the histogram 'name' with the values of its labels,
+// creating it with the upper bounds 'buckets', DefaultBuckets when nil.
+func (r *Registry) Histogram(name string, labels map[string]string, buckets []float64) (*Histogram, error) {
+	s, err := r.series(name, KindHistogram, labels, buckets)
+	if err != nil {
+		return nil, err
+	}
+	return &Histogram{r: r, s: s}, nil
+}
+
+// series returns the series of a metric, checking that it keeps its kind,
+// labels and buckets.
+func (r *Registry) series(name string, kind Kind, labels map[string]string, buckets []float64) (*series, error) {
+	if !metricName.MatchString(name) {
+		return nil, fmt.Errorf("invalid metric name %q", name)
+	}
+	names := make([]string, 0, len(labels))
+	for l, v := range labels {
+		if !labelName.MatchString(l) || strings.HasPrefix(l, "__") || kind == KindHistogram && l == "le" {
+			return nil, fmt.Errorf("metric %s: invalid label name %q", name, l)
+		}
+		if !utf8.ValidString(v) {
+			return nil, fmt.Errorf("metric %s: label %s: invalid UTF-8 value %q", name, l, v)
+		}
+		names = append(names, l)
+	}
+	sort.Strings(names)
+	if kind == KindHistogram {
+		for i := 1; i < len(buckets); i++ {
+			if buckets[i] <= buckets[i-1] {
+				return nil, fmt.Errorf("metric %s: buckets not in increasing order", name)
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.families[name]
+	if !ok || f.kind == -1 {
+		if !ok {
+			f = &family{name: name, series: map[string]*series{}}
+			r.families[name] = f
+		}
+		f.kind = kind
+		f.labels = names
+		if kind == KindHistogram {
+			f.buckets = buckets
+			if f.buckets == nil {
+				f.buckets = DefaultBuckets
+			}
+		}
+	}
+	if f.kind != kind {
+		return nil, fmt.Errorf("metric %s is a %s, not a %s", name, f.kind, kind)
+	}
+	if strings.Join(f.labels, ",") != strings.Join(names, ",") {
+		return nil, fmt.Errorf("metric %s has the labels %v, got %v", name, f.labels, names)
+	}
+	if buckets != nil && fmt.Sprint(buckets) != fmt.Sprint(f.buckets) {
+		return nil, fmt.Errorf("metric %s has the buckets %v, got %v", name, f.buckets, buckets)
+	}
+
+	values := make([]string, len(names))
+	for i, l := range names {
+		values[i] = labels[l]
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: values}
+		if kind == KindHistogram {
+			s.buckets = f.buckets
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s, nil
+}
+
+// Counter is a counter with values for its labels.
+type Counter struct {
+	r *Registry
+	s *series
+}
+
+// Inc adds 1 to the counter.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds 'v' to the counter, it panics when 'v' is negative.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter decreased")
+	}
+	c.r.mu.Lock()
+	c.s.value += v
+	c.r.mu.Unlock()
+}
+
+// Gauge is a gauge with values for its labels.
+type Gauge struct {
+	r *Registry
+	s *series
+}
+
+// Set sets the gauge.
+func (g *Gauge) Set(v float64) {
+	g.r.mu.Lock()
+	g.s.value = v
+	g.r.mu.Unlock()
+}
+
+// Add adds 'v' to the gauge.
+func (g *Gauge) Add(v float64) {
+	g.r.mu.Lock()
+	g.s.value += v
+	g.r.mu.Unlock()
+}
+
+// Inc adds 1 to the gauge.
+func (g *Gauge) Inc() { g.Add(1) }
+
+// Dec subtracts 1 from the gauge.
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// Histogram is a histogram with values for its labels.
+type Histogram struct {
+	r *Registry
+	s *series
+}
+
+// Observe adds an observation to the histogram.
+func (h *Histogram) Observe(v float64) {
+	h.r.mu.Lock()
+	defer h.r.mu.Unlock()
+	for i, b := range h.s.buckets {
+		if v <= b {
+			h.s.counts[i]++
+		}
+	}
+	h.s.sum += v
+	h.s.count++
+}
@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+const script = `
+metrics.counter("withdrawals_total", {currency = "usd"}):inc()
+metrics.counter("withdrawals_total", {currency = "usd"}).add(2)
+metrics.counter("withdrawals_total", {currency = "eur"}):inc()
+local g = metrics.gauge("balance")
+g:set(900)
+g:dec()
+local h = metrics.histogram("amount", nil, {10, 100})
+h:observe(5)
+h:observe(50)
+h:observe(500)
+`
+
+func scrape(t *testing.T, r *Registry) string {
+	srv := httptest.NewServer(r.Handler())
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Help("withdrawals_total", "Withdrawals made by the scripts.")
+	// the registry is shared by the states of a pool
+	n := float64(len(engine.Names()))
+	for _, name := range engine.Names() {
+		e, err := engine.New(name, engine.Options{Modules: []*engine.Module{r.Module()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer e.Close()
+		if err := e.DoString(script, "=test"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	want := fmt.Sprintf(`# TYPE amount histogram
+amount_bucket{le="10"} %g
+amount_bucket{le="100"} %g
+amount_bucket{le="+Inf"} %g
+amount_sum %g
+amount_count %g
+# TYPE balance gauge
+balance 899
+# HELP withdrawals_total Withdrawals made by the scripts.
+# TYPE withdrawals_total counter
+withdrawals_total{currency="eur"} %g
+withdrawals_total{currency="usd"} %g
+`, n, 2*n, 3*n, 555*n, 3*n, n, 3*n)
+	if got := scrape(t, r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	r := NewRegistry()
+	e, err := engine.New("gopher-lua", engine.Options{Modules: []*engine.Module{r.Module()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if err := e.DoString(`metrics.counter("requests_total", {path = "/"}):inc()`, "=test"); err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range []string{
+		`metrics.counter("bad-name")`,
+		`metrics.counter("x", {["bad-label"] = "v"})`,
+		`metrics.counter("x", {__reserved = "v"})`,
+		`metrics.histogram("h", {le = "1"})`,
+		`metrics.histogram("h", nil, {2, 1})`,
+		`metrics.gauge("requests_total", {path = "/"})`,
+		`metrics.counter("requests_total", {method = "GET"})`,
+		`metrics.counter("requests_total", {path = "/"}):add(-1)`,
+	} {
+		if err := e.DoString(code, "=test"); err == nil {
+			t.Errorf("%s: no error", code)
+		}
+	}
+}
+
+func TestStates(t *testing.T) {
+	for _, name := range engine.Names() {
+		r := NewRegistry()
+		e, err := engine.New(name, engine.Options{CountInstructions: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer e.Close()
+		r.AddState("worker-1", e)
+		e.DoString(`for i = 1, 10000 do end`, "=loop")
+		e.DoString(`error("boom")`, "=fail")
+
+		got := scrape(t, r)
+		labels := `{engine="` + name + `",state="worker-1"} `
+		for _, line := range []string{
+			"lua_state_calls_total" + labels + "2",
+			"lua_state_errors_total" + labels + "1",
+		} {
+			if !strings.Contains(got, line) {
+				t.Errorf("%s: missing %q in\n%s", name, line, got)
+			}
+		}
+		// the memory of the engines written in Go is that of the Go heap
+		if memory := strings.Contains(got, "lua_state_memory_bytes"+labels); memory != strings.HasPrefix(name, "golua") {
+			t.Errorf("%s: memory published %v in\n%s", name, memory, got)
+		}
+		// gopher-lua has no hooks, golua and go-lua count with different ones
+		if counted := !strings.Contains(got, "lua_state_instructions_total"+labels+"0\n"); counted != (name != "gopher-lua") {
+			t.Errorf("%s: instructions counted %v in\n%s", name, counted, got)
+		}
+
+		r.RemoveState("worker-1")
+		if got := scrape(t, r); got != "" {
+			t.Errorf("%s: got metrics after RemoveState:\n%s", name, got)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+// Module returns the metrics library writing to the registry, which can be
+// registered on any number of states:
+//
+//	metrics.counter(name [, labels])                 inc(), add(n)
+//	metrics.gauge(name [, labels])                   set(n), add(n), inc(), dec()
+//	metrics.histogram(name [, labels [, buckets]])   observe(n)
+//
+// The functions return a table of the methods of the metric, called with ':'
+// or '.', and raise the errors of invalid names or labels.
+func (r *Registry) Module() *engine.Module {
+	return &engine.Module{Name: "metrics", Funcs: map[string]engine.Function{
+		"counter": func(args []engine.Value) ([]engine.Value, error) {
+			name, labels, err := metricArgs(args)
+			if err != nil {
+				return nil, err
+			}
+			c, err := r.Counter(name, labels)
+			if err != nil {
+				return nil, err
+			}
+			return methods(map[string]engine.Function{
+				"inc": func(args []engine.Value) ([]engine.Value, error) {
+					c.Inc()
+					return nil, nil
+				},
+				"add": func(args []engine.Value) ([]engine.Value, error) {
+					v, err := engine.CheckNumber(self(args), 0)
+					if err != nil {
+						return nil, err
+					}
+					if v < 0 {
+						return nil, engine.ArgError(0, "counters cannot decrease")
+					}
+					c.Add(v)
+					return nil, nil
+				},
+			}), nil
+		},
+		"gauge": func(args []engine.Value) ([]engine.Value, error) {
+			name, labels, err := metricArgs(args)
+			if err != nil {
+				return nil, err
+			}
+			g, err := r.Gauge(name, labels)
+			if err != nil {
+				return nil, err
+			}
+			return methods(map[string]engine.Function{
+				"set": numberMethod(g.Set),
+				"add": numberMethod(g.Add),
+				"inc": func(args []engine.Value) ([]engine.Value, error) {
+					g.Inc()
+					return nil, nil
+				},
+				"dec": func(args []engine.Value) ([]engine.Value, error) {
+					g.Dec()
+					return nil, nil
+				},
+			}), nil
+		},
+		"histogram": func(args []engine.Value) ([]engine.Value, error) {
+			name, labels, err := metricArgs(args)
+			if err != nil {
+				return nil, err
+			}
+			var buckets []float64
+			switch t := arg(args, 2).(type) {
+			case nil:
+			case *engine.Table:
+				for i, v := range t.Array {
+					b, err := engine.CheckNumber(t.Array, i)
+					if err != nil {
+						return nil, engine.ArgError(2, "bucket "+engine.Format(v)+" is not a number")
+					}
+					buckets = append(buckets, b)
+				}
+			default:
+				return nil, engine.ArgError(2, "table expected, got "+engine.TypeName(t))
+			}
+			h, err := r.Histogram(name, labels, buckets)
+			if err != nil {
+				return nil, err
+			}
+			return methods(map[string]engine.Function{"observe": numberMethod(h.Observe)}), nil
+		},
+	}}
+}
+
+// metricArgs returns the name and the labels of a metric from the arguments
+// of the functions of the library.
+func metricArgs(args []engine.Value) (string, map[string]string, error) {
+	name, err := engine.CheckString(args, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	labels := map[string]string{}
+	switch t := arg(args, 1).(type) {
+	case nil:
+	case *engine.Table:
+		for _, k := range t.Keys() {
+			v, err := engine.CheckString([]engine.Value{t.Get(k)}, 0)
+			if err != nil {
+				return "", nil, engine.ArgError(1, "label "+k+" is not a string")
+			}
+			labels[k] = v
+		}
+	default:
+		return "", nil, engine.ArgError(1, "table expected, got "+engine.TypeName(t))
+	}
+	return name, labels, nil
+}
+
+// methods returns the table of the methods of a metric.
+func methods(funcs map[string]engine.Function) []engine.Value {
+	t := engine.NewTable()
+	for name, f := range funcs {
+		t.Set(name, f)
+	}
+	return []engine.Value{t}
+}
+
+// numberMethod returns a method calling 'f' with its number argument.
+func numberMethod(f func(float64)) engine.Function {
+	return func(args []engine.Value) ([]engine.Value, error) {
+		v, err := engine.CheckNumber(self(args), 0)
+		if err != nil {
+			return nil, err
+		}
+		f(v)
+		return nil, nil
+	}
+}
+
+// self removes the table of a method called with ':' from its arguments.
+func self(args []engine.Value) []engine.Value {
+	if _, ok := arg(args, 0).(*engine.Table); ok {
+		return args[1:]
+	}
+	return args
+}
+
+func arg(args []engine.Value, i int) engine.Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+// AddState publishes the Stats of a state as metrics labeled with its 'id' and
+// engine name:
+//
+//	lua_state_calls_total         calls into the state
+//	lua_state_errors_total        failed calls
+//	lua_state_instructions_total  instructions run, with Options.CountInstructions
+//	lua_state_memory_bytes        memory used after the last call, on golua
+//
+// The memory is only published for the golua states, whose C allocations are
+// their own: go-lua and gopher-lua measure the growth of the Go heap, shared
+// by all the states and the rest of the program.
+func (r *Registry) AddState(id string, e engine.Engine) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states[id] = e
+}
+
+// RemoveState stops publishing the metrics of the state 'id', once closed.
+func (r *Registry) RemoveState(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.states, id)
+}
+
+// stateFamilies returns the metrics of the states.
+func (r *Registry) stateFamilies() []*family {
+	calls := &family{name: "lua_state_calls_total", help: "Calls into the Lua state.", kind: KindCounter}
+	errors := &family{name: "lua_state_errors_total", help: "Calls into the Lua state which failed.", kind: KindCounter}
+	instructions := &family{name: "lua_state_instructions_total", help: "Lua instructions run by the state.", kind: KindCounter}
+	memory := &family{name: "lua_state_memory_bytes", help: "Memory used by the Lua state after its last call, golua states only.", kind: KindGauge}
+	families := []*family{calls, errors, instructions, memory}
+	for _, f := range families {
+		f.labels = []string{"engine", "state"}
+		f.series = map[string]*series{}
+	}
+	for id, e := range r.states {
+		stats := e.Stats()
+		values := []string{e.Name(), id}
+		calls.series[id] = &series{values: values, value: float64(stats.Calls)}
+		errors.series[id] = &series{values: values, value: float64(stats.Errors)}
+		instructions.series[id] = &series{values: values, value: float64(stats.Instructions)}
+		if ownMemory(e) {
+			memory.series[id] = &series{values: values, value: float64(stats.Memory)}
+		}
+	}
+	return families
+}
+
+// ownMemory reports whether the Memory of the Stats of 'e' is the memory of
+// the state alone.
+func ownMemory(e engine.Engine) bool {
+	return e.Name() == "golua" || e.Name() == "golua-luar"
+}
+
+// WriteText writes the metrics in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		if f.kind != -1 && len(f.series) > 0 {
+			families = append(families, f)
+		}
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+	if len(r.states) > 0 {
+		for _, f := range r.stateFamilies() {
+			// no memory without golua states
+			if len(f.series) > 0 {
+				families = append(families, f)
+			}
+		}
+	}
+
+	b := bufio.NewWriter(w)
+	for _, f := range families {
+		if f.help != "" {
+			b.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+		}
+		b.WriteString("# TYPE " + f.name + " " + f.kind.String() + "\n")
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := f.series[k]
+			if f.kind != KindHistogram {
+				writeSample(b, f.name, f.labels, s.values, "", s.value)
+				continue
+			}
+			for i, bound := range s.buckets {
+				writeSample(b, f.name+"_bucket", f.labels, s.values, formatFloat(bound), float64(s.counts[i]))
+			}
+			writeSample(b, f.name+"_bucket", f.labels, s.values, "+Inf", float64(s.count))
+			writeSample(b, f.name+"_sum", f.labels, s.values, "", s.sum)
+			writeSample(b, f.name+"_count", f.labels, s.values, "", float64(s.count))
+		}
+	}
+	return b.Flush()
+}
+
+// writeSample writes a line of the text format, with the label "le" when 'le'
+// is not empty.
+func writeSample(b *bufio.Writer, name string, labels, values []string, le string, v float64) {
+	b.WriteString(name)
+	if len(labels) > 0 || le != "" {
+		b.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(l + `="` + escapeLabel(values[i]) + `"`)
+		}
+		if le != "" {
+			if len(labels) > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(`le="` + le + `"`)
+		}
+		b.WriteByte('}')
+	}
+	b.WriteString(" " + formatFloat(v) + "\n")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// Handler returns the HTTP handler serving the metrics in the Prometheus text
+// format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
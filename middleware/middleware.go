@@ -0,0 +1,187 @@
+// Package middleware runs a Lua function on each request of a net/http
+// server, before the handler it wraps:
+//
+//	function handle(req, res)
+//		if req:header("X-Token") == nil then
+//			res:status(401)
+//			res:write("missing token\n")
+//			return
+//		end
+//		req:set_header("X-User", "alice")
+//		res:set_header("X-Served-By", "lua")
+//	end
+//
+//	m, err := middleware.New(middleware.Options{Engine: "gopher-lua"}, "auth.lua")
+//	defer m.Close()
+//	http.ListenAndServe(":8080", m.Handler(mux))
+//
+// The function short-circuits the handler by setting the status or writing
+// the body of the response, otherwise the request goes on to the handler with
+// the headers the function set on the request and the response. It runs on a
+// state of a pool, stopping with the context of the request.
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+// Options configures a Middleware.
+type Options struct {
+	// Engine is the Lua implementation, "golua-luar" when empty.
+	Engine string
+	// Options of the states, with the Modules the scripts use.
+	engine.Options
+	// PoolSize is the number of idle states kept for the requests, 1 when 0.
+	PoolSize int
+	// Function is the global function called for each request, "handle" when
+	// empty.
+	Function string
+	// Timeout limits the time the function runs, on top of the deadline of
+	// the request context, no limit when 0.
+	Timeout time.Duration
+	// MaxBodySize is the number of bytes of the request body the function
+	// can read, past which req:read fails, DefaultMaxBodySize when 0. It
+	// does not limit what the handler reads.
+	MaxBodySize int64
+	// ErrorHandler writes the response when the function fails, after the
+	// error is logged to the Logger of the Options, without the headers the
+	// function set on the response. By default it
+	// answers 503 Service Unavailable for the LimitErrors and 500 Internal
+	// Server Error for the others.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// DefaultMaxBodySize is the MaxBodySize of the Options which set none.
+const DefaultMaxBodySize = 10 << 20
+
+// Middleware runs a Lua function before the handlers. It is safe for
+// concurrent use.
+type Middleware struct {
+	opts Options
+	pool *engine.Pool
+}
+
+// New loads the Lua files 'paths' in a pool of states, which must define the
+// function of the options.
+func New(opts Options, paths ...string) (*Middleware, error) {
+	if opts.Engine == "" {
+		opts.Engine = "golua-luar"
+	}
+	if opts.Function == "" {
+		opts.Function = "handle"
+	}
+	if opts.ErrorHandler == nil {
+		opts.ErrorHandler = defaultErrorHandler
+	}
+	if opts.MaxBodySize == 0 {
+		opts.MaxBodySize = DefaultMaxBodySize
+	}
+	opts.Modules = append(opts.Modules[:len(opts.Modules):len(opts.Modules)], Types)
+	pool, err := engine.NewPool(opts.Engine, opts.Options, opts.PoolSize, func(e engine.Engine) error {
+		for _, path := range paths {
+			if err := e.DoFile(path); err != nil {
+				return err
+			}
+		}
+		f, err := e.Global(opts.Function)
+		if err != nil {
+			return err
+		}
+		if _, ok := f.(*engine.Func); !ok {
+			return fmt.Errorf("middleware: %s is a %s, not a Lua function", opts.Function, engine.TypeName(f))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Middleware{opts: opts, pool: pool}, nil
+}
+
+// Handler returns a handler running the function, then 'next' unless the
+// function short-circuited it.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &request{r: r, max: m.opts.MaxBodySize}
+		if r.Body != nil {
+			req.body = http.MaxBytesReader(w, r.Body, m.opts.MaxBodySize)
+		}
+		res := &response{header: w.Header().Clone(), status: http.StatusOK}
+		if err := m.run(r.Context(), req, res); err != nil {
+			logger := m.opts.Logger
+			if logger == nil {
+				logger = slog.Default()
+			}
+			logger.Error("lua middleware failed", "method", r.Method, "path", r.URL.Path, "err", err)
+			m.opts.ErrorHandler(w, r, err)
+			return
+		}
+		copyHeader(w.Header(), res.header)
+		if res.done {
+			w.WriteHeader(res.status)
+			w.Write(res.body.Bytes())
+			return
+		}
+		if req.read.Len() > 0 {
+			// give the handler the whole body, what the function read first
+			r.Body = readCloser{io.MultiReader(&req.read, r.Body), r.Body}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// run calls the function with 'req' and 'res' on a state of the pool.
+func (m *Middleware) run(ctx context.Context, req *request, res *response) error {
+	if m.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.opts.Timeout)
+		defer cancel()
+	}
+	e, err := m.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer m.pool.Put(e)
+	e.SetContext(ctx)
+	_, err = e.Call(m.opts.Function, RequestType.New(req), ResponseType.New(res))
+	return err
+}
+
+// Close closes the states of the middleware.
+func (m *Middleware) Close() {
+	m.pool.Close()
+}
+
+func defaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	var lerr *engine.Error
+	if errors.As(err, &lerr) && lerr.Kind == engine.LimitError {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// copyHeader replaces the headers of 'dst' by those of 'src'.
+func copyHeader(dst, src http.Header) {
+	for name := range dst {
+		if _, ok := src[name]; !ok {
+			delete(dst, name)
+		}
+	}
+	for name, values := range src {
+		dst[name] = values
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+const handleLua = `
+function handle(req, res)
+	local path = req:path()
+	if path == "/private" and req:header("X-Token") ~= "secret" then
+		res:status(401)
+		res:set_header("Content-Type", "text/plain")
+		res:write("no token for ", req:method(), " ", path, "\n")
+		return
+	end
+	if path == "/all" then
+		res:write(req:read(0), req:read() or "<nil>", req:read() or "<nil>")
+		return
+	end
+	if path == "/echo" then
+		local first = req:read(5)
+		res:set_header("X-First", first)
+		res:set_header("X-Rest", req:read(1e15))
+	end
+	if path == "/loop" then
+		while true do end
+	end
+	if path == "/error" then
+		res:set_header("X-Served-By", "lua")
+		error("broken")
+	end
+	req:set_header("X-User", req:query("user") or "anonymous")
+	req:del_header("X-Token")
+	res:set_header("X-Served-By", "lua")
+	res:add_header("X-Headers", req:headers()["Accept"][1])
+end
+`
+
+// echo writes the headers and the body of the request.
+var echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Write([]byte("user=" + r.Header.Get("X-User") + " token=" + r.Header.Get("X-Token") + " body=" + string(body)))
+})
+
+func newMiddleware(t *testing.T, opts Options) *Middleware {
+	path := filepath.Join(t.TempDir(), "handle.lua")
+	if err := os.WriteFile(path, []byte(handleLua), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = time.Second
+	}
+	m, err := New(opts, path)
+	if err != nil {
+		t.Fatalf("%s: %v", opts.Engine, err)
+	}
+	return m
+}
+
+func serve(h http.Handler, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Accept", "text/plain")
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	for _, name := range engine.Names() {
+		m := newMiddleware(t, Options{Engine: name})
+		defer m.Close()
+		h := m.Handler(echo)
+
+		w := serve(h, "GET", "/private", "", nil)
+		if w.Code != 401 || w.Body.String() != "no token for GET /private\n" || w.Header().Get("Content-Type") != "text/plain" {
+			t.Errorf("%s: short-circuit got %d %q %v", name, w.Code, w.Body, w.Header())
+		}
+
+		w = serve(h, "GET", "/private?user=alice", "", http.Header{"X-Token": {"secret"}})
+		if w.Code != 200 || w.Body.String() != "user=alice token= body=" || w.Header().Get("X-Served-By") != "lua" || w.Header().Get("X-Headers") != "text/plain" {
+			t.Errorf("%s: pass-through got %d %q %v", name, w.Code, w.Body, w.Header())
+		}
+
+		w = serve(h, "POST", "/echo", "hello world", nil)
+		if w.Body.String() != "user=anonymous token= body=hello world" || w.Header().Get("X-First") != "hello" || w.Header().Get("X-Rest") != " world" {
+			t.Errorf("%s: body got %q %v", name, w.Body, w.Header())
+		}
+
+		if w = serve(h, "GET", "/error", "", nil); w.Code != 500 || w.Header().Get("X-Served-By") != "" {
+			t.Errorf("%s: error got %d %v", name, w.Code, w.Header())
+		}
+
+		// the headers set before the middleware are kept, failure or not
+		outer := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Request-Id", "1")
+			w.Header().Set("X-Headers", "outer")
+			h.ServeHTTP(w, r)
+		})
+		w = serve(outer, "GET", "/", "", nil)
+		if got := w.Header(); got.Get("X-Request-Id") != "1" || !reflect.DeepEqual(got["X-Headers"], []string{"outer", "text/plain"}) {
+			t.Errorf("%s: outer headers got %v", name, got)
+		}
+		if w = serve(outer, "GET", "/error", "", nil); w.Code != 500 || w.Header().Get("X-Request-Id") != "1" || w.Header().Get("X-Served-By") != "" {
+			t.Errorf("%s: outer error got %d %v", name, w.Code, w.Header())
+		}
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	for _, name := range engine.Names() {
+		m := newMiddleware(t, Options{Engine: name})
+		defer m.Close()
+		// the second loop runs on the state the first one stopped
+		for round := 1; round <= 2; round++ {
+			r := httptest.NewRequest("GET", "/loop", nil)
+			ctx, cancel := context.WithTimeout(r.Context(), 50*time.Millisecond)
+			w := httptest.NewRecorder()
+			start := time.Now()
+			m.Handler(echo).ServeHTTP(w, r.WithContext(ctx))
+			cancel()
+			if w.Code != http.StatusServiceUnavailable || time.Since(start) > 500*time.Millisecond {
+				t.Errorf("%s: loop %d got %d after %v", name, round, w.Code, time.Since(start))
+			}
+		}
+		if w := serve(m.Handler(echo), "GET", "/", "", nil); w.Code != 200 {
+			t.Errorf("%s: got %d after the deadline", name, w.Code)
+		}
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	for _, name := range engine.Names() {
+		m := newMiddleware(t, Options{Engine: name, MaxBodySize: 8})
+		defer m.Close()
+		h := m.Handler(echo)
+		if w := serve(h, "POST", "/all", "12345678", nil); w.Code != 200 || w.Body.String() != "12345678<nil>" {
+			t.Errorf("%s: got %d %q", name, w.Code, w.Body)
+		}
+		if w := serve(h, "POST", "/all", "123456789", nil); w.Code != 500 {
+			t.Errorf("%s: body past the limit got %d %q", name, w.Code, w.Body)
+		}
+		// the limit is on what the function reads, not on the handler
+		if w := serve(h, "POST", "/", "0123456789abc", nil); w.Body.String() != "user=anonymous token= body=0123456789abc" {
+			t.Errorf("%s: handler got %d %q", name, w.Code, w.Body)
+		}
+	}
+}
+
+func TestMissingFunction(t *testing.T) {
+	if _, err := New(Options{Engine: "gopher-lua", Function: "missing"}); err == nil || !strings.Contains(err.Error(), "missing is a nil") {
+		t.Errorf("got %v", err)
+	}
+}
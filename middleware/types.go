@@ -0,0 +1,339 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+// RequestType and ResponseType are the types of the arguments of the
+// function. Requests have the methods method(), path(), header(name),
+// headers(), set_header(name, value), add_header(name, value),
+// del_header(name), query([name]) and read([n]), the last reading the body, n
+// bytes or all of it up to Options.MaxBodySize, and returning nil at its end. Responses have
+// status([code]), header(name), headers(), set_header(name, value), add_header(name, value),
+// del_header(name) and write(...).
+//
+// headers() and query() return the http.Header and url.Values maps, as luar
+// proxies changing them on golua-luar and as copies on the other engines.
+var (
+	RequestType  = &engine.Type{Name: "Request"}
+	ResponseType = &engine.Type{Name: "Response"}
+)
+
+// Types is the module of RequestType and ResponseType, which New registers on
+// the states, for the linter and the API manifest.
+var Types = &engine.Module{Types: []*engine.Type{RequestType, ResponseType}}
+
+// request is the Go value of a Request.
+type request struct {
+	r *http.Request
+	// body reads r.Body up to the MaxBodySize of the options.
+	body io.Reader
+	// max is the MaxBodySize of the options.
+	max int64
+	// read is what the function read of the body.
+	read bytes.Buffer
+}
+
+// response is the Go value of a Response, sent instead of calling the handler
+// when done.
+type response struct {
+	// header starts as a copy of the headers of the ResponseWriter, which it
+	// replaces unless the function fails.
+	header http.Header
+	status int
+	body   bytes.Buffer
+	done   bool
+}
+
+func init() {
+	RequestType.Methods = map[string]engine.Function{
+		"method": func(args []engine.Value) ([]engine.Value, error) {
+			req, err := checkRequest(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return []engine.Value{req.r.Method}, nil
+		},
+		"path": func(args []engine.Value) ([]engine.Value, error) {
+			req, err := checkRequest(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return []engine.Value{req.r.URL.Path}, nil
+		},
+		"header": func(args []engine.Value) ([]engine.Value, error) {
+			req, err := checkRequest(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return getHeader(req.r.Header, args)
+		},
+		"headers": func(args []engine.Value) ([]engine.Value, error) {
+			req, err := checkRequest(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return []engine.Value{req.r.Header}, nil
+		},
+		"set_header": func(args []engine.Value) ([]engine.Value, error) {
+			req, err := checkRequest(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return setHeader(req.r.Header, args, http.Header.Set)
+		},
+		"add_header": func(args []engine.Value) ([]engine.Value, error) {
+			req, err := checkRequest(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return setHeader(req.r.Header, args, http.Header.Add)
+		},
+		"del_header": func(args []engine.Value) ([]engine.Value, error) {
+			req, err := checkRequest(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return delHeader(req.r.Header, args)
+		},
+		"query": func(args []engine.Value) ([]engine.Value, error) {
+			req, err := checkRequest(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			query := req.r.URL.Query()
+			if len(args) < 2 {
+				return []engine.Value{query}, nil
+			}
+			name, err := engine.CheckString(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			if values, ok := query[name]; ok && len(values) > 0 {
+				return []engine.Value{values[0]}, nil
+			}
+			return []engine.Value{nil}, nil
+		},
+		"read": func(args []engine.Value) ([]engine.Value, error) {
+			req, err := checkRequest(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return req.readBody(args)
+		},
+	}
+	RequestType.Params = map[string][]string{
+		"method":     {"self"},
+		"path":       {"self"},
+		"header":     {"self", "name string"},
+		"headers":    {"self"},
+		"set_header": {"self", "name string", "value string"},
+		"add_header": {"self", "name string", "value string"},
+		"del_header": {"self", "name string"},
+		"query":      {"self", "name? string"},
+		"read":       {"self", "n? integer"},
+	}
+	RequestType.Results = map[string][]string{
+		"method":     {"string"},
+		"path":       {"string"},
+		"header":     {"string?"},
+		"headers":    {"table<string, string[]>"},
+		"set_header": {},
+		"add_header": {},
+		"del_header": {},
+		"query":      {"string|table<string, string[]>|nil"},
+		"read":       {"string?"},
+	}
+
+	ResponseType.Methods = map[string]engine.Function{
+		"status": func(args []engine.Value) ([]engine.Value, error) {
+			res, err := checkResponse(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			if len(args) < 2 {
+				return []engine.Value{float64(res.status)}, nil
+			}
+			code, err := engine.CheckNumber(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			if code < 100 || code > 999 || code != float64(int(code)) {
+				return nil, engine.ArgError(1, "invalid status code")
+			}
+			res.status, res.done = int(code), true
+			return nil, nil
+		},
+		"header": func(args []engine.Value) ([]engine.Value, error) {
+			res, err := checkResponse(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return getHeader(res.header, args)
+		},
+		"headers": func(args []engine.Value) ([]engine.Value, error) {
+			res, err := checkResponse(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return []engine.Value{res.header}, nil
+		},
+		"set_header": func(args []engine.Value) ([]engine.Value, error) {
+			res, err := checkResponse(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return setHeader(res.header, args, http.Header.Set)
+		},
+		"add_header": func(args []engine.Value) ([]engine.Value, error) {
+			res, err := checkResponse(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return setHeader(res.header, args, http.Header.Add)
+		},
+		"del_header": func(args []engine.Value) ([]engine.Value, error) {
+			res, err := checkResponse(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return delHeader(res.header, args)
+		},
+		"write": func(args []engine.Value) ([]engine.Value, error) {
+			res, err := checkResponse(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			for i := 1; i < len(args); i++ {
+				s, err := engine.CheckString(args, i)
+				if err != nil {
+					return nil, err
+				}
+				res.body.WriteString(s)
+			}
+			res.done = true
+			return nil, nil
+		},
+	}
+	ResponseType.Params = map[string][]string{
+		"status":     {"self", "code? integer"},
+		"header":     {"self", "name string"},
+		"headers":    {"self"},
+		"set_header": {"self", "name string", "value string"},
+		"add_header": {"self", "name string", "value string"},
+		"del_header": {"self", "name string"},
+		"write":      {"self", "... string"},
+	}
+	ResponseType.Results = map[string][]string{
+		"status":     {"integer?"},
+		"header":     {"string?"},
+		"headers":    {"table<string, string[]>"},
+		"set_header": {},
+		"add_header": {},
+		"del_header": {},
+		"write":      {},
+	}
+}
+
+func checkRequest(args []engine.Value, i int) (*request, error) {
+	v, err := RequestType.Check(args, i)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*request), nil
+}
+
+func checkResponse(args []engine.Value, i int) (*response, error) {
+	v, err := ResponseType.Check(args, i)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*response), nil
+}
+
+// getHeader returns the first value of the header named by argument 1, nil
+// when there is none.
+func getHeader(h http.Header, args []engine.Value) ([]engine.Value, error) {
+	name, err := engine.CheckString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if values := h.Values(name); len(values) > 0 {
+		return []engine.Value{values[0]}, nil
+	}
+	return []engine.Value{nil}, nil
+}
+
+// setHeader calls 'set', http.Header.Set or Add, with the name and value of
+// arguments 1 and 2.
+func setHeader(h http.Header, args []engine.Value, set func(http.Header, string, string)) ([]engine.Value, error) {
+	name, err := engine.CheckString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	value, err := engine.CheckString(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	set(h, name, value)
+	return nil, nil
+}
+
+func delHeader(h http.Header, args []engine.Value) ([]engine.Value, error) {
+	name, err := engine.CheckString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	h.Del(name)
+	return nil, nil
+}
+
+// readBody reads the body, the number of bytes of argument 1 or all of it,
+// keeping them for the handler. It returns nil at the end of the body, and ""
+// for 0 bytes, and fails past MaxBodySize.
+func (req *request) readBody(args []engine.Value) ([]engine.Value, error) {
+	if req.body == nil {
+		return []engine.Value{nil}, nil
+	}
+	var data []byte
+	var err error
+	if len(args) > 1 {
+		n, cerr := engine.CheckNumber(args, 1)
+		if cerr != nil {
+			return nil, cerr
+		}
+		if n < 0 {
+			return nil, engine.ArgError(1, "negative size")
+		}
+		if n < 1 {
+			return []engine.Value{""}, nil
+		}
+		// read what there is rather than allocating n bytes up front
+		limit := int64(math.MaxInt64)
+		if n < float64(limit) {
+			limit = int64(n)
+		}
+		data, err = io.ReadAll(io.LimitReader(req.body, limit))
+	} else {
+		data, err = io.ReadAll(req.body)
+	}
+	req.read.Write(data)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, fmt.Errorf("request body larger than %d bytes", req.max)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return []engine.Value{nil}, nil
+	}
+	return []engine.Value{string(data)}, nil
+}
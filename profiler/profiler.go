@@ -0,0 +1,284 @@
+// Package profiler is a sampling CPU profiler for Lua scripts run with golua.
+//
+// Go's pprof only sees the time spent in lua_pcall. The Profiler samples the
+// Lua call stack with a count hook instead, and writes a profile.proto that
+// 'go tool pprof' reads like any Go profile, with frames such as
+// 'test.lua:square':
+//
+//	p := profiler.New(profiler.DefaultPeriod)
+//	p.Start(L)
+//	L.DoFile("test.lua")
+//	p.Stop(L)
+//	f, _ := os.Create("lua.pprof")
+//	p.WriteProfile(f)
+//
+// A Profiler can sample many states at once, each state must be started on its
+// own.
+package profiler
+
+import (
+	"compress/gzip"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aarzilli/golua/lua"
+)
+
+// DefaultPeriod is the default number of Lua instructions between two samples.
+const DefaultPeriod = 1000
+
+// Profiler aggregates the samples of the states it was started on.
+type Profiler struct {
+	period int
+	start  time.Time
+
+	mu sync.Mutex
+	// Time of the last sample of every started state.
+	clocks  map[*lua.State]time.Time
+	samples map[string]*sample
+}
+
+type frame struct {
+	function string
+	file     string
+	line     int
+}
+
+type sample struct {
+	// Innermost frame first, as in pprof.
+	frames []frame
+	count  int64
+	nanos  int64
+}
+
+// New creates a Profiler taking a sample every 'period' Lua instructions.
+func New(period int) *Profiler {
+	if period <= 0 {
+		period = DefaultPeriod
+	}
+	return &Profiler{
+		period:  period,
+		start:   time.Now(),
+		clocks:  map[*lua.State]time.Time{},
+		samples: map[string]*sample{},
+	}
+}
+
+// Start starts sampling 'L'. It installs a debug hook, which replaces the hook
+// or execution limit set on the state.
+//
+// Every sample is charged the time elapsed since the previous sample of the
+// state, so Start and Stop should surround the executions to profile rather
+// than the whole life of an idle state.
+func (p *Profiler) Start(L *lua.State) {
+	p.mu.Lock()
+	p.clocks[L] = time.Now()
+	p.mu.Unlock()
+	L.SetHook(lua.LUA_MASKCOUNT, p.period, p.hook)
+}
+
+// Stop stops sampling 'L' and removes its debug hook.
+func (p *Profiler) Stop(L *lua.State) {
+	L.SetHook(0, 0, nil)
+	p.mu.Lock()
+	delete(p.clocks, L)
+	p.mu.Unlock()
+}
+
+func (p *Profiler) started(L *lua.State) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.clocks[L]
+	return ok
+}
+
+func (p *Profiler) hook(L *lua.State, ev lua.HookEvent) {
+	p.add(L, luaFrames(L.StackTrace()), 1)
+}
+
+// Wrap returns a Go function that records the time spent in 'f' under a frame
+// named 'go:name', on top of the Lua frames calling it.
+//
+// Without Wrap, the time spent in a Go function is charged to the next sample
+// of the Lua code calling it.
+func (p *Profiler) Wrap(name string, f lua.LuaGoFunction) lua.LuaGoFunction {
+	return func(L *lua.State) int {
+		if !p.started(L) {
+			return f(L)
+		}
+		frames := luaFrames(L.StackTrace())
+		if len(frames) == 0 {
+			return f(L)
+		}
+		// The Lua code ran until this call.
+		p.add(L, frames[1:], 0)
+		frames[0] = frame{function: "go:" + name, file: "[Go]"}
+		defer p.add(L, frames, 0)
+		return f(L)
+	}
+}
+
+func (p *Profiler) add(L *lua.State, frames []frame, count int64) {
+	now := time.Now()
+	key := stackKey(frames)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	last, ok := p.clocks[L]
+	if !ok {
+		return
+	}
+	p.clocks[L] = now
+	s, ok := p.samples[key]
+	if !ok {
+		s = &sample{frames: frames}
+		p.samples[key] = s
+	}
+	s.count += count
+	s.nanos += int64(now.Sub(last))
+}
+
+func luaFrames(stack []lua.LuaStackEntry) []frame {
+	frames := make([]frame, len(stack))
+	for i, e := range stack {
+		name := e.Name
+		if name == "" {
+			name = "?"
+		}
+		file := e.ShortSource
+		if strings.HasPrefix(e.Source, "@") {
+			file = e.Source[1:]
+		}
+		line := e.CurrentLine
+		if line < 0 {
+			line = 0
+		}
+		frames[i] = frame{function: e.ShortSource + ":" + name, file: file, line: line}
+	}
+	return frames
+}
+
+func stackKey(frames []frame) string {
+	var b strings.Builder
+	for _, f := range frames {
+		b.WriteString(f.function)
+		b.WriteByte(0)
+		b.WriteString(f.file)
+		b.WriteByte(0)
+		b.WriteString(strconv.Itoa(f.line))
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// WriteProfile writes the samples collected so far to 'w' in the gzipped
+// profile.proto format.
+//
+// The profile has two sample types: 'samples', the number of count hook
+// samples, and 'time', the time in nanoseconds, which is the default.
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	p.mu.Lock()
+	samples := make([]*sample, 0, len(p.samples))
+	keys := make([]string, 0, len(p.samples))
+	for k := range p.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := *p.samples[k]
+		samples = append(samples, &s)
+	}
+	p.mu.Unlock()
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(encodeProfile(samples, p.period, p.start, time.Now())); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func encodeProfile(samples []*sample, period int, start, end time.Time) []byte {
+	strs := []string{""}
+	strIndex := map[string]int64{"": 0}
+	str := func(s string) int64 {
+		if i, ok := strIndex[s]; ok {
+			return i
+		}
+		strIndex[s] = int64(len(strs))
+		strs = append(strs, s)
+		return strIndex[s]
+	}
+
+	type functionKey struct{ name, file string }
+	type locationKey struct {
+		function uint64
+		line     int
+	}
+	functions := map[functionKey]uint64{}
+	locations := map[locationKey]uint64{}
+
+	var b protoBuffer
+	valueType := func(field int, typ, unit string) {
+		b.message(field, func(m *protoBuffer) {
+			m.int64(valueTypeType, str(typ))
+			m.int64(valueTypeUnit, str(unit))
+		})
+	}
+	valueType(profileSampleType, "samples", "count")
+	valueType(profileSampleType, "time", "nanoseconds")
+
+	var fb, lb protoBuffer
+	for _, s := range samples {
+		ids := make([]uint64, len(s.frames))
+		for i, f := range s.frames {
+			fk := functionKey{f.function, f.file}
+			fid, ok := functions[fk]
+			if !ok {
+				fid = uint64(len(functions) + 1)
+				functions[fk] = fid
+				fb.message(profileFunction, func(m *protoBuffer) {
+					m.uint64(functionID, fid)
+					m.int64(functionName, str(f.function))
+					m.int64(functionSystemName, str(f.function))
+					m.int64(functionFilename, str(f.file))
+				})
+			}
+			lk := locationKey{fid, f.line}
+			lid, ok := locations[lk]
+			if !ok {
+				lid = uint64(len(locations) + 1)
+				locations[lk] = lid
+				lb.message(profileLocation, func(m *protoBuffer) {
+					m.uint64(locationID, lid)
+					m.message(locationLine, func(l *protoBuffer) {
+						l.uint64(lineFunctionID, fid)
+						l.int64(lineLine, int64(f.line))
+					})
+				})
+			}
+			ids[i] = lid
+		}
+		b.message(profileSample, func(m *protoBuffer) {
+			m.packedUint64(sampleLocationID, ids)
+			m.packedInt64(sampleValue, []int64{s.count, s.nanos})
+		})
+	}
+	b.data = append(b.data, lb.data...)
+	b.data = append(b.data, fb.data...)
+
+	b.int64(profileTimeNanos, start.UnixNano())
+	b.int64(profileDurationNanos, int64(end.Sub(start)))
+	valueType(profilePeriodType, "instructions", "count")
+	b.int64(profilePeriod, int64(period))
+	b.int64(profileDefaultSample, str("time"))
+
+	// The string table is complete once everything else is encoded.
+	for _, s := range strs {
+		b.string(profileStringTable, s)
+	}
+	return b.data
+}
@@ -0,0 +1,249 @@
+package profiler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aarzilli/golua/lua"
+)
+
+const script = `
+function square(m)
+	local s = 0
+	for i = 1, m do
+		s = s + m
+	end
+	return s
+end
+
+for i = 1, 2000 do
+	square(100)
+end
+`
+
+// field is a field of a protocol buffer message.
+type field struct {
+	number int
+	varint uint64
+	bytes  []byte
+}
+
+func varint(t *testing.T, data []byte) (uint64, []byte) {
+	var x uint64
+	for shift := uint(0); len(data) > 0; shift += 7 {
+		b := data[0]
+		data = data[1:]
+		x |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return x, data
+		}
+	}
+	t.Fatal("truncated varint")
+	return 0, nil
+}
+
+// fields decodes the fields of a message, which are varints and bytes in a
+// profile.
+func fields(t *testing.T, data []byte) []field {
+	var fs []field
+	for len(data) > 0 {
+		var key, x uint64
+		key, data = varint(t, data)
+		f := field{number: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			f.varint, data = varint(t, data)
+		case 2:
+			x, data = varint(t, data)
+			if x > uint64(len(data)) {
+				t.Fatalf("field %d of %d bytes, %d left", f.number, x, len(data))
+			}
+			f.bytes, data = data[:x], data[x:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fs = append(fs, f)
+	}
+	return fs
+}
+
+func packed(t *testing.T, data []byte) []uint64 {
+	var xs []uint64
+	for len(data) > 0 {
+		var x uint64
+		x, data = varint(t, data)
+		xs = append(xs, x)
+	}
+	return xs
+}
+
+// decodeProfile returns the number of samples of each function of a profile,
+// counted once per sample where it appears.
+// decodeProfile returns the sample value 'value', 0 for the samples and 1 for
+// the time, of the functions of a profile, their callees included.
+func decodeProfile(t *testing.T, data []byte, value int) map[string]int64 {
+	var strs []string
+	functions := map[uint64]uint64{} // function id to name index
+	locations := map[uint64][]uint64{}
+	var samples [][]field
+	for _, f := range fields(t, data) {
+		switch f.number {
+		case profileStringTable:
+			strs = append(strs, string(f.bytes))
+		case profileFunction:
+			var id, name uint64
+			for _, ff := range fields(t, f.bytes) {
+				switch ff.number {
+				case functionID:
+					id = ff.varint
+				case functionName:
+					name = ff.varint
+				}
+			}
+			functions[id] = name
+		case profileLocation:
+			var id uint64
+			var fids []uint64
+			for _, lf := range fields(t, f.bytes) {
+				switch lf.number {
+				case locationID:
+					id = lf.varint
+				case locationLine:
+					for _, l := range fields(t, lf.bytes) {
+						if l.number == lineFunctionID {
+							fids = append(fids, l.varint)
+						}
+					}
+				}
+			}
+			locations[id] = fids
+		case profileSample:
+			samples = append(samples, fields(t, f.bytes))
+		}
+	}
+
+	counts := map[string]int64{}
+	for _, s := range samples {
+		var ids, values []uint64
+		for _, f := range s {
+			switch f.number {
+			case sampleLocationID:
+				ids = packed(t, f.bytes)
+			case sampleValue:
+				values = packed(t, f.bytes)
+			}
+		}
+		if len(values) != 2 {
+			t.Fatalf("got %d values in a sample, want 2", len(values))
+		}
+		seen := map[string]bool{}
+		for _, id := range ids {
+			fids, ok := locations[id]
+			if !ok {
+				t.Fatalf("unknown location %d", id)
+			}
+			for _, fid := range fids {
+				name := strs[functions[fid]]
+				if !seen[name] {
+					seen[name] = true
+					counts[name] += int64(values[value])
+				}
+			}
+		}
+	}
+	return counts
+}
+
+func TestProfile(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	p := New(100)
+	p.Start(L)
+	if L.LoadBuffer([]byte(script), "=test.lua") != 0 {
+		t.Fatal(L.ToString(-1))
+	}
+	if err := L.Call(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	p.Stop(L)
+
+	var buf bytes.Buffer
+	if err := p.WriteProfile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := decodeProfile(t, data, 0)
+	if counts["test.lua:square"] == 0 {
+		t.Errorf("no samples in test.lua:square, got %v", counts)
+	}
+	if counts["test.lua:square"] > counts["test.lua:?"] {
+		t.Errorf("more samples in square than in the main chunk calling it: %v", counts)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	const pause = 20 * time.Millisecond
+	p := New(100)
+	L.Register("work", p.Wrap("work", func(L *lua.State) int {
+		time.Sleep(pause)
+		return 0
+	}))
+	p.Start(L)
+	if L.LoadBuffer([]byte("for i = 1, 3 do work() end"), "=wrap.lua") != 0 {
+		t.Fatal(L.ToString(-1))
+	}
+	if err := L.Call(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	p.Stop(L)
+
+	var buf bytes.Buffer
+	if err := p.WriteProfile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nanos := decodeProfile(t, data, 1)
+	if nanos["go:work"] < int64(3*pause) {
+		t.Errorf("%v in go:work, want at least %v: %v", time.Duration(nanos["go:work"]), 3*pause, nanos)
+	}
+	// the Go frame is on top of the Lua code calling it
+	if nanos["wrap.lua:?"] < nanos["go:work"] {
+		t.Errorf("less time in the main chunk than in the Go function it calls: %v", nanos)
+	}
+}
+
+func TestEncodeProfile(t *testing.T) {
+	main := frame{function: "test.lua:?", file: "test.lua", line: 11}
+	square := frame{function: "test.lua:square", file: "test.lua", line: 5}
+	samples := []*sample{
+		{frames: []frame{square, main}, count: 3, nanos: 300},
+		{frames: []frame{main}, count: 1, nanos: 100},
+	}
+	counts := decodeProfile(t, encodeProfile(samples, 100, time.Unix(0, 0), time.Unix(1, 0)), 0)
+	if counts["test.lua:square"] != 3 || counts["test.lua:?"] != 4 {
+		t.Errorf("got %v", counts)
+	}
+}
@@ -0,0 +1,98 @@
+package profiler
+
+// Minimal protocol buffer encoder for the pprof profile.proto format, see
+// https://github.com/google/pprof/blob/master/proto/profile.proto
+
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protoBuffer) key(field int, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (b *protoBuffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.key(field, 0)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+func (b *protoBuffer) bytes(field int, x []byte) {
+	b.key(field, 2)
+	b.varint(uint64(len(x)))
+	b.data = append(b.data, x...)
+}
+
+func (b *protoBuffer) string(field int, x string) {
+	b.key(field, 2)
+	b.varint(uint64(len(x)))
+	b.data = append(b.data, x...)
+}
+
+func (b *protoBuffer) packedUint64(field int, xs []uint64) {
+	var p protoBuffer
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.bytes(field, p.data)
+}
+
+func (b *protoBuffer) packedInt64(field int, xs []int64) {
+	var p protoBuffer
+	for _, x := range xs {
+		p.varint(uint64(x))
+	}
+	b.bytes(field, p.data)
+}
+
+// message encodes a nested message with 'f'.
+func (b *protoBuffer) message(field int, f func(m *protoBuffer)) {
+	var m protoBuffer
+	f(&m)
+	b.bytes(field, m.data)
+}
+
+// Field numbers of profile.proto.
+const (
+	profileSampleType    = 1
+	profileSample        = 2
+	profileLocation      = 4
+	profileFunction      = 5
+	profileStringTable   = 6
+	profileTimeNanos     = 9
+	profileDurationNanos = 10
+	profilePeriodType    = 11
+	profilePeriod        = 12
+	profileDefaultSample = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+)
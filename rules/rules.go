@@ -0,0 +1,325 @@
+// Package rules evaluates business rules declared in Lua files against Go
+// facts, like the guards of Account:withdrawl:
+//
+//	rule{
+//		name = "overdraft",
+//		priority = 10,
+//		when = function(ctx) return ctx.Amount > ctx.Balance end,
+//		then_ = function(ctx) ctx.Approved = false end,
+//	}
+//
+// The fact is a pointer to a Go struct, a luar proxy on golua-luar and a table
+// with its fields on the other engines, written back to the struct after each
+// then_. Its fields are named after the Go fields, as luar names them:
+//
+//	set, err := rules.Load(rules.Options{Timeout: 100 * time.Millisecond}, "withdrawl.lua")
+//	defer set.Close()
+//	res, err := set.Eval(ctx, &Withdrawl{Balance: 100, Amount: 500, Approved: true})
+//
+// The rules are evaluated in order of decreasing priority, then in the order
+// of their declaration, each then_ seeing the changes of the rules fired
+// before it. A then_ which fails changes nothing: the fields it set before the
+// error are restored on every engine.
+package rules
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+// Options configures a Set.
+type Options struct {
+	// Engine is the Lua implementation, "golua-luar" when empty.
+	Engine string
+	// Options of the states, with the Modules the rules use.
+	engine.Options
+	// PoolSize is the number of idle states kept for the evaluations, 1 when
+	// 0.
+	PoolSize int
+	// Timeout is the time the when and then_ functions of each rule can run,
+	// no limit when 0.
+	Timeout time.Duration
+}
+
+// Rule is a rule declared by a Lua file.
+type Rule struct {
+	Name     string
+	Priority float64
+	// File is the file declaring the rule.
+	File string
+
+	// index is the index of the rule in __rules.
+	index int
+}
+
+// Result is the outcome of the evaluation of a fact.
+type Result struct {
+	// Fired are the names of the rules whose when returned true, in order.
+	Fired []string
+	// Changes are the changes of the fields of the fact by the then_ of the
+	// fired rules, those which failed excepted.
+	Changes []Change
+	// Errors are the errors of the rules, which do not stop the evaluation.
+	Errors []*Error
+}
+
+// Change is a field of a fact changed by a rule. Old and New are plain Go
+// values, converted like engine.ToGo does.
+type Change struct {
+	Rule  string
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+// Error is the error of a rule.
+type Error struct {
+	Rule string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rule %q: %v", e.Rule, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// rulesLua declares the rule function, recording the rules in __rules, and the
+// functions Eval calls.
+const rulesLua = `
+__rules = {}
+local names = {}
+local fields = {name = true, priority = true, when = true, then_ = true}
+
+function rule(r)
+	if type(r) ~= "table" then
+		error("rule expects a table, got " .. type(r), 2)
+	end
+	for k in pairs(r) do
+		if not fields[k] then
+			error("unknown field '" .. tostring(k) .. "' of rule", 2)
+		end
+	end
+	if type(r.name) ~= "string" or r.name == "" then
+		error("rule without a name", 2)
+	end
+	if names[r.name] then
+		error("duplicate rule '" .. r.name .. "'", 2)
+	end
+	if type(r.when) ~= "function" then
+		error("rule '" .. r.name .. "' without a when function", 2)
+	end
+	if r.then_ ~= nil and type(r.then_) ~= "function" then
+		error("the then_ of rule '" .. r.name .. "' is not a function", 2)
+	end
+	if r.priority ~= nil and type(r.priority) ~= "number" then
+		error("the priority of rule '" .. r.name .. "' is not a number", 2)
+	end
+	names[r.name] = true
+	__rules[#__rules + 1] = {name = r.name, priority = r.priority or 0, file = __rules_file, when = r.when, then_ = r.then_}
+end
+
+function __rules_when(i, ctx)
+	return __rules[i].when(ctx)
+end
+
+function __rules_then(i, ctx)
+	local r = __rules[i]
+	if r.then_ then
+		r.then_(ctx)
+	end
+	return ctx
+end
+`
+
+// listLua returns the rules of __rules.
+const listLua = `(function()
+	local list = {}
+	for i, r in ipairs(__rules) do
+		list[i] = {name = r.name, priority = r.priority, file = r.file}
+	end
+	return list
+end)()`
+
+// Set is the rules of Lua files, loaded in a pool of states. It is safe for
+// concurrent use.
+type Set struct {
+	opts  Options
+	pool  *engine.Pool
+	rules []Rule
+}
+
+// Load loads the rules declared by the Lua files 'paths'.
+func Load(opts Options, paths ...string) (*Set, error) {
+	if opts.Engine == "" {
+		opts.Engine = "golua-luar"
+	}
+	pool, err := engine.NewPool(opts.Engine, opts.Options, opts.PoolSize, func(e engine.Engine) error {
+		if err := e.DoString(rulesLua, "=rules"); err != nil {
+			return err
+		}
+		for _, path := range paths {
+			if err := e.SetGlobal("__rules_file", path); err != nil {
+				return err
+			}
+			if err := e.DoFile(path); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := &Set{opts: opts, pool: pool}
+	if err := s.list(); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// list reads the rules of a state and sorts them.
+func (s *Set) list() error {
+	e, err := s.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer s.pool.Put(e)
+	values, err := e.Eval(listLua)
+	if err != nil {
+		return err
+	}
+	list, ok := values[0].(*engine.Table)
+	if !ok {
+		return fmt.Errorf("rules: the rules are a %s", engine.TypeName(values[0]))
+	}
+	for i, item := range list.Array {
+		r, _ := item.(*engine.Table)
+		if r == nil {
+			return fmt.Errorf("rules: rule %d is a %s", i+1, engine.TypeName(item))
+		}
+		name, _ := r.Get("name").(string)
+		priority, _ := r.Get("priority").(float64)
+		file, _ := r.Get("file").(string)
+		s.rules = append(s.rules, Rule{Name: name, Priority: priority, File: file, index: i + 1})
+	}
+	sort.SliceStable(s.rules, func(i, j int) bool {
+		return s.rules[i].Priority > s.rules[j].Priority
+	})
+	return nil
+}
+
+// Rules returns the rules in the order of evaluation.
+func (s *Set) Rules() []Rule {
+	return append([]Rule(nil), s.rules...)
+}
+
+// Eval evaluates the rules against 'fact', a pointer to a struct, which the
+// fired rules change. The evaluation stops with the error of 'ctx' when it is
+// done, returning the result so far.
+func (s *Set) Eval(ctx context.Context, fact interface{}) (*Result, error) {
+	if v := reflect.ValueOf(fact); v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("rules: the fact must be a pointer to a struct, got %T", fact)
+	}
+	e, err := s.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer s.pool.Put(e)
+
+	res := &Result{}
+	for _, r := range s.rules {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		fired, changes, err := s.eval(ctx, e, r, fact)
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if fired {
+			res.Fired = append(res.Fired, r.Name)
+			res.Changes = append(res.Changes, changes...)
+		}
+		if err != nil {
+			res.Errors = append(res.Errors, &Error{Rule: r.Name, Err: err})
+		}
+	}
+	return res, nil
+}
+
+// eval evaluates the rule 'r' on 'e', within its timeout.
+func (s *Set) eval(ctx context.Context, e engine.Engine, r Rule, fact interface{}) (fired bool, changes []Change, err error) {
+	if s.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.Timeout)
+		defer cancel()
+	}
+	e.SetContext(ctx)
+	defer e.SetContext(nil)
+
+	results, err := e.Call("__rules_when", float64(r.index), fact)
+	if err != nil || !truth(results) {
+		return false, nil, err
+	}
+	before := fields(fact)
+	// luar proxies change the fact in place, even when then_ fails
+	v := reflect.ValueOf(fact).Elem()
+	saved := reflect.New(v.Type()).Elem()
+	saved.Set(v)
+	results, err = e.Call("__rules_then", float64(r.index), fact)
+	if err == nil && len(results) > 0 {
+		// the engines without luar return a copy of the fact
+		if t, ok := results[0].(*engine.Table); ok {
+			err = engine.FromValue(t, fact)
+		}
+	}
+	if err != nil {
+		v.Set(saved)
+		return true, nil, err
+	}
+	after := fields(fact)
+	for _, name := range changed(before, after) {
+		changes = append(changes, Change{Rule: r.Name, Field: name, Old: before[name], New: after[name]})
+	}
+	return true, changes, nil
+}
+
+// truth reports whether the first of 'results' is true for Lua.
+func truth(results []engine.Value) bool {
+	if len(results) == 0 {
+		return false
+	}
+	b, ok := results[0].(bool)
+	return results[0] != nil && (!ok || b)
+}
+
+// fields returns the fields of the struct 'fact' points to, as plain Go values.
+func fields(fact interface{}) map[string]interface{} {
+	m, _ := engine.ToGo(engine.ToValue(fact)).(map[string]interface{})
+	return m
+}
+
+// changed returns the names of the fields which differ, sorted.
+func changed(before, after map[string]interface{}) []string {
+	var names []string
+	for name, v := range after {
+		if !reflect.DeepEqual(before[name], v) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close closes the states of the set.
+func (s *Set) Close() {
+	s.pool.Close()
+}
@@ -0,0 +1,190 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+)
+
+type withdrawl struct {
+	Balance  float64
+	Amount   float64
+	Approved bool
+	Reason   string
+}
+
+const withdrawlLua = `
+rule{
+	name = "approve",
+	when = function(ctx) return ctx.Amount > 0 end,
+	then_ = function(ctx) ctx.Approved = true end,
+}
+
+rule{
+	name = "overdraft",
+	priority = 10,
+	when = function(ctx) return ctx.Amount > ctx.Balance end,
+	then_ = function(ctx)
+		ctx.Approved = false
+		ctx.Reason = "overdraft"
+	end,
+}
+
+rule{
+	name = "large",
+	priority = 10,
+	when = function(ctx) return ctx.Amount >= 1000 end,
+}
+
+rule{
+	name = "broken",
+	priority = -1,
+	when = function(ctx) return ctx.Amount.x end,
+}
+
+rule{
+	name = "slow",
+	priority = -2,
+	when = function(ctx)
+		while true do end
+	end,
+}
+`
+
+func writeFile(t *testing.T, name, code string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(code), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEval(t *testing.T) {
+	path := writeFile(t, "withdrawl.lua", withdrawlLua)
+	for _, name := range engine.Names() {
+		t.Run(name, func(t *testing.T) {
+			set, err := Load(Options{Engine: name, Timeout: 50 * time.Millisecond}, path)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			defer set.Close()
+
+			var order []string
+			for _, r := range set.Rules() {
+				order = append(order, r.Name)
+			}
+			if want := "overdraft large approve broken slow"; strings.Join(order, " ") != want {
+				t.Errorf("%s: order %v, want %s", name, order, want)
+			}
+
+			// the second evaluation reuses the state the slow rule timed out on
+			for round := 1; round <= 2; round++ {
+				fact := &withdrawl{Balance: 100, Amount: 500}
+				res, err := set.Eval(context.Background(), fact)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if want := []string{"overdraft", "approve"}; !reflect.DeepEqual(res.Fired, want) {
+					t.Errorf("%s: fired %v, want %v", name, res.Fired, want)
+				}
+				want := []Change{
+					{Rule: "overdraft", Field: "Reason", Old: "", New: "overdraft"},
+					{Rule: "approve", Field: "Approved", Old: false, New: true},
+				}
+				if !reflect.DeepEqual(res.Changes, want) {
+					t.Errorf("%s: changes %+v, want %+v", name, res.Changes, want)
+				}
+				if *fact != (withdrawl{Balance: 100, Amount: 500, Approved: true, Reason: "overdraft"}) {
+					t.Errorf("%s: fact %+v", name, fact)
+				}
+				if len(res.Errors) != 2 || res.Errors[0].Rule != "broken" || res.Errors[1].Rule != "slow" {
+					t.Fatalf("%s: errors %v", name, res.Errors)
+				}
+				var lerr *engine.Error
+				if !errors.As(res.Errors[1], &lerr) || lerr.Kind != engine.LimitError {
+					t.Errorf("%s: slow rule failed with %v in evaluation %d", name, res.Errors[1], round)
+				}
+			}
+		})
+	}
+}
+
+// TestThenError checks that a then_ failing after setting fields leaves the
+// fact unchanged on every engine.
+func TestThenError(t *testing.T) {
+	path := writeFile(t, "partial.lua", `
+rule{
+	name = "partial",
+	when = function(ctx) return true end,
+	then_ = function(ctx)
+		ctx.Reason = "partial"
+		ctx.Approved = true
+		error("stop")
+	end,
+}
+`)
+	for _, name := range engine.Names() {
+		set, err := Load(Options{Engine: name}, path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		fact := &withdrawl{Balance: 100, Amount: 5}
+		res, err := set.Eval(context.Background(), fact)
+		set.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if *fact != (withdrawl{Balance: 100, Amount: 5}) {
+			t.Errorf("%s: fact %+v", name, fact)
+		}
+		if !reflect.DeepEqual(res.Fired, []string{"partial"}) || len(res.Changes) != 0 {
+			t.Errorf("%s: fired %v, changes %+v", name, res.Fired, res.Changes)
+		}
+		if len(res.Errors) != 1 || !strings.Contains(res.Errors[0].Error(), "stop") {
+			t.Errorf("%s: errors %v", name, res.Errors)
+		}
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	path := writeFile(t, "withdrawl.lua", withdrawlLua)
+	for _, name := range engine.Names() {
+		t.Run(name, func(t *testing.T) {
+			set, err := Load(Options{Engine: name}, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer set.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			res, err := set.Eval(ctx, &withdrawl{Amount: 1})
+			if !errors.Is(err, context.DeadlineExceeded) || len(res.Fired) != 2 {
+				t.Errorf("got %v, %+v", err, res)
+			}
+			if _, err := set.Eval(context.Background(), withdrawl{}); err == nil {
+				t.Error("evaluated a struct which is not a pointer")
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for code, want := range map[string]string{
+		`rule{name = "a", when = function() end} rule{name = "a", when = function() end}`: "duplicate rule 'a'",
+		`rule{name = "a"}`: "rule 'a' without a when function",
+		`rule{name = "a", when = function() end, ["then"] = function() end}`: "unknown field 'then' of rule",
+		`rule{name = "a", when = function() end, priority = "high"}`:         "the priority of rule 'a' is not a number",
+	} {
+		_, err := Load(Options{Engine: "gopher-lua"}, writeFile(t, "rules.lua", code))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %s", code, err, want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Package tracing records spans in the manner of OpenTelemetry: a Tracer
+// starts spans which are children of the span of their context, and exports
+// them once ended.
+//
+// The engine package creates a span for each call into a state and each Go
+// function the scripts call, given a Tracer in its Options:
+//
+//	exporter := tracing.NewInMemoryExporter()
+//	tracer := tracing.NewTracer(exporter)
+//	e, _ := engine.New("golua", engine.Options{Tracer: tracer})
+//	ctx, span := tracer.Start(ctx, "request")
+//	e.SetContext(ctx)
+//	e.Call("account_test")
+//	span.End()
+//	for _, s := range exporter.Spans() {
+//		fmt.Println(s.Name, s.Attributes())
+//	}
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace, the tree of spans of a request.
+type TraceID [16]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanID identifies a span.
+type SpanID [8]byte
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the ID is not zero, the parent of the root spans.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// Status is the outcome of a span.
+type Status int
+
+const (
+	// StatusUnset is the status of the spans which did not fail.
+	StatusUnset Status = iota
+	// StatusError is the status of the spans which failed.
+	StatusError
+)
+
+func (s Status) String() string {
+	if s == StatusError {
+		return "error"
+	}
+	return "unset"
+}
+
+// Span is an operation of a trace.
+type Span struct {
+	Name     string
+	TraceID  TraceID
+	SpanID   SpanID
+	ParentID SpanID
+	// StartTime and EndTime are the times the span started and ended.
+	StartTime time.Time
+	EndTime   time.Time
+
+	mu            sync.Mutex
+	attributes    map[string]string
+	status        Status
+	statusMessage string
+
+	tracer *Tracer
+}
+
+// SetAttribute sets an attribute of the span.
+func (s *Span) SetAttribute(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = map[string]string{}
+	}
+	s.attributes[key] = value
+}
+
+// Attributes returns a copy of the attributes of the span.
+func (s *Span) Attributes() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attrs := make(map[string]string, len(s.attributes))
+	for k, v := range s.attributes {
+		attrs[k] = v
+	}
+	return attrs
+}
+
+// SetError marks the span as failed with 'err'.
+func (s *Span) SetError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = StatusError
+	s.statusMessage = err.Error()
+}
+
+// Status returns the status of the span and its message.
+func (s *Span) Status() (Status, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status, s.statusMessage
+}
+
+// End ends the span and exports it.
+func (s *Span) End() {
+	s.EndTime = time.Now()
+	s.tracer.exporter.ExportSpan(s)
+}
+
+// Exporter receives the ended spans.
+type Exporter interface {
+	ExportSpan(s *Span)
+}
+
+// InMemoryExporter keeps the spans in memory, for tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// NewInMemoryExporter returns an empty InMemoryExporter.
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+// ExportSpan implements Exporter.
+func (e *InMemoryExporter) ExportSpan(s *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, s)
+}
+
+// Spans returns the spans exported so far, in the order they ended.
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+// Reset removes the exported spans.
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// Tracer starts spans exported to its Exporter.
+type Tracer struct {
+	exporter Exporter
+}
+
+// NewTracer returns a Tracer exporting its spans to 'exporter'.
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Start starts a span, child of the span of 'ctx' if any, and returns a
+// context holding it.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	s := &Span{Name: name, StartTime: time.Now(), tracer: t}
+	if parent := SpanFromContext(ctx); parent != nil {
+		s.TraceID = parent.TraceID
+		s.ParentID = parent.SpanID
+	} else {
+		rand.Read(s.TraceID[:])
+	}
+	rand.Read(s.SpanID[:])
+	return ContextWithSpan(ctx, s), s
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a context holding 's'.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// SpanFromContext returns the span of 'ctx', nil if none.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
@@ -0,0 +1,123 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rickcrawford/go-lua-test/engine"
+	"github.com/rickcrawford/go-lua-test/tracing"
+)
+
+const script = `function test_go_string(fn, val)
+	local str = fn(val)
+	return "Type: " .. str
+end
+
+function fail()
+	return check(-1)
+end
+`
+
+func ourSimpleFn(args []engine.Value) ([]engine.Value, error) {
+	return []engine.Value{engine.TypeName(args[0])}, nil
+}
+
+var checkModule = &engine.Module{Funcs: map[string]engine.Function{
+	"check": func(args []engine.Value) ([]engine.Value, error) {
+		return nil, errors.New("negative amount")
+	},
+}}
+
+func TestEngineSpans(t *testing.T) {
+	for _, name := range engine.Names() {
+		t.Run(name, func(t *testing.T) {
+			exporter := tracing.NewInMemoryExporter()
+			tracer := tracing.NewTracer(exporter)
+			e, err := engine.New(name, engine.Options{Modules: []*engine.Module{checkModule}, Tracer: tracer})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer e.Close()
+			if err := e.DoString(script, "=test"); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, request := tracer.Start(context.Background(), "request")
+			e.SetContext(ctx)
+			if _, err := e.Call("test_go_string", engine.Function(ourSimpleFn), "Hello"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := e.Call("fail"); err == nil {
+				t.Fatal("fail did not fail")
+			}
+			request.End()
+
+			spans := exporter.Spans()
+			if len(spans) != 6 {
+				t.Fatalf("got %d spans, want 6", len(spans))
+			}
+			load, callback, call, check, failed := spans[0], spans[1], spans[2], spans[3], spans[4]
+
+			if load.Name != "lua.DoString" || load.Attributes()["lua.source"] != "=test" || load.ParentID.IsValid() {
+				t.Errorf("DoString span %s %v, parent %s", load.Name, load.Attributes(), load.ParentID)
+			}
+
+			if call.Name != "lua.Call" || call.Attributes()["lua.function"] != "test_go_string" {
+				t.Errorf("Call span %s %v", call.Name, call.Attributes())
+			}
+			if call.TraceID != request.TraceID || call.ParentID != request.SpanID {
+				t.Errorf("Call span is not a child of the request span")
+			}
+
+			attrs := callback.Attributes()
+			if callback.Name != "go.Function" || !strings.HasSuffix(attrs["go.function"], ".ourSimpleFn") || attrs["lua.source"] != "test:2" {
+				t.Errorf("callback span %s %v", callback.Name, attrs)
+			}
+			if callback.TraceID != request.TraceID || callback.ParentID != call.SpanID {
+				t.Errorf("callback span is not a child of the Call span")
+			}
+			if status, _ := callback.Status(); status != tracing.StatusUnset {
+				t.Errorf("callback span status %s", status)
+			}
+
+			if check.Attributes()["go.function"] != "check" || check.ParentID != failed.SpanID {
+				t.Errorf("check span %v", check.Attributes())
+			}
+			for _, s := range []*tracing.Span{check, failed} {
+				if status, msg := s.Status(); status != tracing.StatusError || !strings.Contains(msg, "negative amount") {
+					t.Errorf("%s span status %s %q", s.Name, status, msg)
+				}
+			}
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	tracer := tracing.NewTracer(exporter)
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	if tracing.SpanFromContext(ctx) != parent {
+		t.Fatal("SpanFromContext did not return the started span")
+	}
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttribute("k", "v")
+	child.End()
+	parent.End()
+
+	spans := exporter.Spans()
+	if len(spans) != 2 || spans[0] != child || spans[1] != parent {
+		t.Fatalf("spans %v", spans)
+	}
+	if child.TraceID != parent.TraceID || child.ParentID != parent.SpanID || parent.ParentID.IsValid() {
+		t.Errorf("child %s/%s, parent %s/%s", child.TraceID, child.ParentID, parent.TraceID, parent.SpanID)
+	}
+	if child.EndTime.Before(child.StartTime) {
+		t.Errorf("child ended before it started")
+	}
+	exporter.Reset()
+	if len(exporter.Spans()) != 0 {
+		t.Errorf("spans after Reset")
+	}
+}